@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/spf13/cobra"
+)
+
+// NewGenesisCmd returns the command that validates the genesis file and prints
+// the hash of its genesis block. Nodes of the same network must print the same
+// hash.
+func NewGenesisCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "genesis",
+		Short: "Validate the genesis file and show its genesis block",
+		RunE:  showGenesis,
+	}
+}
+
+func showGenesis(cmd *cobra.Command, args []string) error {
+	genesis, err := state.ReadGenesis(config.Eth.Genesis)
+	if err != nil {
+		return err
+	}
+
+	block, err := genesis.ToBlock()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("File:      %s\n", config.Eth.Genesis)
+	fmt.Printf("Hash:      %s\n", block.Hash().Hex())
+	fmt.Printf("Root:      %s\n", block.Root().Hex())
+	fmt.Printf("Accounts:  %d\n", len(genesis.Alloc))
+	fmt.Printf("Gas limit: %d\n", block.GasLimit())
+	fmt.Printf("Timestamp: %v\n", block.Time())
+	if genesis.Config != nil && genesis.Config.ChainID != nil {
+		fmt.Printf("Chain ID:  %v\n", genesis.Config.ChainID)
+	}
+
+	return nil
+}
@@ -17,6 +17,15 @@ func AddRaftFlags(cmd *cobra.Command) {
 	cmd.Flags().String("raft.snapshot-dir", config.Raft.SnapshotDir, "Snapshot directory")
 	cmd.Flags().String("raft.node-addr", config.Raft.NodeAddr, "IP:PORT of Raft node")
 	cmd.Flags().String("raft.server-id", string(config.Raft.LocalID), "Unique ID of this server")
+	cmd.Flags().String("raft.store", config.Raft.Store, "Storage of the Raft log: leveldb or inmem")
+	cmd.Flags().Uint64("raft.snapshot_threshold", config.Raft.SnapshotThreshold, "Number of log entries that triggers a snapshot")
+	cmd.Flags().Duration("raft.snapshot_interval", config.Raft.SnapshotInterval, "How often to check whether a snapshot is due")
+	cmd.Flags().Uint64("raft.trailing_logs", config.Raft.TrailingLogs, "Number of log entries kept after a snapshot")
+	cmd.Flags().Bool("raft.shutdown_on_remove", config.Raft.ShutdownOnRemove, "Shut down when removed from, or demoted in, the cluster")
+	cmd.Flags().Int("raft.block-max-txs", config.Raft.BlockMaxTxs, "Maximum number of transactions in a block")
+	cmd.Flags().Int("raft.block-max-bytes", config.Raft.BlockMaxBytes, "Maximum size of the transactions in a block")
+	cmd.Flags().Duration("raft.block-timeout", config.Raft.BlockTimeout, "Time after its first transaction at which a block is appended")
+	cmd.Flags().String("raft.admin-addr", config.Raft.AdminAddr, "IP:PORT of the membership admin API (empty to disable)")
 
 	viper.BindPFlags(cmd.Flags())
 }
@@ -40,6 +49,7 @@ func NewRaftCmd() *cobra.Command {
 	}
 
 	AddRaftFlags(cmd)
+	AddRaftAdminCmds(cmd)
 
 	return cmd
 }
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bear987978897/evm-lite/src/consensus/raft"
+	"github.com/spf13/cobra"
+)
+
+//AddRaftAdminCmds adds the commands that manage the membership of a running
+//Raft cluster through the admin API of one of its nodes
+func AddRaftAdminCmds(cmd *cobra.Command) {
+	cmd.AddCommand(
+		newRaftConfigurationCmd(),
+		newRaftChangeCmd(raft.AddVoter, "Add a voting server to the cluster", true),
+		newRaftChangeCmd(raft.AddNonvoter, "Add a server that replicates the log without voting", true),
+		newRaftChangeCmd(raft.DemoteVoter, "Take the vote away from a server", false),
+		newRaftChangeCmd(raft.RemoveServer, "Remove a server from the cluster", false),
+	)
+}
+
+func newRaftConfigurationCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "configuration",
+		Short: "Show the members of the Raft cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return raftAdminRequest(cmd, "GET", "configuration", nil)
+		},
+	}
+	addRaftAPIFlag(cmd)
+	return cmd
+}
+
+func newRaftChangeCmd(op, short string, withAddress bool) *cobra.Command {
+	change := raft.ConfigurationChange{Op: op}
+
+	cmd := &cobra.Command{
+		Use:   op,
+		Short: short,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := change.Validate(); err != nil {
+				return err
+			}
+			return raftAdminRequest(cmd, "POST", op, change)
+		},
+	}
+	addRaftAPIFlag(cmd)
+	cmd.Flags().StringVar(&change.ID, "id", "", "ID of the server")
+	if withAddress {
+		cmd.Flags().StringVar(&change.Address, "address", "", "Raft address (IP:PORT) of the server")
+	}
+	return cmd
+}
+
+func addRaftAPIFlag(cmd *cobra.Command) {
+	cmd.Flags().String("api", "", "Admin API address of a node of the cluster (default: raft.admin-addr)")
+}
+
+//raftAdminRequest calls a Raft admin endpoint and prints the configuration it
+//returns
+func raftAdminRequest(cmd *cobra.Command, method, endpoint string, body interface{}) error {
+	api, err := cmd.Flags().GetString("api")
+	if err != nil {
+		return err
+	}
+	if api == "" {
+		if config.Raft.AdminAddr == "" {
+			return fmt.Errorf("The admin API is disabled: set --api or raft.admin-addr")
+		}
+		api = config.Raft.AdminAddr
+		if host, port, err := net.SplitHostPort(api); err == nil && host == "" {
+			api = net.JoinHostPort("localhost", port)
+		}
+	}
+	if !strings.HasPrefix(api, "http://") && !strings.HasPrefix(api, "https://") {
+		api = "http://" + api
+	}
+
+	var data []byte
+	if body != nil {
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/raft/%s", api, endpoint), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	res, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(res)))
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, res, "", "  "); err != nil {
+		return err
+	}
+	out.WriteTo(os.Stdout)
+	fmt.Println()
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bear987978897/evm-lite/src/consensus/raft"
+	"github.com/spf13/cobra"
+)
+
+//adminRequest is a request received by a fake admin API
+type adminRequest struct {
+	method string
+	path   string
+	change raft.ConfigurationChange
+}
+
+func TestRaftAdminCmds(t *testing.T) {
+	var requests []adminRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := adminRequest{method: r.Method, path: r.URL.Path}
+		if r.Method == "POST" {
+			if err := json.NewDecoder(r.Body).Decode(&req.change); err != nil {
+				t.Error(err)
+			}
+		}
+		requests = append(requests, req)
+
+		if req.change.ID == "unknown" {
+			http.Error(w, "unknown server", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[{"id": "node1", "address": "127.0.0.1:1337", "suffrage": "Voter", "leader": true}]`))
+	}))
+	defer server.Close()
+
+	run := func(args ...string) error {
+		cmd := &cobra.Command{Use: "raft"}
+		AddRaftAdminCmds(cmd)
+		cmd.SetArgs(append(args, "--api", server.URL))
+		cmd.SilenceUsage = true
+		return cmd.Execute()
+	}
+
+	if err := run("configuration"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(raft.AddVoter, "--id", "node4", "--address", "127.0.0.1:1338"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(raft.RemoveServer, "--id", "node2"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []adminRequest{
+		{method: "GET", path: "/raft/configuration"},
+		{method: "POST", path: "/raft/add-voter", change: raft.ConfigurationChange{Op: raft.AddVoter, ID: "node4", Address: "127.0.0.1:1338"}},
+		{method: "POST", path: "/raft/remove", change: raft.ConfigurationChange{Op: raft.RemoveServer, ID: "node2"}},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("Admin API should get %d requests, not %v", len(want), requests)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Fatalf("Request %d should be %v, not %v", i, want[i], requests[i])
+		}
+	}
+
+	// Changes without their arguments are not sent
+	if err := run(raft.DemoteVoter); err == nil {
+		t.Fatal("Demotion without id should fail")
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("Invalid change should not be sent, got %v", requests[len(want):])
+	}
+
+	// Errors of the admin API are reported with its status
+	err := run(raft.DemoteVoter, "--id", "unknown")
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "unknown server") {
+		t.Fatalf("Refused change should fail with the status of the API, not %v", err)
+	}
+}
@@ -51,11 +51,17 @@ func init() {
 
 	//Eth
 	RootCmd.PersistentFlags().String("eth.genesis", config.Eth.Genesis, "Location of genesis file")
+	RootCmd.PersistentFlags().Uint64("eth.chain-id", config.Eth.ChainID, "Chain ID of the network (0: the chain ID of the genesis file, or 1)")
 	RootCmd.PersistentFlags().String("eth.keystore", config.Eth.Keystore, "Location of Ethereum account keys")
 	RootCmd.PersistentFlags().String("eth.pwd", config.Eth.PwdFile, "Password file to unlock accounts")
 	RootCmd.PersistentFlags().String("eth.db", config.Eth.DbFile, "Eth database file")
 	RootCmd.PersistentFlags().String("eth.listen", config.Eth.EthAPIAddr, "Address of HTTP API service")
 	RootCmd.PersistentFlags().Int("eth.cache", config.Eth.Cache, "Megabytes of memory allocated to internal caching (min 16MB / database forced)")
+	RootCmd.PersistentFlags().Uint64("eth.txpool-pricebump", config.Eth.PriceBump, "Minimum gas price bump (%) to replace a queued transaction")
+	RootCmd.PersistentFlags().Uint64("eth.txpool-accountslots", config.Eth.AccountSlots, "Maximum number of pending transactions per account")
+	RootCmd.PersistentFlags().Uint64("eth.txpool-globalslots", config.Eth.GlobalSlots, "Maximum number of pending transactions")
+	RootCmd.PersistentFlags().Uint64("eth.txpool-accountqueue", config.Eth.AccountQueue, "Maximum number of queued transactions per account")
+	RootCmd.PersistentFlags().Uint64("eth.txpool-globalqueue", config.Eth.GlobalQueue, "Maximum number of queued transactions")
 
 }
 
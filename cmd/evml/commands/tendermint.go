@@ -15,6 +15,9 @@ import (
 // AddTendermintFlags adds flags to the Tendermint command
 func AddTendermintFlags(cmd *cobra.Command) {
 	// cmd.Flags().String("tendermint.home", config.Tendermint.DataDir, "Tendermint home directory")
+	cmd.Flags().String("tendermint.metrics-file", config.Tendermint.MetricsFile, "File to which block metrics are appended (disabled if empty)")
+	cmd.Flags().String("tendermint.metrics-format", config.Tendermint.MetricsFormat, "Format of block metrics: csv, jsonl or none")
+	cmd.Flags().String("tendermint.staking-contract", config.Tendermint.StakingContract, "Address of the contract whose ValidatorUpdate events update the validator set")
 }
 
 // Viber load config
@@ -13,6 +13,7 @@ func main() {
 		cmd.NewBabbleCmd(),
 		cmd.NewRaftCmd(),
 		cmd.NewTendermintCmd(),
+		cmd.NewGenesisCmd(),
 		cmd.VersionCmd)
 
 	//Do not print usage when error occurs
@@ -1,7 +0,0 @@
-package common
-
-type AccountMap map[string]struct {
-	Code    string
-	Storage map[string]string
-	Balance string
-}
@@ -10,6 +10,11 @@ var (
 	defaultGenesisFile  = fmt.Sprintf("%s/genesis.json", defaultEthDir)
 	defaultPwdFile      = fmt.Sprintf("%s/pwd.txt", defaultEthDir)
 	defaultDbFile       = fmt.Sprintf("%s/chaindata", defaultEthDir)
+	defaultPriceBump    = uint64(10)
+	defaultAccountSlots = uint64(1024)
+	defaultGlobalSlots  = uint64(16384)
+	defaultAccountQueue = uint64(64)
+	defaultGlobalQueue  = uint64(1024)
 )
 
 // EthConfig contains the configuration relative to the accounts, EVM, trie/db,
@@ -19,6 +24,10 @@ type EthConfig struct {
 	// Genesis file
 	Genesis string `mapstructure:"genesis"`
 
+	// Chain ID used to sign and verify transactions. If it is 0, the chain ID
+	// is the one of the genesis file, or 1.
+	ChainID uint64 `mapstructure:"chain-id"`
+
 	// Location of ethereum account keys
 	Keystore string `mapstructure:"keystore"`
 
@@ -33,17 +42,37 @@ type EthConfig struct {
 
 	// Megabytes of memory allocated to internal caching (min 16MB / database forced)
 	Cache int `mapstructure:"cache"`
+
+	// Minimum gas price bump (%) to replace a queued transaction
+	PriceBump uint64 `mapstructure:"txpool-pricebump"`
+
+	// Maximum number of pending transactions per account
+	AccountSlots uint64 `mapstructure:"txpool-accountslots"`
+
+	// Maximum number of pending transactions
+	GlobalSlots uint64 `mapstructure:"txpool-globalslots"`
+
+	// Maximum number of queued transactions per account
+	AccountQueue uint64 `mapstructure:"txpool-accountqueue"`
+
+	// Maximum number of queued transactions
+	GlobalQueue uint64 `mapstructure:"txpool-globalqueue"`
 }
 
 // DefaultEthConfig return the default configuration for Eth services
 func DefaultEthConfig() *EthConfig {
 	return &EthConfig{
-		Genesis:    defaultGenesisFile,
-		Keystore:   defaultKeystoreFile,
-		PwdFile:    defaultPwdFile,
-		DbFile:     defaultDbFile,
-		EthAPIAddr: defaultEthAPIAddr,
-		Cache:      defaultCache,
+		Genesis:      defaultGenesisFile,
+		Keystore:     defaultKeystoreFile,
+		PwdFile:      defaultPwdFile,
+		DbFile:       defaultDbFile,
+		EthAPIAddr:   defaultEthAPIAddr,
+		Cache:        defaultCache,
+		PriceBump:    defaultPriceBump,
+		AccountSlots: defaultAccountSlots,
+		GlobalSlots:  defaultGlobalSlots,
+		AccountQueue: defaultAccountQueue,
+		GlobalQueue:  defaultGlobalQueue,
 	}
 }
 
@@ -11,6 +11,8 @@ var (
 	defaultRaftDir     = fmt.Sprintf("%s/raft", DefaultDataDir)
 	defaultSnapshotDir = fmt.Sprintf("%s/snapshots", defaultRaftDir)
 	defaultRaftID      = defaultNodeAddr
+	defaultRaftStore   = "leveldb"
+	defaultAdminAddr   = "127.0.0.1:8090"
 )
 
 // RaftConfig contains the configuration of a Raft node
@@ -49,6 +51,7 @@ type RaftConfig struct {
 	// local node, then we forget all peers and transition into the follower state.
 	// If ShutdownOnRemove is is set, we additional shutdown Raft. Otherwise,
 	// we can become a leader of a cluster containing only this node.
+	// Demoted nodes are shut down too.
 	ShutdownOnRemove bool `mapstructure:"shutdown_on_remove"`
 
 	// TrailingLogs controls how many logs we leave after a snapshot. This is
@@ -83,6 +86,25 @@ type RaftConfig struct {
 
 	/*------------------------------------------------------------------------*/
 
+	// Store selects where the Raft log, term and vote are kept: "leveldb" (on
+	// disk, under RaftDir) or "inmem". A node with an in-memory store forgets
+	// them when it restarts, which is only safe for testing.
+	Store string `mapstructure:"store"`
+
+	// BlockMaxTxs, BlockMaxBytes and BlockTimeout bound the blocks of
+	// transactions that the leader appends to the Raft log as single entries.
+	// A block is appended when it holds BlockMaxTxs transactions, when the
+	// next transaction would take it over BlockMaxBytes or the block gas
+	// limit, or BlockTimeout after its first transaction.
+	BlockMaxTxs   int           `mapstructure:"block-max-txs"`
+	BlockMaxBytes int           `mapstructure:"block-max-bytes"`
+	BlockTimeout  time.Duration `mapstructure:"block-timeout"`
+
+	// AdminAddr is the IP:PORT of the admin API, which manages the membership
+	// of the cluster. It has no authentication, so it should not be reachable
+	// from untrusted networks. It is disabled if empty.
+	AdminAddr string `mapstructure:"admin-addr"`
+
 	//XXX TODO improve this
 
 	RaftDir     string `mapstructure:"dir"`
@@ -104,6 +126,11 @@ func DefaultRaftConfig() *RaftConfig {
 		SnapshotThreshold:  8192,
 		LeaderLeaseTimeout: 500 * time.Millisecond,
 		LocalID:            _raft.ServerID(defaultRaftID),
+		Store:              defaultRaftStore,
+		BlockMaxTxs:        1000,
+		BlockMaxBytes:      1024 * 1024,
+		BlockTimeout:       100 * time.Millisecond,
+		AdminAddr:          defaultAdminAddr,
 		RaftDir:            defaultRaftDir,
 		SnapshotDir:        defaultSnapshotDir,
 		NodeAddr:           defaultNodeAddr,
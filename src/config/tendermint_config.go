@@ -11,15 +11,29 @@ var (
 )
 
 type TmConfig struct {
-	DataDir    string `mapstructure:"datadir"`
+	DataDir string `mapstructure:"datadir"`
+
+	// MetricsFile is the file to which the metrics of committed blocks (commit
+	// timestamp, tx count, gas used and commit latency) are appended, in
+	// MetricsFormat: "csv", "jsonl" or "none". Metrics are not recorded if it
+	// is empty.
+	MetricsFile   string `mapstructure:"metrics-file"`
+	MetricsFormat string `mapstructure:"metrics-format"`
+
+	// StakingContract is the address of the contract whose ValidatorUpdate
+	// events update the Tendermint validator set. The validator set is not
+	// updated if it is empty. It must be the same on all nodes.
+	StakingContract string `mapstructure:"staking-contract"`
+
 	RealConfig *tmConfig.Config
 }
 
 // DefaultTmConfig returns the default configuration for a Babble node
 func DefaultTmConfig() *TmConfig {
 	var conf = &TmConfig{
-		DataDir:    defaultTmDir,
-		RealConfig: tmConfig.DefaultConfig().SetRoot(defaultTmDir),
+		DataDir:       defaultTmDir,
+		MetricsFormat: "csv",
+		RealConfig:    tmConfig.DefaultConfig().SetRoot(defaultTmDir),
 	}
 	return conf
 }
@@ -48,6 +48,12 @@ func (b *InmemBabble) Init(state *state.State, service *service.Service) error {
 	}
 	b.babble = babble
 
+	// Refuse to run on top of a State that was built from blocks this Babble
+	// node doesn't know about.
+	if err := state.CheckIndex(int64(babble.Store.LastBlockIndex())); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1,9 +1,12 @@
 package babble
 
 import (
+	"bytes"
+	"fmt"
+	"sync"
+
 	"github.com/bear987978897/evm-lite/src/service"
 	"github.com/bear987978897/evm-lite/src/state"
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/mosaicnetworks/babble/src/hashgraph"
 	"github.com/mosaicnetworks/babble/src/proxy"
 	"github.com/sirupsen/logrus"
@@ -15,6 +18,11 @@ type InmemProxy struct {
 	state    *state.State
 	submitCh chan []byte
 	logger   *logrus.Entry
+
+	// Babble answers fast-forward requests concurrently with the commits of
+	// new blocks. The State must not be snapshotted or restored while it
+	// commits.
+	commitLock sync.Mutex
 }
 
 // NewInmemProxy initializes and return a new InmemProxy
@@ -47,16 +55,31 @@ func (p *InmemProxy) SubmitCh() chan []byte {
 func (p *InmemProxy) CommitBlock(block hashgraph.Block) (proxy.CommitResponse, error) {
 	p.logger.Debug("CommitBlock")
 
-	blockHashBytes, err := block.Hash()
-	blockHash := common.BytesToHash(blockHashBytes)
+	p.commitLock.Lock()
+	defer p.commitLock.Unlock()
 
-	for i, tx := range block.Transactions() {
-		if err := p.state.ApplyTransaction(tx, i, blockHash); err != nil {
+	// Blocks that were already applied are replayed when Babble bootstraps
+	// from its database. Answer with the state hash they produced the first
+	// time around.
+	if index := int64(block.Index()); index <= p.state.LastIndex() {
+		hash, err := p.state.GetIndexRoot(index)
+		if err != nil {
 			return proxy.CommitResponse{}, err
 		}
+		p.logger.WithField("index", index).Debug("Skipping block already applied")
+		return proxy.CommitResponse{StateHash: hash.Bytes()}, nil
+	}
+
+	// Babble does not know about gas, so its blocks can hold transactions that
+	// do not fit in the block gas limit. They are rejected, like transactions
+	// that cannot be applied, on every node alike.
+	for i, tx := range block.Transactions() {
+		if err := p.state.ApplyTransaction(tx); err != nil {
+			p.logger.WithError(err).WithField("tx", i).Error("Error applying transaction")
+		}
 	}
 
-	hash, err := p.state.Commit()
+	hash, err := p.state.Commit(int64(block.Index()))
 	if err != nil {
 		return proxy.CommitResponse{}, err
 	}
@@ -68,11 +91,39 @@ func (p *InmemProxy) CommitBlock(block hashgraph.Block) (proxy.CommitResponse, e
 	return res, nil
 }
 
-//TODO - Implement these two functions
+// GetSnapshot returns a snapshot of the State that includes the block at
+// blockIndex, for a node that fast-forwards to this block. The snapshot is
+// taken at the last committed block, which may come after blockIndex: the node
+// answers for the blocks in between with the state hashes they produced here,
+// when Babble commits them again.
 func (p *InmemProxy) GetSnapshot(blockIndex int) ([]byte, error) {
-	return []byte{}, nil
+	p.logger.WithField("block", blockIndex).Debug("GetSnapshot")
+
+	p.commitLock.Lock()
+	snap, err := p.state.Snapshot()
+	p.commitLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	if index := snap.Head().Index; index < int64(blockIndex) {
+		return nil, fmt.Errorf("Block %d not committed yet, last committed block is %d", blockIndex, index)
+	}
+
+	var buf bytes.Buffer
+	if err := snap.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
+// Restore replaces the State with a snapshot returned by GetSnapshot
 func (p *InmemProxy) Restore(snapshot []byte) error {
-	return nil
+	p.logger.Debug("Restore")
+
+	p.commitLock.Lock()
+	defer p.commitLock.Unlock()
+
+	return p.state.Restore(bytes.NewReader(snapshot))
 }
@@ -0,0 +1,191 @@
+package babble
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/mosaicnetworks/babble/src/hashgraph"
+	"github.com/mosaicnetworks/babble/src/peers"
+	"github.com/sirupsen/logrus"
+)
+
+// chainID is the chain ID of the test genesis
+var chainID = big.NewInt(4242)
+
+type proxyTest struct {
+	dir     string
+	account *state.TestAccount
+	nonce   uint64
+	logger  *logrus.Logger
+	t       *testing.T
+}
+
+func newProxyTest(t *testing.T) *proxyTest {
+	dir, err := ioutil.TempDir("", "evml-babble")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &proxyTest{
+		dir:     dir,
+		account: state.NewTestAccount(t),
+		logger:  bcommon.NewTestLogger(t),
+		t:       t,
+	}
+}
+
+func (test *proxyTest) newProxy(name string) (*InmemProxy, *state.State) {
+	s := test.account.NewState(test.t,
+		filepath.Join(test.dir, name),
+		fmt.Sprintf(`"config": {"chainId": %v}`, chainID))
+	return NewInmemProxy(s, nil, make(chan []byte), test.logger), s
+}
+
+// block returns a Babble block with a transfer of 1 wei to each address
+func (test *proxyTest) block(index int, to ...common.Address) hashgraph.Block {
+	signer := ethTypes.NewEIP155Signer(chainID)
+
+	var txs [][]byte
+	for _, addr := range to {
+		tx := ethTypes.NewTransaction(test.nonce, addr, big.NewInt(1), 21000, big.NewInt(0), nil)
+		signed, err := ethTypes.SignTx(tx, signer, test.account.Key)
+		if err != nil {
+			test.t.Fatal(err)
+		}
+		data, err := rlp.EncodeToBytes(signed)
+		if err != nil {
+			test.t.Fatal(err)
+		}
+		txs = append(txs, data)
+		test.nonce++
+	}
+
+	return *hashgraph.NewBlock(index, index, []byte{}, []*peers.Peer{}, txs)
+}
+
+func commit(t *testing.T, p *InmemProxy, block hashgraph.Block) []byte {
+	res, err := p.CommitBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StateHash
+}
+
+func TestInmemProxySnapshot(t *testing.T) {
+	test := newProxyTest(t)
+	defer os.RemoveAll(test.dir)
+
+	alice := common.HexToAddress("0xa11ce")
+	bob := common.HexToAddress("0xb0b")
+
+	node, nodeState := test.newProxy("node")
+	var blocks []hashgraph.Block
+	var hashes [][]byte
+	for i := 0; i < 3; i++ {
+		block := test.block(i, alice, bob)
+		blocks = append(blocks, block)
+		hashes = append(hashes, commit(t, node, block))
+	}
+
+	if _, err := node.GetSnapshot(3); err == nil {
+		t.Fatal("Snapshot of a block that is not committed should fail")
+	}
+
+	// Babble fast-forwards to an anchor block that can be behind the last
+	// committed block
+	snapshot, err := node.GetSnapshot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, freshState := test.newProxy("fresh")
+	if err := fresh.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(freshState.CurrentBlock().Root().Bytes(), hashes[2]) {
+		t.Fatalf("Restored root should be %x, not %x", hashes[2], freshState.CurrentBlock().Root())
+	}
+
+	// The blocks after the anchor are committed again
+	if h := commit(t, fresh, blocks[2]); !bytes.Equal(h, hashes[2]) {
+		t.Fatalf("State hash of replayed block should be %x, not %x", hashes[2], h)
+	}
+
+	// Both nodes converge on new blocks
+	block := test.block(3, alice)
+	if h1, h2 := commit(t, node, block), commit(t, fresh, block); !bytes.Equal(h1, h2) {
+		t.Fatalf("State hashes differ: %x and %x", h1, h2)
+	}
+	if b1, b2 := nodeState.GetBalance(alice), freshState.GetBalance(alice); b1.Cmp(b2) != 0 || b1.Int64() != 4 {
+		t.Fatalf("Balances should be 4, not %v and %v", b1, b2)
+	}
+	if n := freshState.GetNonce(test.account.Address); n != test.nonce {
+		t.Fatalf("Nonce should be %d, not %d", test.nonce, n)
+	}
+
+	// Restoring a snapshot that the State already includes is a no-op
+	if err := fresh.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInmemProxyRestoreErrors(t *testing.T) {
+	test := newProxyTest(t)
+	defer os.RemoveAll(test.dir)
+
+	alice := common.HexToAddress("0xa11ce")
+	bob := common.HexToAddress("0xb0b")
+
+	node, _ := test.newProxy("node")
+	first := test.block(0, alice)
+	hash := commit(t, node, first)
+	commit(t, node, test.block(1, bob))
+
+	// Blocks replayed by Babble are answered with the hash they produced
+	if h := commit(t, node, first); !bytes.Equal(h, hash) {
+		t.Fatalf("State hash of replayed block should be %x, not %x", hash, h)
+	}
+
+	snapshot, err := node.GetSnapshot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated snapshot is refused, and leaves the State where it was
+	fresh, freshState := test.newProxy("fresh")
+	if err := fresh.Restore(snapshot[:len(snapshot)/2]); err == nil {
+		t.Fatal("Truncated snapshot should not be restored")
+	}
+	if freshState.LastIndex() != -1 {
+		t.Fatalf("Last index should still be -1, not %d", freshState.LastIndex())
+	}
+	if err := fresh.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if freshState.LastIndex() != 1 {
+		t.Fatalf("Last index should be 1, not %d", freshState.LastIndex())
+	}
+
+	// A snapshot of another history is refused by a State that committed the
+	// same blocks
+	other, otherState := test.newProxy("other")
+	test.nonce = 0
+	commit(t, other, test.block(0, bob))
+	commit(t, other, test.block(1, bob))
+	if err := other.Restore(snapshot); err == nil {
+		t.Fatal("Snapshot with a different root should not be restored")
+	}
+	if otherState.GetBalance(bob).Int64() != 2 {
+		t.Fatalf("Balance should still be 2, not %v", otherState.GetBalance(bob))
+	}
+}
@@ -0,0 +1,202 @@
+package raft
+
+import (
+	"time"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	_raft "github.com/hashicorp/raft"
+	"github.com/sirupsen/logrus"
+)
+
+// blockEntry prefixes the Raft log entries that carry a block of transactions.
+// Entries written before blocks carry a single RLP-encoded transaction, which
+// starts with an RLP list prefix (>= 0xc0).
+const blockEntry byte = 0x01
+
+// txBuffer is the number of transactions that can wait for the leader to add
+// them to a block
+const txBuffer = 1024
+
+// ApplyResult is the response of FSM.Apply for a block: the state root that
+// results from it, and the error of each transaction that could not be applied
+// (nil for the others).
+type ApplyResult struct {
+	Root     common.Hash
+	TxErrors []error
+}
+
+// blockTx is a transaction waiting to be added to a block
+type blockTx struct {
+	data []byte
+	gas  uint64
+}
+
+// blockBuilder accumulates transactions into a block, within the limits of
+// the configuration and the block gas limit
+type blockBuilder struct {
+	maxTxs   int
+	maxBytes int
+	gasLimit uint64
+
+	txs   [][]byte
+	bytes int
+	gas   uint64
+}
+
+// fits tells whether tx can be added to the block. Any transaction fits in an
+// empty block.
+func (b *blockBuilder) fits(tx blockTx) bool {
+	return len(b.txs) == 0 ||
+		(b.bytes+len(tx.data) <= b.maxBytes && b.gas+tx.gas <= b.gasLimit)
+}
+
+func (b *blockBuilder) add(tx blockTx) {
+	b.txs = append(b.txs, tx.data)
+	b.bytes += len(tx.data)
+	b.gas += tx.gas
+}
+
+func (b *blockBuilder) full() bool {
+	return len(b.txs) >= b.maxTxs
+}
+
+// take empties the block and returns its transactions
+func (b *blockBuilder) take() [][]byte {
+	txs := b.txs
+	b.txs, b.bytes, b.gas = nil, 0, 0
+	return txs
+}
+
+func encodeBlock(txs [][]byte) ([]byte, error) {
+	data, err := rlp.EncodeToBytes(txs)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{blockEntry}, data...), nil
+}
+
+// decodeBlock returns the transactions of a Raft log entry
+func decodeBlock(data []byte) ([][]byte, error) {
+	if len(data) == 0 || data[0] != blockEntry {
+		return [][]byte{data}, nil
+	}
+	var txs [][]byte
+	if err := rlp.DecodeBytes(data[1:], &txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+/******************************************************************************/
+
+// apply adds a transaction to the next block of the leader
+func (r *Raft) apply(tx []byte) error {
+	if r.raftNode.State() != _raft.Leader {
+		return _raft.ErrNotLeader
+	}
+
+	t, err := state.DecodeTx(tx)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r.txCh <- blockTx{data: tx, gas: t.Gas()}:
+		return nil
+	case <-time.After(r.config.CommitTimeout):
+		return _raft.ErrEnqueueTimeout
+	}
+}
+
+// applyTxs adds transactions to the next blocks of the leader, in order, up to
+// the first one that it cannot add
+func (r *Raft) applyTxs(txs [][]byte) SubmitResult {
+	for i, tx := range txs {
+		if err := r.apply(tx); err != nil {
+			return SubmitResult{Accepted: i, Error: err.Error()}
+		}
+	}
+	return SubmitResult{Accepted: len(txs)}
+}
+
+// buildBlocks accumulates the transactions submitted to the leader into
+// blocks, and appends each block to the Raft log as a single entry. A block is
+// appended when it is full, when the next transaction does not fit in it, or
+// BlockTimeout after its first transaction.
+func (r *Raft) buildBlocks() {
+	block := &blockBuilder{
+		maxTxs:   r.config.BlockMaxTxs,
+		maxBytes: r.config.BlockMaxBytes,
+		gasLimit: r.state.GetGasLimit(),
+	}
+
+	var timeout <-chan time.Time
+	for {
+		select {
+		case tx := <-r.txCh:
+			if !block.fits(tx) {
+				r.appendBlock(block.take())
+				timeout = nil
+			}
+			block.add(tx)
+			if block.full() {
+				r.appendBlock(block.take())
+				timeout = nil
+			} else if timeout == nil {
+				timeout = time.After(r.config.BlockTimeout)
+			}
+		case <-timeout:
+			r.appendBlock(block.take())
+			timeout = nil
+		}
+	}
+}
+
+// appendBlock appends a block to the Raft log. Blocks that do not make it to
+// the log, because leadership was lost, are submitted again to the next
+// leader.
+func (r *Raft) appendBlock(txs [][]byte) {
+	data, err := encodeBlock(txs)
+	if err != nil {
+		r.logger.WithError(err).Error("Encoding block")
+		return
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"txs":   len(txs),
+		"bytes": len(data),
+	}).Debug("Appending block")
+
+	f := r.raftNode.Apply(data, r.config.CommitTimeout)
+
+	go func() {
+		if err := f.Error(); err != nil {
+			r.logger.WithError(err).Warn("Appending block to Raft log")
+			r.resubmit(txs, err)
+			return
+		}
+
+		res, ok := f.Response().(*ApplyResult)
+		if !ok {
+			return
+		}
+		for i, err := range res.TxErrors {
+			if err != nil {
+				r.logger.WithError(err).WithField("tx", i).Debug("Transaction not applied")
+			}
+		}
+	}()
+}
+
+// resubmit submits the transactions of a block that failed to be appended
+func (r *Raft) resubmit(txs [][]byte, err error) {
+	if retryable(err) {
+		r.submitTxs(txs)
+		return
+	}
+	for _, tx := range txs {
+		r.rejectTx(tx, err)
+	}
+}
@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestDecodeBlock(t *testing.T) {
+	txs := [][]byte{{0xc1, 0x01}, {0xc2, 0x02, 0x03}, {}}
+
+	data, err := encodeBlock(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != blockEntry {
+		t.Fatalf("Blocks should start with %#x, not %#x", blockEntry, data[0])
+	}
+
+	decoded, err := decodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(txs) {
+		t.Fatalf("Decoded %d transactions, want %d", len(decoded), len(txs))
+	}
+	for i := range txs {
+		if !bytes.Equal(decoded[i], txs[i]) {
+			t.Fatalf("Transaction %d: got %x, want %x", i, decoded[i], txs[i])
+		}
+	}
+
+	// Entries written before blocks carry a single RLP-encoded transaction
+	tx := ethTypes.NewTransaction(0, common.HexToAddress("b0b"), big.NewInt(1), 21000, big.NewInt(0), nil)
+	legacy, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err = decodeBlock(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || !bytes.Equal(decoded[0], legacy) {
+		t.Fatalf("Legacy entry should decode to its transaction, got %x", decoded)
+	}
+
+	if _, err := decodeBlock([]byte{blockEntry, 0x01}); err == nil {
+		t.Fatal("Malformed block should not decode")
+	}
+}
+
+func TestBlockBuilder(t *testing.T) {
+	b := &blockBuilder{
+		maxTxs:   3,
+		maxBytes: 10,
+		gasLimit: 100000,
+	}
+	tx := func(size int, gas uint64) blockTx {
+		return blockTx{data: make([]byte, size), gas: gas}
+	}
+
+	// Any transaction fits in an empty block, even over the limits
+	if !b.fits(tx(20, 200000)) {
+		t.Fatal("Transaction should fit in an empty block")
+	}
+
+	b.add(tx(4, 21000))
+	b.add(tx(4, 21000))
+	if b.full() {
+		t.Fatal("Block of 2 transactions should not be full")
+	}
+	if b.fits(tx(3, 21000)) {
+		t.Fatal("Transaction should not take the block over maxBytes")
+	}
+	if !b.fits(tx(2, 21000)) {
+		t.Fatal("Transaction should fit up to maxBytes")
+	}
+	if b.fits(tx(1, 60000)) {
+		t.Fatal("Transaction should not take the block over the gas limit")
+	}
+	if !b.fits(tx(1, 58000)) {
+		t.Fatal("Transaction should fit up to the gas limit")
+	}
+
+	b.add(tx(1, 21000))
+	if !b.full() {
+		t.Fatal("Block of maxTxs transactions should be full")
+	}
+
+	txs := b.take()
+	if len(txs) != 3 {
+		t.Fatalf("Block should hold 3 transactions, not %d", len(txs))
+	}
+	if len(b.txs) != 0 || b.bytes != 0 || b.gas != 0 {
+		t.Fatal("Taking the block should empty it")
+	}
+	if !b.fits(tx(10, 100000)) {
+		t.Fatal("Transaction should fit in the next block")
+	}
+}
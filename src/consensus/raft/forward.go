@@ -0,0 +1,263 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	_raft "github.com/hashicorp/raft"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// forwardTimeout bounds the time spent running most operations on the
+	// leader, retries included. It covers a few elections.
+	forwardTimeout = 10 * time.Second
+	// forwardRetryInterval is the time between two attempts
+	forwardRetryInterval = 250 * time.Millisecond
+)
+
+var errNoLeader = errors.New("No Raft leader")
+
+// unreachableError is returned when the leader could not be reached
+type unreachableError struct {
+	leader _raft.ServerAddress
+	err    error
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("Leader %s unreachable: %v", e.leader, e.err)
+}
+
+// forwarder is the RPC service through which followers run operations on the
+// leader: submitting transactions and changing the cluster configuration. Its
+// methods fail with ErrNotLeader if this node is not the leader.
+type forwarder struct {
+	raft *Raft
+}
+
+// SubmitResult is the answer of the leader to a batch of transactions: the
+// number of transactions, from the first, that it added to its next blocks,
+// and the error that stopped it before the others.
+type SubmitResult struct {
+	Accepted int
+	Error    string
+}
+
+// SubmitTxs adds transactions to the next blocks of the leader, in order
+func (f *forwarder) SubmitTxs(txs [][]byte, res *SubmitResult) error {
+	*res = f.raft.applyTxs(txs)
+	return nil
+}
+
+// ChangeConfiguration changes the membership of the cluster
+func (f *forwarder) ChangeConfiguration(change ConfigurationChange, ok *bool) error {
+	if err := f.raft.applyChange(change); err != nil {
+		return err
+	}
+	*ok = true
+	return nil
+}
+
+// forwardClient is the connection of a follower to the leader's forwarder
+type forwardClient struct {
+	sync.Mutex
+	leader _raft.ServerAddress
+	client *rpc.Client
+}
+
+// get returns a client connected to the leader's forwarder
+func (c *forwardClient) get(stream *muxStreamLayer, leader _raft.ServerAddress) (*rpc.Client, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.leader != leader {
+		c.close(c.client)
+		c.leader = leader
+	}
+	if c.client == nil {
+		conn, err := stream.dial(string(leader), forwardConn, forwardTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.client = rpc.NewClient(conn)
+	}
+	return c.client, nil
+}
+
+// discard closes a client whose connection is broken
+func (c *forwardClient) discard(client *rpc.Client) {
+	c.Lock()
+	defer c.Unlock()
+	c.close(client)
+}
+
+func (c *forwardClient) close(client *rpc.Client) {
+	if client != nil && client == c.client {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+/******************************************************************************/
+
+// serveForward answers the operations forwarded by the followers
+func (r *Raft) serveForward() {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Raft", &forwarder{raft: r}); err != nil {
+		r.logger.WithError(err).Error("Registering forwarder")
+		return
+	}
+	for conn := range r.stream.forward {
+		go server.ServeConn(conn)
+	}
+}
+
+// onLeader runs an operation on the leader: locally, with local, if this node
+// is the leader, or by calling method on the leader's forwarder otherwise.
+// Attempts are repeated, while there is no leader or the leader cannot be
+// reached, for up to timeout. Operations refused by the leader are not
+// repeated.
+func (r *Raft) onLeader(method string, args, reply interface{}, timeout time.Duration, local func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := r.onLeaderOnce(method, args, reply, deadline, local)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if time.Now().Add(forwardRetryInterval).After(deadline) {
+			return err
+		}
+		r.logger.WithError(err).WithField("method", method).Debug("Retrying on leader")
+		time.Sleep(forwardRetryInterval)
+	}
+}
+
+func (r *Raft) onLeaderOnce(method string, args, reply interface{}, deadline time.Time, local func() error) error {
+	if r.raftNode.State() == _raft.Leader {
+		return local()
+	}
+
+	leader := r.raftNode.Leader()
+	if leader == "" {
+		return errNoLeader
+	}
+
+	return r.forward(leader, method, args, reply, deadline)
+}
+
+// forward calls a method of the leader's forwarder
+func (r *Raft) forward(leader _raft.ServerAddress, method string, args, reply interface{}, deadline time.Time) error {
+	client, err := r.forwardClient.get(r.stream, leader)
+	if err != nil {
+		return &unreachableError{leader, err}
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"leader": leader,
+		"method": method,
+	}).Debug("Forwarding to leader")
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if _, refused := call.Error.(rpc.ServerError); call.Error != nil && !refused {
+			// The connection is broken
+			r.forwardClient.discard(client)
+			return &unreachableError{leader, call.Error}
+		}
+		return call.Error
+	case <-time.After(time.Until(deadline)):
+		r.forwardClient.discard(client)
+		return &unreachableError{leader, errors.New("timed out")}
+	}
+}
+
+// retryable tells whether an operation failed because there was no leader, or
+// the leader could not be reached or changed, rather than because the leader
+// refused it. Errors returned by the leader's forwarder only carry a message.
+func retryable(err error) bool {
+	if _, ok := err.(*unreachableError); ok {
+		return true
+	}
+	switch err.Error() {
+	case errNoLeader.Error(),
+		_raft.ErrNotLeader.Error(),
+		_raft.ErrLeadershipLost.Error(),
+		_raft.ErrEnqueueTimeout.Error():
+		return true
+	}
+	return false
+}
+
+/******************************************************************************/
+
+// submitTxs appends transactions to the Raft log, through the leader, in as
+// few round trips as possible. Attempts are repeated as in onLeader for the
+// transactions that the leader has not accepted yet. A transaction that the
+// leader refuses is rejected, and the ones after it are submitted again. The
+// Service is told whether each transaction was accepted.
+func (r *Raft) submitTxs(txs [][]byte) {
+	deadline := time.Now().Add(forwardTimeout)
+	for len(txs) > 0 {
+		var res SubmitResult
+		err := r.onLeaderOnce("Raft.SubmitTxs", txs, &res, deadline, func() error {
+			res = r.applyTxs(txs)
+			return nil
+		})
+		if err == nil {
+			for _, tx := range txs[:res.Accepted] {
+				r.service.TxSubmitted(txHash(tx), nil)
+			}
+			txs = txs[res.Accepted:]
+			if res.Error == "" {
+				return
+			}
+			err = errors.New(res.Error)
+		}
+
+		switch {
+		case !retryable(err):
+			r.rejectTx(txs[0], err)
+			txs = txs[1:]
+		case time.Now().Add(forwardRetryInterval).After(deadline):
+			for _, tx := range txs {
+				r.rejectTx(tx, err)
+			}
+			return
+		default:
+			r.logger.WithError(err).WithField("txs", len(txs)).Debug("Retrying transactions on leader")
+			time.Sleep(forwardRetryInterval)
+		}
+	}
+}
+
+// txHash returns the hash of an RLP-encoded transaction, which is the hash of
+// its encoding
+func txHash(tx []byte) common.Hash {
+	return crypto.Keccak256Hash(tx)
+}
+
+// rejectTx drops a transaction that could not be submitted from the pool, so
+// that its status tells the client why.
+func (r *Raft) rejectTx(tx []byte, err error) {
+	t, decodeErr := state.DecodeTx(tx)
+	if decodeErr != nil {
+		r.logger.WithError(decodeErr).Error("Decoding rejected transaction")
+		return
+	}
+	r.logger.WithFields(logrus.Fields{
+		"hash":  t.Hash().Hex(),
+		"error": err,
+	}).Error("Submitting transaction to Raft")
+
+	if err := r.state.DropTx(t.Hash(), fmt.Sprintf("Raft: %v", err)); err != nil {
+		r.logger.WithError(err).Error("Dropping rejected transaction")
+	}
+	r.service.TxSubmitted(t.Hash(), err)
+}
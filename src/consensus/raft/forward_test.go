@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/bear987978897/evm-lite/src/config"
+	"github.com/bear987978897/evm-lite/src/service"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	_raft "github.com/hashicorp/raft"
+)
+
+// freeAddr returns a local address that no one listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// newTestCluster starts Raft nodes that bootstrap a cluster together. They
+// build blocks and serve forwarded operations, but do not read the Service.
+func newTestCluster(t *testing.T, dir string, account *state.TestAccount, size int) []*Raft {
+	type peer struct {
+		ID      string `json:"id"`
+		Address string `json:"address"`
+	}
+	peers := make([]peer, size)
+	for i := range peers {
+		peers[i] = peer{ID: fmt.Sprintf("node%d", i), Address: freeAddr(t)}
+	}
+	peersJSON, err := json.Marshal(peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := make([]*Raft, size)
+	for i, p := range peers {
+		nodeDir := filepath.Join(dir, p.ID)
+		conf := config.DefaultRaftConfig()
+		conf.LocalID = _raft.ServerID(p.ID)
+		conf.NodeAddr = p.Address
+		conf.RaftDir = filepath.Join(nodeDir, "raft")
+		conf.SnapshotDir = filepath.Join(nodeDir, "snapshots")
+		conf.Store = "inmem"
+		conf.AdminAddr = ""
+		if err := os.MkdirAll(conf.RaftDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(conf.RaftDir, "peers.json"), peersJSON, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		logger := bcommon.NewTestLogger(t)
+		s := account.NewState(t, nodeDir, "")
+		svc := service.NewService(filepath.Join(nodeDir, "keystore"), "", "", s, make(chan []byte), logger)
+
+		node := NewRaft(*conf, logger)
+		node.logger = node.logger.WithField("node", p.ID)
+		if err := node.Init(s, svc); err != nil {
+			t.Fatal(err)
+		}
+		go node.serveForward()
+		go node.buildBlocks()
+		nodes[i] = node
+	}
+	return nodes
+}
+
+func shutdownCluster(nodes []*Raft) {
+	for _, node := range nodes {
+		node.raftNode.Shutdown().Error()
+		node.stream.Close()
+	}
+}
+
+// waitForLeader returns the leader of the cluster, and one of its followers
+func waitForLeader(t *testing.T, nodes []*Raft) (*Raft, *Raft) {
+	deadline := time.Now().Add(forwardTimeout)
+	for time.Now().Before(deadline) {
+		for i, node := range nodes {
+			if node.raftNode.State() == _raft.Leader {
+				return node, nodes[(i+1)%len(nodes)]
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("No leader elected")
+	return nil, nil
+}
+
+func TestForwardToLeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evml-raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	account := state.NewTestAccount(t)
+	nodes := newTestCluster(t, dir, account, 2)
+	defer shutdownCluster(nodes)
+
+	_, follower := waitForLeader(t, nodes)
+
+	// Watch the blocks committed by the follower from the Raft log
+	blocks := make(chan state.ChainEvent, 16)
+	sub := follower.state.SubscribeChainEvent(blocks)
+	defer sub.Unsubscribe()
+
+	// The follower cannot append to the log: it forwards the transactions to
+	// the leader in one batch. The leader refuses the malformed one, and puts
+	// the others in a block.
+	first := transfer(t, follower.state, account, 0)
+	second := transfer(t, follower.state, account, 1)
+	follower.submitTxs([][]byte{first, []byte("malformed"), second})
+
+	hashes := []common.Hash{txHash(first), txHash(second)}
+	timeout := time.After(forwardTimeout)
+	for len(hashes) > 0 {
+		select {
+		case ev := <-blocks:
+			for _, committed := range ev.Block.Transactions() {
+				if committed.Hash() != hashes[0] {
+					t.Fatalf("Committed transaction should be %x, not %x", hashes[0], committed.Hash())
+				}
+				hashes = hashes[1:]
+			}
+		case <-timeout:
+			t.Fatal("Forwarded transactions were not committed")
+		}
+	}
+}
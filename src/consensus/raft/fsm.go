@@ -1,11 +1,10 @@
 package raft
 
 import (
-	"fmt"
+	"bufio"
 	"io"
 
 	"github.com/bear987978897/evm-lite/src/state"
-	_ethCommon "github.com/ethereum/go-ethereum/common"
 	_raft "github.com/hashicorp/raft"
 	"github.com/sirupsen/logrus"
 )
@@ -29,36 +28,113 @@ IMPLEMENT RAFT FSM INTERFACE
 *******************************************************************************/
 
 // Apply is invoked once a log entry is committed.
-// It applies the log data to the state as a transaction.
+// It applies the transactions of the block carried by the entry to the state,
+// and commits them once. It returns an *ApplyResult, with the errors of the
+// transactions that failed; those are left out of the block.
 func (f *FSM) Apply(log *_raft.Log) interface{} {
 
 	f.logger.WithFields(logrus.Fields{
 		"index": log.Index,
 		"term":  log.Term,
 		"type":  log.Type,
-		"data":  log.Data,
+		"bytes": len(log.Data),
 	}).Debug("Apply")
 
-	if err := f.state.ApplyTransaction(log.Data, int(log.Index), _ethCommon.Hash{}); err != nil {
-		f.logger.WithError(err).Error("Error applying transaction")
+	// Entries that were already applied are replayed when a node restarts:
+	// from its own log, or by the leader if the node kept its log in memory.
+	// Only the entries after the last index committed by the State are
+	// applied; the others are answered with the state hash they produced the
+	// first time around.
+	if index := int64(log.Index); index <= f.state.LastIndex() {
+		hash, err := f.state.GetIndexRoot(index)
+		if err != nil {
+			f.logger.WithError(err).Error("Error getting state hash of applied entry")
+			return nil
+		}
+		return &ApplyResult{Root: hash}
+	}
+
+	txs, err := decodeBlock(log.Data)
+	if err != nil {
+		f.logger.WithError(err).Error("Error decoding block")
 		return nil
 	}
 
-	hash, err := f.state.Commit()
+	res := &ApplyResult{TxErrors: make([]error, len(txs))}
+	for i, tx := range txs {
+		if err := f.state.ApplyTransaction(tx); err != nil {
+			f.logger.WithError(err).WithField("tx", i).Error("Error applying transaction")
+			res.TxErrors[i] = err
+		}
+	}
+
+	res.Root, err = f.state.Commit(int64(log.Index))
 	if err != nil {
 		f.logger.WithError(err).Error("Error committing")
 		return nil
 	}
 
-	return hash.Bytes()
+	f.logger.WithFields(logrus.Fields{
+		"index": log.Index,
+		"txs":   len(txs),
+		"root":  res.Root.Hex(),
+	}).Debug("Committed block")
+
+	return res
 }
 
-// Snapshot is not implemented yet
+// Snapshot captures the committed state. Raft calls it between two Apply
+// calls, and persists the snapshot concurrently with the following ones.
 func (f *FSM) Snapshot() (_raft.FSMSnapshot, error) {
-	return nil, fmt.Errorf("Snapshot function not implemented")
+	snap, err := f.state.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{
+		snap:   snap,
+		logger: f.logger,
+	}, nil
+}
+
+// Restore replaces the state with a snapshot. It is called when the node
+// starts with a snapshot on disk, and when the leader sends a snapshot to a
+// follower that is too far behind to catch up from the log.
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	return f.state.Restore(rc)
+}
+
+/*******************************************************************************
+IMPLEMENT RAFT FSMSNAPSHOT INTERFACE
+*******************************************************************************/
+
+type fsmSnapshot struct {
+	snap   *state.Snapshot
+	logger *logrus.Entry
+}
+
+// Persist writes the snapshot to the sink
+func (s *fsmSnapshot) Persist(sink _raft.SnapshotSink) error {
+	w := bufio.NewWriter(sink)
+	err := s.snap.Encode(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("Error persisting snapshot")
+		sink.Cancel()
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"id":    sink.ID(),
+		"index": s.snap.Head().Index,
+	}).Info("Persisted snapshot")
+
+	return sink.Close()
 }
 
-// Restore is not implemented yet
-func (f *FSM) Restore(io.ReadCloser) error {
-	return fmt.Errorf("Restore function not implemented")
+// Release is invoked when we are finished with the snapshot
+func (s *fsmSnapshot) Release() {
+	s.snap.Release()
 }
@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	_raft "github.com/hashicorp/raft"
+)
+
+// transfer returns an encoded transfer of 1 wei to 0xb0b, signed by account
+func transfer(t *testing.T, s *state.State, account *state.TestAccount, nonce uint64) []byte {
+	tx := ethTypes.NewTransaction(nonce, common.HexToAddress("b0b"), big.NewInt(1), 21000, big.NewInt(0), nil)
+	tx, err := ethTypes.SignTx(tx, s.GetSigner(), account.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func TestFSMApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evml-fsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	account := state.NewTestAccount(t)
+	s := account.NewState(t, dir, "")
+	fsm := NewFSM(s, bcommon.NewTestLogger(t).WithField("module", "raft"))
+
+	apply := func(index uint64, data []byte) *ApplyResult {
+		res, ok := fsm.Apply(&_raft.Log{Index: index, Type: _raft.LogCommand, Data: data}).(*ApplyResult)
+		if !ok {
+			t.Fatalf("Entry %d should be applied", index)
+		}
+		return res
+	}
+
+	// A block, with a transaction that cannot be applied
+	block, err := encodeBlock([][]byte{transfer(t, s, account, 0), transfer(t, s, account, 5), transfer(t, s, account, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := apply(1, block)
+	if res.TxErrors[0] != nil || res.TxErrors[1] == nil || res.TxErrors[2] != nil {
+		t.Fatalf("Only the second transaction should fail, got %v", res.TxErrors)
+	}
+	if n := s.GetNonce(account.Address); n != 2 {
+		t.Fatalf("Nonce should be 2, not %d", n)
+	}
+	if s.LastIndex() != 1 {
+		t.Fatalf("Last index should be 1, not %d", s.LastIndex())
+	}
+	root := res.Root
+
+	// Entries that were already applied are skipped, and answered with the
+	// root they produced
+	replay := apply(1, block)
+	if replay.Root != root {
+		t.Fatalf("Replayed entry should return root %s, not %s", root.Hex(), replay.Root.Hex())
+	}
+	if n := s.GetNonce(account.Address); n != 2 {
+		t.Fatalf("Replayed entry should not be applied again, nonce is %d", n)
+	}
+	if s.CurrentBlock().NumberU64() != 1 {
+		t.Fatalf("Replayed entry should not produce a block, current block is %d", s.CurrentBlock().NumberU64())
+	}
+
+	// Entries written before blocks carry a single transaction
+	apply(2, transfer(t, s, account, 2))
+	if n := s.GetNonce(account.Address); n != 3 {
+		t.Fatalf("Legacy entry should be applied, nonce is %d", n)
+	}
+	if s.LastIndex() != 2 {
+		t.Fatalf("Last index should be 2, not %d", s.LastIndex())
+	}
+}
@@ -0,0 +1,232 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	_raft "github.com/hashicorp/raft"
+	"github.com/sirupsen/logrus"
+)
+
+// Operations of a ConfigurationChange
+//
+// Leadership transfers are not supported: hashicorp/raft v1.0.0 cannot hand
+// leadership over to another server.
+const (
+	AddVoter     = "add-voter"
+	AddNonvoter  = "add-nonvoter"
+	DemoteVoter  = "demote"
+	RemoveServer = "remove"
+)
+
+// ConfigurationChange is a change of the membership of the Raft cluster. ID
+// identifies the server to add, demote or remove, and Address is required to
+// add a server.
+type ConfigurationChange struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Validate checks that the change has the arguments of its operation
+func (c ConfigurationChange) Validate() error {
+	switch c.Op {
+	case AddVoter, AddNonvoter:
+		if c.ID == "" || c.Address == "" {
+			return fmt.Errorf("%s requires a server id and address", c.Op)
+		}
+	case DemoteVoter, RemoveServer:
+		if c.ID == "" {
+			return fmt.Errorf("%s requires a server id", c.Op)
+		}
+	default:
+		return fmt.Errorf("Unknown configuration change %q", c.Op)
+	}
+	return nil
+}
+
+// Server is a member of the Raft cluster
+type Server struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+}
+
+/******************************************************************************/
+
+// changeConfiguration applies a change on the leader, forwarding it if needed
+func (r *Raft) changeConfiguration(change ConfigurationChange) error {
+	if err := change.Validate(); err != nil {
+		return err
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"op":      change.Op,
+		"id":      change.ID,
+		"address": change.Address,
+	}).Info("Changing Raft configuration")
+
+	return r.onLeader("Raft.ChangeConfiguration", change, new(bool), forwardTimeout, func() error {
+		return r.applyChange(change)
+	})
+}
+
+// applyChange applies a change to the configuration of the leader
+func (r *Raft) applyChange(change ConfigurationChange) error {
+	if err := change.Validate(); err != nil {
+		return err
+	}
+	if r.raftNode.State() != _raft.Leader {
+		return _raft.ErrNotLeader
+	}
+
+	id := _raft.ServerID(change.ID)
+	address := _raft.ServerAddress(change.Address)
+	timeout := r.config.CommitTimeout
+
+	switch change.Op {
+	case AddVoter:
+		return r.raftNode.AddVoter(id, address, 0, timeout).Error()
+	case AddNonvoter:
+		return r.raftNode.AddNonvoter(id, address, 0, timeout).Error()
+	case DemoteVoter:
+		return r.raftNode.DemoteVoter(id, 0, timeout).Error()
+	default:
+		return r.raftNode.RemoveServer(id, 0, timeout).Error()
+	}
+}
+
+// servers returns the latest configuration
+func (r *Raft) servers() ([]_raft.Server, error) {
+	f := r.raftNode.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return nil, err
+	}
+	return f.Configuration().Servers, nil
+}
+
+// configuration returns the members of the cluster
+func (r *Raft) configuration() ([]Server, error) {
+	servers, err := r.servers()
+	if err != nil {
+		return nil, err
+	}
+
+	leader := r.raftNode.Leader()
+	res := make([]Server, len(servers))
+	for i, s := range servers {
+		res[i] = Server{
+			ID:       string(s.ID),
+			Address:  string(s.Address),
+			Suffrage: s.Suffrage.String(),
+			Leader:   s.Address == leader,
+		}
+	}
+	return res, nil
+}
+
+/*******************************************************************************
+ADMIN ENDPOINTS
+*******************************************************************************/
+
+/*
+GET /raft/configuration
+returns: JSON [Server]
+
+Returns the members of the Raft cluster.
+*/
+func (r *Raft) configurationHandler(w http.ResponseWriter, req *http.Request) {
+	r.logger.Debug("GET raft/configuration")
+
+	servers, err := r.configuration()
+	if err != nil {
+		r.logger.WithError(err).Error("Getting Raft configuration")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(servers)
+	if err != nil {
+		r.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/*
+POST /raft/add-voter
+POST /raft/add-nonvoter
+POST /raft/demote
+POST /raft/remove
+data: JSON {"id": "...", "address": "..."}
+returns: JSON [Server]
+
+Changes the membership of the Raft cluster. The change is forwarded to the
+leader, and the configuration that results from it is returned.
+*/
+func (r *Raft) changeHandler(op string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r.logger.WithField("op", op).Debug("POST raft")
+
+		var change ConfigurationChange
+		if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+			r.logger.WithError(err).Error("Decoding JSON request")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		change.Op = op
+		if err := change.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := r.changeConfiguration(change); err != nil {
+			r.logger.WithError(err).Error("Changing Raft configuration")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.configurationHandler(w, req)
+	}
+}
+
+// listenAdmin opens the listener of the admin API, if it is enabled
+func (r *Raft) listenAdmin() error {
+	if r.config.AdminAddr == "" {
+		r.logger.Info("Raft admin API disabled")
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", r.config.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("admin API: %s", err)
+	}
+	r.adminListener = ln
+	return nil
+}
+
+// serveAdmin serves the admin endpoints. They change the cluster, so they are
+// kept off the Service's public API, and its CORS headers.
+func (r *Raft) serveAdmin() {
+	if r.adminListener == nil {
+		return
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/raft/configuration", r.configurationHandler).Methods("GET")
+	for _, op := range []string{AddVoter, AddNonvoter, DemoteVoter, RemoveServer} {
+		router.HandleFunc("/raft/"+op, r.changeHandler(op)).Methods("POST")
+	}
+
+	r.logger.WithField("addr", r.adminListener.Addr()).Info("Serving Raft admin API")
+	if err := http.Serve(r.adminListener, router); err != nil {
+		r.logger.WithError(err).Error("Serving Raft admin API")
+	}
+}
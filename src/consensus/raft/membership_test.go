@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bear987978897/evm-lite/src/state"
+)
+
+// postChange calls the admin endpoint of op on node, and decodes the
+// configuration that it returns
+func postChange(t *testing.T, node *Raft, op, body string) (int, []Server) {
+	w := httptest.NewRecorder()
+	node.changeHandler(op)(w, httptest.NewRequest("POST", "/raft/"+op, strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	var servers []Server
+	if err := json.Unmarshal(w.Body.Bytes(), &servers); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, servers
+}
+
+// suffrage returns the suffrage of a server, or "" if it is not a member
+func suffrage(servers []Server, id string) string {
+	for _, s := range servers {
+		if s.ID == id {
+			return s.Suffrage
+		}
+	}
+	return ""
+}
+
+func TestMembership(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evml-raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodes := newTestCluster(t, dir, state.NewTestAccount(t), 3)
+	defer shutdownCluster(nodes)
+
+	leader, follower := waitForLeader(t, nodes)
+	var other *Raft
+	for _, node := range nodes {
+		if node != leader && node != follower {
+			other = node
+		}
+	}
+	id := string(other.config.LocalID)
+	address := other.config.NodeAddr
+
+	// The follower forwards changes to the leader
+	if err := follower.changeConfiguration(ConfigurationChange{Op: DemoteVoter, ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	servers, err := leader.configuration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := suffrage(servers, id); s != "Nonvoter" {
+		t.Fatalf("Demoted server should be a Nonvoter, not %q", s)
+	}
+
+	// The admin endpoints return the configuration that results from the
+	// change
+	code, servers := postChange(t, follower, AddVoter, `{"id": "`+id+`", "address": "`+address+`"}`)
+	if code != http.StatusOK || suffrage(servers, id) != "Voter" {
+		t.Fatalf("Promoted server should be a Voter, not %d %v", code, servers)
+	}
+	leaders := 0
+	for _, s := range servers {
+		if s.Leader {
+			leaders++
+		}
+	}
+	if len(servers) != 3 || leaders != 1 {
+		t.Fatalf("Configuration should have 3 servers and 1 leader, not %v", servers)
+	}
+
+	// Changes without the arguments of their operation are refused
+	if code, _ := postChange(t, follower, AddNonvoter, `{"id": "node9"}`); code != http.StatusBadRequest {
+		t.Fatalf("Change without address should be answered with 400, not %d", code)
+	}
+	if code, _ := postChange(t, follower, RemoveServer, `{}`); code != http.StatusBadRequest {
+		t.Fatalf("Change without id should be answered with 400, not %d", code)
+	}
+	if code, _ := postChange(t, follower, RemoveServer, `not json`); code != http.StatusBadRequest {
+		t.Fatalf("Malformed change should be answered with 400, not %d", code)
+	}
+
+	code, servers = postChange(t, follower, RemoveServer, `{"id": "`+id+`"}`)
+	if code != http.StatusOK || len(servers) != 2 || suffrage(servers, id) != "" {
+		t.Fatalf("Removed server should not be a member, not %d %v", code, servers)
+	}
+}
@@ -1,9 +1,12 @@
 package raft
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"time"
 
 	"github.com/bear987978897/evm-lite/src/config"
@@ -16,21 +19,28 @@ import (
 // Raft implements the Consensus interface.
 // It uses Hashicorp Raft
 type Raft struct {
-	config    config.RaftConfig
-	service   *service.Service
-	fsm       _raft.FSM
-	raftNode  *_raft.Raft
-	logger    *logrus.Entry
-	terminate chan os.Signal
-	txIndex   int
+	config        config.RaftConfig
+	state         *state.State
+	service       *service.Service
+	fsm           _raft.FSM
+	raftNode      *_raft.Raft
+	stream        *muxStreamLayer
+	forwardClient *forwardClient
+	adminListener net.Listener
+	txCh          chan blockTx
+	logger        *logrus.Entry
+	terminate     chan os.Signal
+	txIndex       int
 }
 
 // NewRaft returns a new Raft object
 func NewRaft(config config.RaftConfig, logger *logrus.Logger) *Raft {
 	return &Raft{
-		config:    config,
-		logger:    logger.WithField("module", "raft"),
-		terminate: make(chan os.Signal, 1),
+		config:        config,
+		forwardClient: &forwardClient{},
+		txCh:          make(chan blockTx, txBuffer),
+		logger:        logger.WithField("module", "raft"),
+		terminate:     make(chan os.Signal, 1),
 	}
 }
 
@@ -43,6 +53,7 @@ func (r *Raft) Init(state *state.State, service *service.Service) error {
 
 	r.logger.Debug("INIT")
 
+	r.state = state
 	r.service = service
 
 	r.fsm = NewFSM(state, r.logger)
@@ -52,16 +63,19 @@ func (r *Raft) Init(state *state.State, service *service.Service) error {
 	//TODO: Use r.config
 	config := _raft.DefaultConfig()
 	config.LocalID = r.config.LocalID
-
-	// Setup Raft communication.
-	transport, err := _raft.NewTCPTransport(r.config.NodeAddr,
-		nil,
-		3,
-		10*time.Second,
-		os.Stderr)
+	config.ShutdownOnRemove = r.config.ShutdownOnRemove
+	config.TrailingLogs = r.config.TrailingLogs
+	config.SnapshotInterval = r.config.SnapshotInterval
+	config.SnapshotThreshold = r.config.SnapshotThreshold
+
+	// Setup Raft communication. Followers forward transactions to the leader
+	// over the same address.
+	stream, err := newMuxStreamLayer(r.config.NodeAddr)
 	if err != nil {
 		return err
 	}
+	r.stream = stream
+	transport := _raft.NewNetworkTransport(stream, 3, 10*time.Second, os.Stderr)
 
 	// Create the snapshot store. This allows the Raft to truncate the log.
 	snapshots, err := _raft.NewFileSnapshotStore(r.config.SnapshotDir, 1, os.Stderr)
@@ -70,9 +84,21 @@ func (r *Raft) Init(state *state.State, service *service.Service) error {
 	}
 
 	// Create the log store and stable store.
-	// TODO: Add option for persistent store
-	logStore := _raft.NewInmemStore()
-	stableStore := _raft.NewInmemStore()
+	logStore, stableStore, err := r.newStores()
+	if err != nil {
+		return err
+	}
+
+	hasState, err := _raft.HasExistingState(logStore, stableStore, snapshots)
+	if err != nil {
+		return err
+	}
+
+	if hasState {
+		if err := r.checkState(state, logStore, snapshots); err != nil {
+			return err
+		}
+	}
 
 	// Instantiate the Raft systems.
 	ra, err := _raft.NewRaft(config, r.fsm, logStore, stableStore, snapshots, transport)
@@ -80,45 +106,127 @@ func (r *Raft) Init(state *state.State, service *service.Service) error {
 		return fmt.Errorf("new raft: %s", err)
 	}
 
-	//TODO: We should be using the new dynmamic membership protocol
-	configuration, err := _raft.ReadConfigJSON(fmt.Sprintf("%s/peers.json", r.config.RaftDir))
-	if err != nil {
-		return fmt.Errorf("Unable to create cluster configuration from peers.json: %v", err)
+	// A node that restarts with its log resumes from its stored configuration.
+	// New nodes bootstrap the cluster from peers.json; without it, they wait
+	// to be added to an existing cluster through its membership API.
+	if !hasState {
+		peersFile := filepath.Join(r.config.RaftDir, "peers.json")
+		if _, err := os.Stat(peersFile); os.IsNotExist(err) {
+			r.logger.Info("No peers.json, waiting to be added to a cluster")
+		} else {
+			configuration, err := _raft.ReadConfigJSON(peersFile)
+			if err != nil {
+				return fmt.Errorf("Unable to create cluster configuration from peers.json: %v", err)
+			}
+			if err := ra.BootstrapCluster(configuration).Error(); err != nil {
+				return fmt.Errorf("bootstrap cluster: %s", err)
+			}
+		}
 	}
-	ra.BootstrapCluster(configuration)
 
 	r.raftNode = ra
 
+	// Transactions can fail to reach the leader: the transaction endpoints
+	// wait for their submission, which can queue behind another batch
+	service.SetSubmitTimeout(2 * forwardTimeout)
+
+	return r.listenAdmin()
+}
+
+// newStores returns the Raft log store and stable store selected by the
+// configuration
+func (r *Raft) newStores() (_raft.LogStore, _raft.StableStore, error) {
+	switch r.config.Store {
+	case "inmem":
+		r.logger.Warn("Raft log is kept in memory and will be lost on restart")
+		return _raft.NewInmemStore(), _raft.NewInmemStore(), nil
+	case "leveldb":
+		store, err := NewLevelDBStore(filepath.Join(r.config.RaftDir, "raftdb"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("leveldb store: %s", err)
+		}
+		return store, store, nil
+	default:
+		return nil, nil, fmt.Errorf("Unknown Raft store %q", r.config.Store)
+	}
+}
+
+// checkState verifies that the State is not ahead of the Raft log it restarts
+// with. Raft replays the entries of its log from the last snapshot, and the
+// FSM skips those that the State already applied.
+func (r *Raft) checkState(state *state.State,
+	logStore _raft.LogStore,
+	snapshots _raft.SnapshotStore) error {
+
+	lastIndex, err := logStore.LastIndex()
+	if err != nil {
+		return err
+	}
+
+	snaps, err := snapshots.List()
+	if err != nil {
+		return err
+	}
+	if len(snaps) > 0 && snaps[0].Index > lastIndex {
+		lastIndex = snaps[0].Index
+	}
+
+	if err := state.CheckIndex(int64(lastIndex)); err != nil {
+		return err
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"last_log_index": lastIndex,
+		"last_applied":   state.LastIndex(),
+	}).Info("Resuming Raft log")
+
 	return nil
 }
 
 // Run starts the Raft node and service
 func (r *Raft) Run() error {
 
-	// Relay submitCh to Raft
+	go r.serveForward()
+	go r.serveAdmin()
+	go r.buildBlocks()
+
+	// Relay submitCh to Raft, through the leader if this node is a follower.
+	// Transactions are submitted in batches, one batch at a time so that they
+	// stay in order: those read during a submission make the next batch. A
+	// batch holds at most what the leader can buffer.
 	submitCh := r.service.GetSubmitCh()
+	batches := make(chan [][]byte)
+	go func() {
+		for txs := range batches {
+			r.submitTxs(txs)
+		}
+	}()
+
 	signal.Notify(r.terminate, os.Interrupt)
+	var batch [][]byte
 	for {
+		var (
+			in   = submitCh
+			next chan [][]byte
+		)
+		if len(batch) >= txBuffer {
+			in = nil
+		}
+		if len(batch) > 0 {
+			next = batches
+		}
+
 		select {
-		case t := <-submitCh:
+		case t := <-in:
 			r.logger.WithFields(logrus.Fields{
 				"tx":    r.txIndex,
 				"state": r.raftNode.State(),
 			}).Debug("Adding Transaction")
 
-			if r.raftNode.State() != _raft.Leader {
-				r.logger.Debug("NOT LEADER")
-				//TODO: Relay message to leader
-				break
-			}
-
-			f := r.raftNode.Apply(t, r.config.CommitTimeout)
-			if err := f.Error(); err != nil {
-				r.logger.WithError(err).Error("Applying Raft tx")
-				break
-			}
-
+			batch = append(batch, t)
 			r.txIndex++
+		case next <- batch:
+			batch = nil
 		case <-r.terminate:
 			r.logger.Debug("Raft exiting")
 			return nil
@@ -126,9 +234,21 @@ func (r *Raft) Run() error {
 	}
 }
 
-// Info returns Raft stats
+// Info returns Raft stats, the leader and the members of the cluster
 func (r *Raft) Info() (map[string]string, error) {
 	info := r.raftNode.Stats()
 	info["type"] = "raft"
+	info["leader"] = string(r.raftNode.Leader())
+
+	servers, err := r.configuration()
+	if err != nil {
+		return nil, err
+	}
+	js, err := json.Marshal(servers)
+	if err != nil {
+		return nil, err
+	}
+	info["configuration"] = string(js)
+
 	return info, nil
 }
@@ -0,0 +1,192 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+
+	_raft "github.com/hashicorp/raft"
+	"github.com/syndtr/goleveldb/leveldb"
+	ldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	logPrefix    = []byte("l") // logPrefix + index (uint64 big endian) -> log
+	stablePrefix = []byte("s") // stablePrefix + key -> value
+
+	// Raft matches this message to tell missing keys from errors
+	errNotFound = errors.New("not found")
+
+	// Writes are synced: Raft relies on the term, vote and log entries it
+	// stores having reached the disk before it answers its peers.
+	syncWrite = &opt.WriteOptions{Sync: true}
+)
+
+// LevelDBStore is a Raft LogStore and StableStore backed by LevelDB
+type LevelDBStore struct {
+	db *leveldb.DB
+}
+
+// NewLevelDBStore opens, or creates, a LevelDBStore in the given directory
+func NewLevelDBStore(path string) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if _, corrupted := err.(*ldbErrors.ErrCorrupted); corrupted {
+		db, err = leveldb.RecoverFile(path, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBStore{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *LevelDBStore) Close() error {
+	return s.db.Close()
+}
+
+/*******************************************************************************
+IMPLEMENT RAFT LOGSTORE INTERFACE
+*******************************************************************************/
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (s *LevelDBStore) FirstIndex() (uint64, error) {
+	it := s.db.NewIterator(util.BytesPrefix(logPrefix), nil)
+	defer it.Release()
+
+	if !it.First() {
+		return 0, it.Error()
+	}
+	return decodeIndex(it.Key()), nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (s *LevelDBStore) LastIndex() (uint64, error) {
+	it := s.db.NewIterator(util.BytesPrefix(logPrefix), nil)
+	defer it.Release()
+
+	if !it.Last() {
+		return 0, it.Error()
+	}
+	return decodeIndex(it.Key()), nil
+}
+
+// GetLog gets a log entry at a given index
+func (s *LevelDBStore) GetLog(index uint64, log *_raft.Log) error {
+	data, err := s.db.Get(logKey(index), nil)
+	if err == leveldb.ErrNotFound {
+		return _raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeLog(index, data, log)
+}
+
+// StoreLog stores a log entry
+func (s *LevelDBStore) StoreLog(log *_raft.Log) error {
+	return s.StoreLogs([]*_raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries atomically
+func (s *LevelDBStore) StoreLogs(logs []*_raft.Log) error {
+	batch := new(leveldb.Batch)
+	for _, log := range logs {
+		batch.Put(logKey(log.Index), encodeLog(log))
+	}
+	return s.db.Write(batch, syncWrite)
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+func (s *LevelDBStore) DeleteRange(min, max uint64) error {
+	batch := new(leveldb.Batch)
+	it := s.db.NewIterator(&util.Range{Start: logKey(min), Limit: logKey(max + 1)}, nil)
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return s.db.Write(batch, syncWrite)
+}
+
+/*******************************************************************************
+IMPLEMENT RAFT STABLESTORE INTERFACE
+*******************************************************************************/
+
+// Set stores a value
+func (s *LevelDBStore) Set(key []byte, val []byte) error {
+	return s.db.Put(stableKey(key), val, syncWrite)
+}
+
+// Get returns the value for key, or an error matching "not found" if key was
+// not found.
+func (s *LevelDBStore) Get(key []byte) ([]byte, error) {
+	val, err := s.db.Get(stableKey(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, errNotFound
+	}
+	return val, err
+}
+
+// SetUint64 stores a uint64 value
+func (s *LevelDBStore) SetUint64(key []byte, val uint64) error {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, val)
+	return s.Set(key, enc)
+}
+
+// GetUint64 returns the uint64 value for key, or 0 if key was not found
+func (s *LevelDBStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err == errNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, errors.New("invalid uint64 value")
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+/*******************************************************************************
+ENCODING
+*******************************************************************************/
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(logPrefix)+8)
+	copy(key, logPrefix)
+	binary.BigEndian.PutUint64(key[len(logPrefix):], index)
+	return key
+}
+
+func decodeIndex(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(logPrefix):])
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, stablePrefix...), key...)
+}
+
+// encodeLog = term (uint64 big endian) + type (1 byte) + data
+func encodeLog(log *_raft.Log) []byte {
+	enc := make([]byte, 9+len(log.Data))
+	binary.BigEndian.PutUint64(enc, log.Term)
+	enc[8] = byte(log.Type)
+	copy(enc[9:], log.Data)
+	return enc
+}
+
+func decodeLog(index uint64, enc []byte, log *_raft.Log) error {
+	if len(enc) < 9 {
+		return errors.New("invalid log entry")
+	}
+	log.Index = index
+	log.Term = binary.BigEndian.Uint64(enc)
+	log.Type = _raft.LogType(enc[8])
+	log.Data = append([]byte{}, enc[9:]...)
+	return nil
+}
@@ -0,0 +1,165 @@
+package raft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_raft "github.com/hashicorp/raft"
+)
+
+func newTestStore(t *testing.T) (*LevelDBStore, string) {
+	dir, err := ioutil.TempDir("", "evml-raftdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewLevelDBStore(filepath.Join(dir, "raftdb"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func testLog(index uint64) *_raft.Log {
+	return &_raft.Log{
+		Index: index,
+		Term:  index / 3,
+		Type:  _raft.LogCommand,
+		Data:  []byte{byte(index), 0xff},
+	}
+}
+
+func checkIndexes(t *testing.T, store *LevelDBStore, first, last uint64) {
+	t.Helper()
+	if got, err := store.FirstIndex(); err != nil || got != first {
+		t.Fatalf("FirstIndex: got %d, %v, want %d", got, err, first)
+	}
+	if got, err := store.LastIndex(); err != nil || got != last {
+		t.Fatalf("LastIndex: got %d, %v, want %d", got, err, last)
+	}
+}
+
+func checkLog(t *testing.T, store *LevelDBStore, index uint64) {
+	t.Helper()
+	var log _raft.Log
+	if err := store.GetLog(index, &log); err != nil {
+		t.Fatalf("GetLog %d: %v", index, err)
+	}
+	want := testLog(index)
+	if log.Index != want.Index || log.Term != want.Term || log.Type != want.Type ||
+		!bytes.Equal(log.Data, want.Data) {
+		t.Fatalf("GetLog %d: got %+v, want %+v", index, log, *want)
+	}
+}
+
+func TestLevelDBStoreLogs(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	// An empty store has no entries
+	checkIndexes(t, store, 0, 0)
+	var log _raft.Log
+	if err := store.GetLog(1, &log); err != _raft.ErrLogNotFound {
+		t.Fatalf("GetLog on empty store: got %v, want %v", err, _raft.ErrLogNotFound)
+	}
+
+	if err := store.StoreLog(testLog(1)); err != nil {
+		t.Fatal(err)
+	}
+	logs := []*_raft.Log{}
+	for i := uint64(2); i <= 300; i++ {
+		logs = append(logs, testLog(i))
+	}
+	if err := store.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Indexes are ordered numerically, not as strings
+	checkIndexes(t, store, 1, 300)
+	for _, i := range []uint64{1, 2, 255, 256, 300} {
+		checkLog(t, store, i)
+	}
+
+	// Compaction deletes from the start of the log
+	if err := store.DeleteRange(1, 255); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, store, 256, 300)
+	if err := store.GetLog(255, &log); err != _raft.ErrLogNotFound {
+		t.Fatalf("GetLog of deleted entry: got %v, want %v", err, _raft.ErrLogNotFound)
+	}
+	checkLog(t, store, 256)
+
+	// Conflicting entries are deleted from the end of the log
+	if err := store.DeleteRange(290, 300); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, store, 256, 289)
+
+	if err := store.DeleteRange(256, 289); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, store, 0, 0)
+}
+
+func TestLevelDBStoreStable(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	// Raft tells missing keys from errors by their message
+	if _, err := store.Get([]byte("missing")); err == nil || err.Error() != "not found" {
+		t.Fatalf("Get of missing key: got %v, want not found", err)
+	}
+	if val, err := store.GetUint64([]byte("missing")); err != nil || val != 0 {
+		t.Fatalf("GetUint64 of missing key: got %d, %v, want 0", val, err)
+	}
+
+	if err := store.Set([]byte("LastVoteCand"), []byte("node1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 42); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, err := store.Get([]byte("LastVoteCand")); err != nil || string(val) != "node1" {
+		t.Fatalf("Get: got %q, %v, want node1", val, err)
+	}
+	if val, err := store.GetUint64([]byte("CurrentTerm")); err != nil || val != 42 {
+		t.Fatalf("GetUint64: got %d, %v, want 42", val, err)
+	}
+
+	// Stable keys do not show up as log entries
+	checkIndexes(t, store, 0, 0)
+}
+
+func TestLevelDBStoreReopen(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	if err := store.StoreLogs([]*_raft.Log{testLog(5), testLog(6), testLog(7)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewLevelDBStore(filepath.Join(dir, "raftdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	checkIndexes(t, store, 5, 7)
+	checkLog(t, store, 6)
+	if val, err := store.GetUint64([]byte("CurrentTerm")); err != nil || val != 3 {
+		t.Fatalf("GetUint64 after reopen: got %d, %v, want 3", val, err)
+	}
+}
@@ -0,0 +1,163 @@
+package raft
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	_raft "github.com/hashicorp/raft"
+)
+
+// Connections to the Raft address start with a byte telling what they carry,
+// so that Raft and the transaction forwarding RPC share the same port.
+const (
+	raftConn    byte = 0x01
+	forwardConn byte = 0x02
+)
+
+const (
+	// connTypeTimeout is how long an accepted connection has to send its type
+	connTypeTimeout = 5 * time.Second
+	// acceptRetryDelay is how long to wait before accepting connections again
+	// after an error
+	acceptRetryDelay = 10 * time.Millisecond
+)
+
+var (
+	errNotAdvertisable = errors.New("local bind address is not advertisable")
+	errNotTCP          = errors.New("local address is not a TCP address")
+	errListenerClosed  = errors.New("listener closed")
+)
+
+// muxStreamLayer is a Raft StreamLayer over TCP that also accepts the
+// connections of the transaction forwarding RPC. Raft connections are returned
+// by Accept, and forwarding connections are sent to the forward channel.
+type muxStreamLayer struct {
+	listener net.Listener
+	raft     chan net.Conn
+	forward  chan net.Conn
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func newMuxStreamLayer(bindAddr string) (*muxStreamLayer, error) {
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify that we have a usable advertise address
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		listener.Close()
+		return nil, errNotTCP
+	}
+	if addr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, errNotAdvertisable
+	}
+
+	l := &muxStreamLayer{
+		listener: listener,
+		raft:     make(chan net.Conn),
+		forward:  make(chan net.Conn),
+		closeCh:  make(chan struct{}),
+	}
+
+	go l.listen()
+
+	return l, nil
+}
+
+func (l *muxStreamLayer) listen() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			select {
+			case <-l.closeCh:
+				return
+			case <-time.After(acceptRetryDelay):
+				continue
+			}
+		}
+		go l.dispatch(conn)
+	}
+}
+
+// dispatch reads the type of a connection and hands it over to Raft or to the
+// forwarding RPC server.
+func (l *muxStreamLayer) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(connTypeTimeout))
+	var connType [1]byte
+	if _, err := conn.Read(connType[:]); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var ch chan net.Conn
+	switch connType[0] {
+	case raftConn:
+		ch = l.raft
+	case forwardConn:
+		ch = l.forward
+	default:
+		conn.Close()
+		return
+	}
+
+	select {
+	case ch <- conn:
+	case <-l.closeCh:
+		conn.Close()
+	}
+}
+
+// dial opens a connection of the given type
+func (l *muxStreamLayer) dial(address string, connType byte, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{connType}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+/*******************************************************************************
+IMPLEMENT RAFT STREAMLAYER INTERFACE
+*******************************************************************************/
+
+// Dial implements the StreamLayer interface.
+func (l *muxStreamLayer) Dial(address _raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return l.dial(string(address), raftConn, timeout)
+}
+
+// Accept implements the net.Listener interface.
+func (l *muxStreamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.raft:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, errListenerClosed
+	}
+}
+
+// Close implements the net.Listener interface.
+func (l *muxStreamLayer) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		err = l.listener.Close()
+	})
+	return err
+}
+
+// Addr implements the net.Listener interface.
+func (l *muxStreamLayer) Addr() net.Addr {
+	return l.listener.Addr()
+}
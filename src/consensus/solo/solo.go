@@ -1,10 +1,9 @@
 package solo
 
 import (
-	"fmt"
 	"strconv"
+	"time"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/bear987978897/evm-lite/src/service"
 	"github.com/bear987978897/evm-lite/src/state"
 	"github.com/sirupsen/logrus"
@@ -32,13 +31,15 @@ func NewSolo(logger *logrus.Logger) *Solo {
 IMPLEMENT CONSENSUS INTERFACE
 *******************************************************************************/
 
-// Init sets the state and service
+// Init sets the state and service, and resumes the transaction index from the
+// last one committed to the state
 func (s *Solo) Init(state *state.State, service *service.Service) error {
 
 	s.logger.Debug("INIT")
 
 	s.state = state
 	s.service = service
+	s.txIndex = int(state.LastIndex() + 1)
 
 	return nil
 }
@@ -52,14 +53,14 @@ func (s *Solo) Run() error {
 		case t := <-submitCh:
 			s.logger.WithField("tx", s.txIndex).Debug("Adding Transaction")
 
-			err := s.state.ApplyTransaction(t,
-				s.txIndex,
-				common.BytesToHash([]byte(fmt.Sprintf("block %d", s.txIndex))))
+			s.state.SetBlockTime(time.Now())
+
+			err := s.state.ApplyTransaction(t)
 			if err != nil {
 				s.logger.WithField("tx", s.txIndex).WithError(err).Errorf("ApplyTransaction")
 			}
 
-			hash, err := s.state.Commit()
+			hash, err := s.state.Commit(int64(s.txIndex))
 			if err != nil {
 				s.logger.WithField("tx", s.txIndex).WithError(err).Errorf("Commit")
 			}
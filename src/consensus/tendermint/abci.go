@@ -1,161 +1,378 @@
 package tendermint
 
 import (
-	"encoding/hex"
+	"math"
 	"time"
-	"bytes"  //update
+
 	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/bear987978897/evm-lite/src/version"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/sirupsen/logrus"
 	"github.com/tendermint/tendermint/abci/types"
-	ethTypes "github.com/ethereum/go-ethereum/core/types" //update
-	"github.com/ethereum/go-ethereum/rlp" //update
-	"fmt"
-	"os"
+	cmn "github.com/tendermint/tendermint/libs/common"
 )
 
-var path1 = "/home/caideyi/TendermintOnEvm_benchmark/data/blockCommitTime.txt"
-var path2 = "/home/caideyi/TendermintOnEvm_benchmark/data/blockTxNum.txt"
-var path3 = "/home/caideyi/TendermintOnEvm_benchmark/data/rawTx"
-var path4 = "/home/caideyi/TendermintOnEvm_benchmark/data/erro"
-
 type ABCIProxy struct {
 	types.BaseApplication
 
 	state     *state.State
 	logger    *logrus.Entry
 	blockHash common.Hash
-	txIndex   int
+	height    int64
+	replaying bool
+	metrics   MetricsSink
+
+	// Transactions delivered in the block, and those of them that were
+	// rejected
+	txCount    int
+	rejectedTx int
+
+	// staking is the address of the contract whose ValidatorUpdate events
+	// update the validator set, if any. validatorSet is the set that results
+	// from the updates returned so far; it is nil until InitChain, or the
+	// first BeginBlock after a restart.
+	staking      *common.Address
+	validators   *validatorUpdates
+	validatorSet validatorSet
 }
 
 func NewABCIProxy(
 	state *state.State,
+	metrics MetricsSink,
+	staking *common.Address,
 	logger *logrus.Logger,
 ) *ABCIProxy {
 	return &ABCIProxy{
-		state:     state,
-		logger:    logger.WithField("module", "tendermint/abci"),
-		blockHash: common.Hash{},
-		txIndex:   0,
+		state:      state,
+		logger:     logger.WithField("module", "tendermint/abci"),
+		blockHash:  common.Hash{},
+		metrics:    metrics,
+		staking:    staking,
+		validators: newValidatorUpdates(),
 	}
 }
 
 /********************************************************
 Implement Tendermint ABCI application
 *********************************************************/
+
+// Info tells Tendermint the height and app hash of the last block committed to
+// the State, so that it replays the blocks that come after it when it
+// restarts. The app hash of a block is the state root that results from it.
+func (p *ABCIProxy) Info(req types.RequestInfo) types.ResponseInfo {
+	res := types.ResponseInfo{
+		Data:    "evm-lite",
+		Version: version.Version,
+	}
+
+	lastIndex := p.state.LastIndex()
+	if lastIndex < 0 {
+		return res
+	}
+
+	root, err := p.state.GetIndexRoot(lastIndex)
+	if err != nil {
+		p.logger.Panic("Info Error: ", err)
+	}
+	res.LastBlockHeight = lastIndex
+	res.LastBlockAppHash = root.Bytes()
+
+	p.logger.WithFields(logrus.Fields{
+		"height":   res.LastBlockHeight,
+		"app_hash": root.Hex(),
+	}).Debug("Info")
+
+	return res
+}
+
+// InitChain is called once, when Tendermint starts the chain. The app_state of
+// the Tendermint genesis can carry the genesis accounts and chain ID, in the
+// format of the EVM-Lite genesis file. The accounts must be those of the State's
+// genesis block, or the State's genesis block must have no accounts, in which
+// case it is replaced by one with them. The other fields of the genesis block
+// are those of the State's genesis file. The genesis validators of Tendermint
+// are kept, and the gas of blocks is capped to the block gas limit of the
+// State.
+func (p *ABCIProxy) InitChain(req types.RequestInitChain) types.ResponseInitChain {
+	p.logger.WithFields(logrus.Fields{
+		"chain_id":   req.ChainId,
+		"validators": len(req.Validators),
+	}).Debug("InitChain")
+
+	res := types.ResponseInitChain{
+		ConsensusParams: p.consensusParams(req.ConsensusParams),
+	}
+
+	validators, err := newValidatorSet(req.Validators)
+	if err != nil {
+		p.logger.Panic("InitChain Error: validators: ", err)
+	}
+	p.validatorSet = validators
+
+	if len(req.AppStateBytes) == 0 {
+		return res
+	}
+
+	genesis, err := state.ParseGenesis(req.AppStateBytes)
+	if err != nil {
+		p.logger.Panic("InitChain Error: app_state: ", err)
+	}
+	if genesis.Config != nil && genesis.Config.ChainID != nil &&
+		genesis.Config.ChainID.Cmp(p.state.GetChainID()) != 0 {
+		p.logger.Panicf("InitChain Error: chain ID %v of app_state disagrees with chain ID %v of the State",
+			genesis.Config.ChainID, p.state.GetChainID())
+	}
+	if len(genesis.Alloc) > 0 {
+		if err := p.state.InitGenesis(genesis.Alloc); err != nil {
+			p.logger.Panic("InitChain Error: ", err)
+		}
+	}
+	p.logger.WithField("hash", p.state.GetGenesisHash().Hex()).Info("InitChain genesis block")
+
+	return res
+}
+
+// consensusParams returns the consensus parameters that cap the gas of blocks
+// to the block gas limit of the State, so that proposers only put in a block
+// the transactions whose gas fits in it. It returns nil if the gas of blocks is
+// already capped as much.
+func (p *ABCIProxy) consensusParams(params *types.ConsensusParams) *types.ConsensusParams {
+	if params == nil || params.BlockSize == nil {
+		return nil
+	}
+
+	gasLimit := p.state.GetGasLimit()
+	if gasLimit > math.MaxInt64 {
+		return nil
+	}
+	if maxGas := params.BlockSize.MaxGas; maxGas >= 0 && uint64(maxGas) <= gasLimit {
+		return nil
+	}
+
+	blockSize := *params.BlockSize
+	blockSize.MaxGas = int64(gasLimit)
+	p.logger.WithField("max_gas", blockSize.MaxGas).Debug("Capping block gas")
+
+	return &types.ConsensusParams{BlockSize: &blockSize}
+}
+
 func (p *ABCIProxy) BeginBlock(req types.RequestBeginBlock) types.ResponseBeginBlock {
 	p.blockHash = common.BytesToHash(req.Hash)
+	p.height = req.Header.Height
+	p.state.SetBlockTime(req.Header.Time)
 
 	p.logger.Debug("Begin block: ", p.blockHash.String())
 
+	// Tendermint replays blocks the State has already applied when it restarts.
+	// They are skipped. A gap, on the other hand, means the State was built
+	// from a different chain.
+	lastIndex := p.state.LastIndex()
+	p.replaying = p.height <= lastIndex
+	p.validators = newValidatorUpdates()
+	if lastIndex >= 0 && p.height > lastIndex+1 {
+		p.logger.Panicf("State is behind Tendermint: last committed height is %d, got block %d", lastIndex, p.height)
+	}
+
+	// After a restart, the validator set starts from the validators of the
+	// last block, which do not include the updates of the two blocks before it
+	// yet
+	if p.validatorSet == nil && len(req.LastCommitInfo.Votes) > 0 {
+		p.validatorSet = make(validatorSet)
+		for _, vote := range req.LastCommitInfo.Votes {
+			p.validatorSet[string(vote.Validator.Address)] = vote.Validator.Power
+		}
+	}
+
 	return types.ResponseBeginBlock{}
 }
 
+// CheckTx admits transactions to Tendermint's mempool. Transactions rejected
+// by the State's TxPool are reported with the code of their state.TxError, so
+// that they are not gossiped nor proposed.
 func (p *ABCIProxy) CheckTx(tx []byte) types.ResponseCheckTx {
 
-	var t ethTypes.Transaction
-	if err := rlp.Decode(bytes.NewReader(tx), &t); err != nil {
-		p.logger.WithError(err).Error("Decoding Transaction")
-		return types.ResponseCheckTx{Code: types.CodeTypeOK}
-	}
-	// p.logger.Debug("ethTypes.Transaction ABCI", &t)
-	
-	// p.logger.WithFields(logrus.Fields{
-	// 	"hash":     t.Hash().Hex(),
-	// 	"to":       t.To(),
-	// 	"payload":  fmt.Sprintf("%x", t.Data()),
-	// 	"gas":      t.Gas(),
-	// 	"gasPrice": t.GasPrice(),
-	// 	"nonce":    t.Nonce(),
-	// 	"value":    t.Value(),
-	// }).Debug("Service decoded tx ABCI")
-
-	if err := p.state.CheckTx(&t); err != nil {
-		p.logger.WithError(err).Error("Checking Transaction")
-		return types.ResponseCheckTx{Code: types.CodeTypeOK}
-	}
-
-	return types.ResponseCheckTx{Code: types.CodeTypeOK}
+	t, err := state.DecodeTx(tx)
+	if err == nil {
+		err = p.state.CheckTx(t)
+	}
+	if err != nil {
+		p.logger.WithError(err).Debug("Rejected Transaction")
+		code := state.CodeInvalidTx
+		if txErr, ok := err.(*state.TxError); ok {
+			code = txErr.Code
+		}
+		return types.ResponseCheckTx{Code: code, Log: err.Error()}
+	}
+
+	return types.ResponseCheckTx{Code: types.CodeTypeOK, GasWanted: int64(t.Gas())}
 }
 
+// DeliverTx applies a transaction to the State. Transactions that cannot be
+// applied are answered with the code of their state.TxError, and leave the
+// state unchanged. Transactions whose execution fails are applied nonetheless,
+// and pay for their gas, but are answered with state.CodeExecutionFailed and
+// the error in the log.
+//
+// The data of the response is the address of the contract created by the
+// transaction, or the data returned by its execution. Its tags are those of
+// its receipt (see txTags), so that Tendermint's tx indexer can search EVM
+// transactions.
 func (p *ABCIProxy) DeliverTx(tx []byte) types.ResponseDeliverTx {
-	err := p.state.ApplyTransaction(tx, p.txIndex, p.blockHash)
-
-	//p.logger.Debug("TxByteCode: ", tx)
-/*	file3 , err3 := os.OpenFile(path3, os.O_APPEND|os.O_WRONLY, 0600)
-	if err3 != nil{
-		p.logger.Panic("File Erro: ",err3)
-		return types.ResponseDeliverTx{}
+	if p.replaying {
+		return types.ResponseDeliverTx{Code: types.CodeTypeOK}
 	}
-	defer file3.Close()
-	file3.WriteString(fmt.Sprintf("%s\n",tx))
-*/
 
+	p.txCount++
+
+	res, err := p.state.DeliverTransaction(tx)
 	if err != nil {
+		p.rejectedTx++
+		p.logger.WithError(err).Debug("Rejected Transaction")
+		code := state.CodeInvalidTx
+		if txErr, ok := err.(*state.TxError); ok {
+			code = txErr.Code
+		}
+		return types.ResponseDeliverTx{Code: code, Log: err.Error()}
+	}
 
-/*		file4 , err4 := os.OpenFile(path4, os.O_APPEND|os.O_WRONLY,0600)
-		if err4 != nil{
-			p.logger.Panic("File Erro" , err4)
-			return types.ResponseDeliverTx{}
+	p.logger.WithField("hash", res.Receipt.TxHash.Hex()).Debug("DeliverTx")
+
+	if p.staking != nil {
+		for _, log := range res.Receipt.Logs {
+			if !p.validators.add(*p.staking, log) {
+				p.logger.WithField("hash", res.Receipt.TxHash.Hex()).Warn("Ignored malformed ValidatorUpdate event")
+			}
 		}
-		defer file4.Close()
-		file4.WriteString(fmt.Sprintf("%s\n",tx))
+	}
+
+	resp := types.ResponseDeliverTx{
+		Code:      types.CodeTypeOK,
+		Data:      res.Return,
+		GasWanted: int64(res.Tx.Gas()),
+		GasUsed:   int64(res.Receipt.GasUsed),
+		Tags:      txTags(res),
+	}
+	if res.Error != nil {
+		resp.Code = state.CodeExecutionFailed
+		resp.Log = res.Error.Error()
+	} else if res.Receipt.ContractAddress != (common.Address{}) {
+		resp.Data = res.Receipt.ContractAddress.Bytes()
+	}
+
+	return resp
+}
+
+// Tags of delivered transactions. Values are 0x-prefixed hex strings, and
+// eth.log.address and eth.log.topic are repeated for every log.
+const (
+	TagHash       = "eth.hash"
+	TagFrom       = "eth.from"
+	TagTo         = "eth.to"
+	TagContract   = "eth.contract"
+	TagLogAddress = "eth.log.address"
+	TagLogTopic   = "eth.log.topic"
+)
+
+// txTags returns the tags of a delivered transaction: its hash, sender,
+// recipient or created contract, and the addresses and topics of the logs it
+// emitted. The hash is the Ethereum one; Tendermint reserves tx.hash for the
+// hash of the raw transaction.
+func txTags(res *state.TxResult) []cmn.KVPair {
+	tag := func(key string, value []byte) cmn.KVPair {
+		return cmn.KVPair{Key: []byte(key), Value: []byte(hexutil.Encode(value))}
+	}
 
-*/		p.logger.Panic("DeliverTx Error: ", err)
-		p.logger.Debug("ERRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRRO" , tx)
-		return types.ResponseDeliverTx{Code: 1}
+	receipt := res.Receipt
+	tags := []cmn.KVPair{
+		tag(TagHash, receipt.TxHash.Bytes()),
+		tag(TagFrom, res.From.Bytes()),
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		tags = append(tags, tag(TagContract, receipt.ContractAddress.Bytes()))
+	} else if to := res.Tx.To(); to != nil {
+		tags = append(tags, tag(TagTo, to.Bytes()))
+	}
 
+	// The same address or topic is only tagged once
+	seen := make(map[string]bool)
+	add := func(key string, value []byte) {
+		t := tag(key, value)
+		if k := key + string(t.Value); !seen[k] {
+			seen[k] = true
+			tags = append(tags, t)
+		}
+	}
+	for _, log := range receipt.Logs {
+		add(TagLogAddress, log.Address.Bytes())
+		for _, topic := range log.Topics {
+			add(TagLogTopic, topic.Bytes())
+		}
 	}
-	p.txIndex++
-	dst := make([]byte, hex.DecodedLen(len(tx)))
-	txHex, _ := hex.Decode(dst, tx)
 
-	p.logger.Debug("DeliverTx: ", txHex)
+	return tags
+}
 
-	return types.ResponseDeliverTx{Code: types.CodeTypeOK}
+// EndBlock returns the validator updates emitted by the staking contract in
+// the block, without the removals that Tendermint would refuse
+func (p *ABCIProxy) EndBlock(req types.RequestEndBlock) types.ResponseEndBlock {
+	updates, ignored := p.validators.list(p.validatorSet)
+	if ignored > 0 {
+		p.logger.WithField("removals", ignored).Warn("Ignored removals of validators that are not in the set, or of the last one")
+	}
+	if len(updates) > 0 {
+		p.logger.WithField("updates", len(updates)).Debug("Validator updates")
+	}
+	if p.validatorSet != nil {
+		if err := p.validatorSet.apply(updates); err != nil {
+			p.logger.Panic("EndBlock Error: ", err)
+		}
+	}
+
+	return types.ResponseEndBlock{ValidatorUpdates: updates}
 }
 
+// Commit commits the block to the State, with the block height as consensus
+// index, and returns the resulting state root as the app hash. Tendermint
+// includes it in the next block, so that validators whose states diverge
+// cannot agree on it.
 func (p *ABCIProxy) Commit() types.ResponseCommit {
 
+	// Blocks that were already applied are answered with the state root they
+	// produced the first time around
+	if p.replaying {
+		p.logger.Debug("Skipped block already applied: ", p.height)
+		p.txCount, p.rejectedTx = 0, 0
+		root, err := p.state.GetIndexRoot(p.height)
+		if err != nil {
+			p.logger.Panic("Commit Error: ", err)
+		}
+		return types.ResponseCommit{Data: root.Bytes()}
+	}
 
-	hash, err := p.state.Commit()
-
+	start := time.Now()
+	hash, err := p.state.Commit(p.height)
 	if err != nil {
 		p.logger.Panic("Commit Error: ", err)
 		return types.ResponseCommit{}
 	}
+	end := time.Now()
 	p.logger.Debug("Block commited: ", hash)
 
-	if p.txIndex != 0 {
-
-		p.logger.Debug("Debug Block commited: ", p.txIndex)
-		m := makeTimestampMilli()
-		file , err1 := os.OpenFile(path1, os.O_APPEND|os.O_WRONLY, 0600)
-		file2, err2 := os.OpenFile(path2, os.O_APPEND|os.O_WRONLY, 0600)
-		if err1 != nil {
-			p.logger.Panic("File Error: ",err1)
-			return types.ResponseCommit{}
+	if p.txCount != 0 {
+		err := p.metrics.Record(BlockMetrics{
+			Height:     p.height,
+			Timestamp:  unixMilli(end),
+			TxCount:    p.txCount,
+			RejectedTx: p.rejectedTx,
+			GasUsed:    p.state.CurrentBlock().GasUsed(),
+			Latency:    float64(end.Sub(start)) / float64(time.Millisecond),
+		})
+		if err != nil {
+			p.logger.WithError(err).Error("Recording block metrics")
 		}
-		if err2 != nil {
-			p.logger.Panic("File2 Error: ",err2)
-			return types.ResponseCommit{}
-		}
-		defer file.Close()
-		defer file2.Close()
-		file2.WriteString(fmt.Sprintf("%d\n",p.txIndex))
-		file.WriteString(fmt.Sprintf("%d\n", m))
-
 	}
-	m := makeTimestampMilli()
-	p.logger.Debug("Time: ",time.Unix(m/1e3, (m%1e3)*int64(time.Millisecond)/int64(time.Nanosecond)))
-	p.txIndex = 0
-	return types.ResponseCommit{}
-}
-
-func unixMilli(t time.Time) int64 {
-	return t.Round(time.Millisecond).UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
-}
 
-func makeTimestampMilli() int64 {
-	return unixMilli(time.Now())
+	p.txCount, p.rejectedTx = 0, 0
+	return types.ResponseCommit{Data: hash.Bytes()}
 }
@@ -0,0 +1,413 @@
+package tendermint
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// testProxy is an ABCIProxy on top of a State whose genesis funds the account
+// of key
+type testProxy struct {
+	*ABCIProxy
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	dir     string
+	metrics *recordingSink
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	dir, err := ioutil.TempDir("", "evml-abci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := state.NewTestAccount(t)
+	s := account.NewState(t, dir, "")
+
+	metrics := &recordingSink{}
+	return &testProxy{
+		ABCIProxy: NewABCIProxy(s, metrics, nil, bcommon.NewTestLogger(t)),
+		key:       account.Key,
+		from:      account.Address,
+		dir:       dir,
+		metrics:   metrics,
+	}
+}
+
+func (tp *testProxy) close() {
+	os.RemoveAll(tp.dir)
+}
+
+// tx returns an encoded transaction signed by the funded account. It creates
+// a contract with data as code if to is nil.
+func (tp *testProxy) tx(t *testing.T, nonce uint64, to *common.Address, data []byte) []byte {
+	var tx *ethTypes.Transaction
+	if to == nil {
+		tx = ethTypes.NewContractCreation(nonce, big.NewInt(0), 100000, big.NewInt(0), data)
+	} else {
+		tx = ethTypes.NewTransaction(nonce, *to, big.NewInt(1), 100000, big.NewInt(0), data)
+	}
+	tx, err := ethTypes.SignTx(tx, tp.state.GetSigner(), tp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+// block delivers a block of transactions at height, as Tendermint does, and
+// returns the responses to its transactions and to its commit
+func (tp *testProxy) block(height int64, txs ...[]byte) ([]types.ResponseDeliverTx, types.ResponseCommit) {
+	tp.BeginBlock(types.RequestBeginBlock{
+		Header: types.Header{Height: height, Time: time.Unix(1548000000+height, 0)},
+	})
+	var res []types.ResponseDeliverTx
+	for _, tx := range txs {
+		res = append(res, tp.DeliverTx(tx))
+	}
+	tp.EndBlock(types.RequestEndBlock{Height: height})
+	return res, tp.Commit()
+}
+
+// recordingSink keeps the block metrics it records
+type recordingSink struct {
+	records []BlockMetrics
+}
+
+func (s *recordingSink) Record(m BlockMetrics) error {
+	s.records = append(s.records, m)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestMetrics(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	to := common.HexToAddress("b0b")
+
+	// Blocks without transactions are not recorded
+	tp.block(1)
+
+	// Rejected transactions are counted
+	tp.block(2, tp.tx(t, 0, &to, nil), tp.tx(t, 5, &to, nil), []byte{1, 2, 3})
+
+	// Blocks whose transactions were all rejected are recorded too
+	tp.block(3, tp.tx(t, 0, &to, nil))
+
+	if len(tp.metrics.records) != 2 {
+		t.Fatalf("Metrics of 2 blocks should be recorded, not %d", len(tp.metrics.records))
+	}
+	expected := []struct {
+		height     int64
+		txCount    int
+		rejectedTx int
+		gasUsed    uint64
+	}{
+		{2, 3, 2, 21000},
+		{3, 1, 1, 0},
+	}
+	for i, e := range expected {
+		m := tp.metrics.records[i]
+		if m.Height != e.height || m.TxCount != e.txCount || m.RejectedTx != e.rejectedTx || m.GasUsed != e.gasUsed {
+			t.Fatalf("Metrics of block %d should have %d txs, %d rejected, %d gas, not %+v",
+				e.height, e.txCount, e.rejectedTx, e.gasUsed, m)
+		}
+	}
+}
+
+func TestInfoAndReplay(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	// Tendermint starts from scratch when the State has no block of its own
+	info := tp.Info(types.RequestInfo{})
+	if info.LastBlockHeight != 0 || len(info.LastBlockAppHash) != 0 {
+		t.Fatalf("Fresh State should report height 0 and no app hash, not %+v", info)
+	}
+
+	to := common.HexToAddress("b0b")
+	_, commit1 := tp.block(1, tp.tx(t, 0, &to, nil))
+	_, commit2 := tp.block(2, tp.tx(t, 1, &to, nil))
+
+	info = tp.Info(types.RequestInfo{})
+	if info.LastBlockHeight != 2 || !bytes.Equal(info.LastBlockAppHash, commit2.Data) {
+		t.Fatalf("Info should report height 2 and app hash %x, not %+v", commit2.Data, info)
+	}
+
+	// Blocks replayed after a restart are skipped, and answered with the app
+	// hash they produced
+	current := tp.state.CurrentBlock().Hash()
+	res, commit := tp.block(1, tp.tx(t, 0, &to, nil), []byte{1, 2, 3})
+	if !bytes.Equal(commit.Data, commit1.Data) {
+		t.Fatalf("Replayed block should return app hash %x, not %x", commit1.Data, commit.Data)
+	}
+	for i, r := range res {
+		if r.Code != types.CodeTypeOK {
+			t.Fatalf("Replayed transaction %d should be answered OK, not %d", i, r.Code)
+		}
+	}
+	if tp.state.CurrentBlock().Hash() != current || tp.state.GetNonce(tp.from) != 2 {
+		t.Fatal("Replayed block should not be applied")
+	}
+
+	// The next block is applied
+	_, commit3 := tp.block(3, tp.tx(t, 2, &to, nil))
+	if bytes.Equal(commit3.Data, commit2.Data) || tp.state.GetNonce(tp.from) != 3 {
+		t.Fatal("Block 3 should be applied")
+	}
+
+	// A gap means the State comes from another chain
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Block after a gap should panic")
+		}
+	}()
+	tp.BeginBlock(types.RequestBeginBlock{Header: types.Header{Height: 5, Time: time.Unix(1548000005, 0)}})
+}
+
+func TestDeliverTx(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	tag := func(res types.ResponseDeliverTx, key string) []string {
+		var values []string
+		for _, kv := range res.Tags {
+			if string(kv.Key) == key {
+				values = append(values, string(kv.Value))
+			}
+		}
+		return values
+	}
+
+	// logger logs 0x2a when it is called; invalid runs an invalid opcode
+	logger := crypto.CreateAddress(tp.from, 0)
+	invalid := crypto.CreateAddress(tp.from, 1)
+	res, _ := tp.block(1,
+		tp.tx(t, 0, nil, common.FromHex("6008600c60003960086000f3602a60006000a100")),
+		tp.tx(t, 1, nil, common.FromHex("600180600b6000396000f3fe")),
+		tp.tx(t, 2, &logger, nil),
+		tp.tx(t, 3, &invalid, nil),
+		tp.tx(t, 9, &logger, nil),
+		[]byte{1, 2, 3},
+	)
+
+	// Creations return the address of the contract
+	create := res[0]
+	if create.Code != types.CodeTypeOK || !bytes.Equal(create.Data, logger.Bytes()) {
+		t.Fatalf("Creation should return the contract address %x, not %+v", logger, create)
+	}
+	if create.GasWanted != 100000 || create.GasUsed == 0 || create.GasUsed > create.GasWanted {
+		t.Fatalf("Creation should report the gas it used, not %d of %d", create.GasUsed, create.GasWanted)
+	}
+	if c := tag(create, TagContract); len(c) != 1 || c[0] != strings.ToLower(logger.Hex()) {
+		t.Fatalf("Creation should be tagged with the contract, not %v", c)
+	}
+	if to := tag(create, TagTo); len(to) != 0 {
+		t.Fatalf("Creation should not be tagged with a recipient, not %v", to)
+	}
+
+	// Calls are tagged with their sender, recipient and logs
+	call := res[2]
+	if call.Code != types.CodeTypeOK {
+		t.Fatalf("Call should succeed, not %+v", call)
+	}
+	expected := map[string]string{
+		TagFrom:       strings.ToLower(tp.from.Hex()),
+		TagTo:         strings.ToLower(logger.Hex()),
+		TagLogAddress: strings.ToLower(logger.Hex()),
+		TagLogTopic:   common.BigToHash(big.NewInt(42)).Hex(),
+	}
+	for key, value := range expected {
+		if v := tag(call, key); len(v) != 1 || v[0] != value {
+			t.Fatalf("Call should be tagged %s=%s, not %v", key, value, v)
+		}
+	}
+	if h := tag(call, TagHash); len(h) != 1 || len(h[0]) != 66 {
+		t.Fatalf("Call should be tagged with its hash, not %v", h)
+	}
+
+	// Failed executions are applied, but reported
+	failed := res[3]
+	if failed.Code != state.CodeExecutionFailed || failed.Log == "" || failed.GasUsed != 100000 {
+		t.Fatalf("Failed execution should be reported with all its gas, not %+v", failed)
+	}
+	if len(tag(failed, TagHash)) != 1 {
+		t.Fatal("Failed execution should be tagged")
+	}
+
+	// Transactions that cannot be applied are answered with their TxError
+	if r := res[4]; r.Code != state.CodeNonceTooHigh || len(r.Tags) != 0 {
+		t.Fatalf("Transaction with a nonce too high should be rejected, not %+v", r)
+	}
+	if r := res[5]; r.Code != state.CodeMalformedTx {
+		t.Fatalf("Malformed transaction should be rejected, not %+v", r)
+	}
+
+	if n := len(tp.state.CurrentBlock().Transactions()); n != 4 {
+		t.Fatalf("Block should hold the 4 applied transactions, not %d", n)
+	}
+}
+
+// panics tells whether f panics
+func panics(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return false
+}
+
+func TestInitChain(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	genesisHash := tp.state.GetGenesisHash()
+	alloc := func(addr common.Address) []byte {
+		return []byte(fmt.Sprintf(`{"alloc": {"%x": {"balance": "1337000000000000000000"}}}`, addr))
+	}
+
+	// An app_state that agrees with the genesis block leaves it as it is
+	tp.InitChain(types.RequestInitChain{AppStateBytes: alloc(tp.from)})
+	if h := tp.state.GetGenesisHash(); h != genesisHash {
+		t.Fatalf("Genesis block should still be %x, not %x", genesisHash, h)
+	}
+
+	// The node refuses to start on an app_state that disagrees
+	other := common.HexToAddress("0xa11ce")
+	if !panics(func() { tp.InitChain(types.RequestInitChain{AppStateBytes: alloc(other)}) }) {
+		t.Fatal("InitChain should refuse app_state accounts that disagree with the genesis block")
+	}
+	chainID := []byte(`{"config": {"chainId": 7}}`)
+	if !panics(func() { tp.InitChain(types.RequestInitChain{AppStateBytes: chainID}) }) {
+		t.Fatal("InitChain should refuse an app_state chain ID that disagrees with the State")
+	}
+	if h := tp.state.GetGenesisHash(); h != genesisHash {
+		t.Fatalf("Genesis block should still be %x, not %x", genesisHash, h)
+	}
+
+	// The gas of blocks is capped to the block gas limit of the State
+	gasLimit := int64(tp.state.GetGasLimit())
+	params := func(maxGas int64) *types.ConsensusParams {
+		return &types.ConsensusParams{BlockSize: &types.BlockSizeParams{MaxBytes: 1024, MaxGas: maxGas}}
+	}
+	for _, maxGas := range []int64{-1, gasLimit + 1} {
+		res := tp.InitChain(types.RequestInitChain{ConsensusParams: params(maxGas)})
+		if res.ConsensusParams == nil || !res.ConsensusParams.BlockSize.Equal(params(gasLimit).BlockSize) {
+			t.Fatalf("Max gas %d should be capped to %d, not %v", maxGas, gasLimit, res.ConsensusParams)
+		}
+	}
+	for _, maxGas := range []int64{gasLimit, gasLimit - 1} {
+		if res := tp.InitChain(types.RequestInitChain{ConsensusParams: params(maxGas)}); res.ConsensusParams != nil {
+			t.Fatalf("Max gas %d should be kept, not changed to %v", maxGas, res.ConsensusParams)
+		}
+	}
+}
+
+// validatorUpdate returns the calldata with which the test staking contract
+// emits a ValidatorUpdate event
+func validatorUpdate(pubKey []byte, power *big.Int) []byte {
+	return append(common.LeftPadBytes(pubKey, 32), common.LeftPadBytes(power.Bytes(), 32)...)
+}
+
+func TestValidatorUpdates(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	// The staking contract emits its calldata in a ValidatorUpdate event
+	staking := crypto.CreateAddress(tp.from, 0)
+	tp.staking = &staking
+	code := fmt.Sprintf("602c600c600039602c6000f3366000600037"+"7f%x"+"366000a100", validatorUpdateTopic)
+
+	alice := bytes.Repeat([]byte{0xa1}, 32)
+	bob := bytes.Repeat([]byte{0xb0}, 32)
+	carol := bytes.Repeat([]byte{0xca}, 32)
+	tp.InitChain(types.RequestInitChain{
+		Validators: []types.ValidatorUpdate{types.Ed25519ValidatorUpdate(alice, 10)},
+	})
+
+	block := func(height int64, txs ...[]byte) []types.ValidatorUpdate {
+		tp.BeginBlock(types.RequestBeginBlock{Header: types.Header{Height: height}})
+		for _, tx := range txs {
+			if res := tp.DeliverTx(tx); res.Code != types.CodeTypeOK {
+				t.Fatalf("Transaction should succeed, not %+v", res)
+			}
+		}
+		res := tp.EndBlock(types.RequestEndBlock{Height: height})
+		tp.Commit()
+		return res.ValidatorUpdates
+	}
+	nonce := uint64(0)
+	call := func(data []byte) []byte {
+		nonce++
+		return tp.tx(t, nonce, &staking, data)
+	}
+
+	block(1, tp.tx(t, 0, nil, common.FromHex(code)))
+
+	updates := block(2,
+		call(validatorUpdate(bob, big.NewInt(5))),
+		// Alice can go, as Bob joins
+		call(validatorUpdate(alice, big.NewInt(0))),
+		// Carol is not a validator
+		call(validatorUpdate(carol, big.NewInt(0))),
+		// Only the last update of Bob is kept
+		call(validatorUpdate(bob, big.NewInt(7))),
+		// Malformed events are ignored
+		call(bob),
+		call(validatorUpdate(carol, big.NewInt(tmtypes.MaxTotalVotingPower+1))),
+	)
+	expected := []types.ValidatorUpdate{
+		types.Ed25519ValidatorUpdate(bob, 7),
+		types.Ed25519ValidatorUpdate(alice, 0),
+	}
+	if fmt.Sprint(updates) != fmt.Sprint(expected) {
+		t.Fatalf("Validator updates should be %v, not %v", expected, updates)
+	}
+
+	// Bob is the last validator, and stays
+	if updates := block(3, call(validatorUpdate(bob, big.NewInt(0)))); len(updates) != 0 {
+		t.Fatalf("Removal of the last validator should be ignored, not %v", updates)
+	}
+
+	// After a restart, the validator set is that of the votes of the last
+	// block
+	restarted := NewABCIProxy(tp.state, tp.metrics, &staking, bcommon.NewTestLogger(t))
+	tp.ABCIProxy = restarted
+	var bobKey ed25519.PubKeyEd25519
+	copy(bobKey[:], bob)
+	tp.BeginBlock(types.RequestBeginBlock{
+		Header: types.Header{Height: 4},
+		LastCommitInfo: types.LastCommitInfo{Votes: []types.VoteInfo{
+			{Validator: types.Validator{Address: bobKey.Address(), Power: 7}, SignedLastBlock: true},
+		}},
+	})
+	tp.DeliverTx(call(validatorUpdate(alice, big.NewInt(0))))
+	tp.DeliverTx(call(validatorUpdate(carol, big.NewInt(3))))
+	res := tp.EndBlock(types.RequestEndBlock{Height: 4})
+	expected = []types.ValidatorUpdate{types.Ed25519ValidatorUpdate(carol, 3)}
+	if fmt.Sprint(res.ValidatorUpdates) != fmt.Sprint(expected) {
+		t.Fatalf("Validator updates after a restart should be %v, not %v", expected, res.ValidatorUpdates)
+	}
+}
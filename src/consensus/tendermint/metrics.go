@@ -0,0 +1,128 @@
+package tendermint
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Formats of the block metrics file
+const (
+	MetricsCSV      = "csv"
+	MetricsJSONL    = "jsonl"
+	MetricsDisabled = "none"
+)
+
+// BlockMetrics are recorded for every block that the ABCIProxy commits with
+// transactions
+type BlockMetrics struct {
+	Height int64 `json:"height"`
+	// Timestamp is the time at which the block was committed, in milliseconds
+	// since the Unix epoch
+	Timestamp int64 `json:"timestamp"`
+	// TxCount is the number of transactions of the block, including the
+	// RejectedTx transactions that could not be applied
+	TxCount int    `json:"tx_count"`
+	GasUsed uint64 `json:"gas_used"`
+	// Latency is the time spent committing the block to the State, in
+	// milliseconds
+	Latency    float64 `json:"latency"`
+	RejectedTx int     `json:"rejected_tx"`
+}
+
+// MetricsSink records block metrics
+type MetricsSink interface {
+	Record(m BlockMetrics) error
+	Close() error
+}
+
+// NewMetricsSink returns a sink that appends block metrics to file, in the
+// given format. Metrics are not recorded if file is empty or format is
+// MetricsDisabled.
+func NewMetricsSink(format, file string) (MetricsSink, error) {
+	if file == "" || format == MetricsDisabled {
+		return nopSink{}, nil
+	}
+	if format != MetricsCSV && format != MetricsJSONL {
+		return nil, fmt.Errorf("Unknown metrics format %q", format)
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == MetricsJSONL {
+		return &jsonlSink{file: f, enc: json.NewEncoder(f)}, nil
+	}
+
+	sink := &csvSink{file: f, w: csv.NewWriter(f)}
+
+	// Files that already hold metrics keep their header
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := sink.write([]string{"height", "timestamp", "tx_count", "gas_used", "latency", "rejected_tx"}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+type nopSink struct{}
+
+func (nopSink) Record(BlockMetrics) error { return nil }
+func (nopSink) Close() error              { return nil }
+
+type csvSink struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func (s *csvSink) Record(m BlockMetrics) error {
+	return s.write([]string{
+		strconv.FormatInt(m.Height, 10),
+		strconv.FormatInt(m.Timestamp, 10),
+		strconv.Itoa(m.TxCount),
+		strconv.FormatUint(m.GasUsed, 10),
+		strconv.FormatFloat(m.Latency, 'f', 3, 64),
+		strconv.Itoa(m.RejectedTx),
+	})
+}
+
+func (s *csvSink) write(record []string) error {
+	if err := s.w.Write(record); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Close() error {
+	return s.file.Close()
+}
+
+type jsonlSink struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+func (s *jsonlSink) Record(m BlockMetrics) error {
+	return s.enc.Encode(m)
+}
+
+func (s *jsonlSink) Close() error {
+	return s.file.Close()
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.Round(time.Millisecond).UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
+}
@@ -0,0 +1,255 @@
+package tendermint
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bear987978897/evm-lite/src/service"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+// Codes of failed queries
+const (
+	CodeUnknownPath  uint32 = 1
+	CodeInvalidQuery uint32 = 2
+	CodeNotFound     uint32 = 3
+)
+
+// Types of the ProofOps of query proofs. Their data is the RLP list of the
+// trie nodes on the path from the root to the value. The keys of accounts and
+// storage slots are their address and slot; the state and storage tries are
+// keyed by their Keccak-256 hash.
+const (
+	ProofOpAccount = "eth:account"
+	ProofOpStorage = "eth:storage"
+	ProofOpReceipt = "eth:receipt"
+)
+
+// queryError is a failed query
+type queryError struct {
+	code uint32
+	err  error
+}
+
+func (e *queryError) Error() string {
+	return e.err.Error()
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return &queryError{CodeInvalidQuery, fmt.Errorf(format, args...)}
+}
+
+/*
+Query reads the EVM state. The value of a response is JSON, and its height is
+the one of the state that was read: the requested height, or the last committed
+one if the request has none.
+
+/account/<address>
+returns: JSON state.AccountProof
+
+/storage/<address>/<slot>
+returns: JSON state.AccountProof, with the slot in its storageProof
+
+Accounts and storage slots are read from the state committed at the requested
+height, and returned with their Merkle proofs in the state trie, whose root is
+the app hash of the next Tendermint block. With prove, the proofs are also
+returned as ProofOps, of type eth:account and eth:storage.
+
+/receipt/<tx hash>
+returns: JSON state.ReceiptProof
+
+Receipts are returned with their Merkle proof in the receipt trie of their
+block, and as an eth:receipt ProofOp with prove. The height is ignored.
+
+/call
+data: JSON SendTxArgs, as for POST /call
+returns: JSON JsonCallRes
+
+Calls are executed on the latest state, like POST /call. They come with no
+proof.
+*/
+func (p *ABCIProxy) Query(req types.RequestQuery) types.ResponseQuery {
+	p.logger.WithField("path", req.Path).Debug("Query")
+
+	height := req.Height
+	if height == 0 {
+		height = p.state.LastIndex()
+	}
+
+	res, err := p.query(req, height)
+	if err != nil {
+		code := CodeNotFound
+		if qErr, ok := err.(*queryError); ok {
+			code = qErr.code
+		}
+		return types.ResponseQuery{Code: code, Log: err.Error(), Height: height}
+	}
+	res.Height = height
+
+	return *res
+}
+
+func (p *ABCIProxy) query(req types.RequestQuery, height int64) (*types.ResponseQuery, error) {
+	args := strings.Split(strings.Trim(req.Path, "/"), "/")
+
+	switch {
+	case args[0] == "account" && len(args) == 2:
+		addr, err := parseAddress(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return p.queryAccount(req, height, addr, nil)
+
+	case args[0] == "storage" && len(args) == 3:
+		addr, err := parseAddress(args[1])
+		if err != nil {
+			return nil, err
+		}
+		slot, err := parseHash(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return p.queryAccount(req, height, addr, &slot)
+
+	case args[0] == "receipt" && len(args) == 2:
+		hash, err := parseHash(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return p.queryReceipt(req, hash)
+
+	case args[0] == "call" && len(args) == 1:
+		return p.queryCall(req)
+	}
+
+	return nil, &queryError{CodeUnknownPath, fmt.Errorf("Unknown query path %q", req.Path)}
+}
+
+func (p *ABCIProxy) queryAccount(req types.RequestQuery,
+	height int64,
+	addr common.Address,
+	slot *common.Hash) (*types.ResponseQuery, error) {
+
+	if last := p.state.LastIndex(); height > last {
+		return nil, invalidQuery("Height %d is not committed yet, last committed height is %d", height, last)
+	}
+
+	var keys []common.Hash
+	key := addr.Bytes()
+	if slot != nil {
+		keys = append(keys, *slot)
+		key = append(key, slot.Bytes()...)
+	}
+
+	account, err := p.state.GetProof(height, addr, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := jsonResponse(key, account)
+	if err != nil {
+		return nil, err
+	}
+	if req.Prove {
+		ops := []merkle.ProofOp{{Type: ProofOpAccount, Key: addr.Bytes()}}
+		if ops[0].Data, err = rlp.EncodeToBytes(account.AccountProof); err != nil {
+			return nil, err
+		}
+		for _, s := range account.StorageProof {
+			op := merkle.ProofOp{Type: ProofOpStorage, Key: s.Key.Bytes()}
+			if op.Data, err = rlp.EncodeToBytes(s.Proof); err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+		res.Proof = &merkle.Proof{Ops: ops}
+	}
+
+	return res, nil
+}
+
+func (p *ABCIProxy) queryReceipt(req types.RequestQuery, hash common.Hash) (*types.ResponseQuery, error) {
+	receipt, err := p.state.GetReceiptProof(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := jsonResponse(hash.Bytes(), receipt)
+	if err != nil {
+		return nil, err
+	}
+	if req.Prove {
+		op := merkle.ProofOp{Type: ProofOpReceipt}
+		if op.Key, err = rlp.EncodeToBytes(uint64(receipt.TransactionIndex)); err != nil {
+			return nil, err
+		}
+		if op.Data, err = rlp.EncodeToBytes(receipt.Proof); err != nil {
+			return nil, err
+		}
+		res.Proof = &merkle.Proof{Ops: []merkle.ProofOp{op}}
+	}
+
+	return res, nil
+}
+
+func (p *ABCIProxy) queryCall(req types.RequestQuery) (*types.ResponseQuery, error) {
+	var args service.SendTxArgs
+	if err := json.Unmarshal(req.Data, &args); err != nil {
+		return nil, invalidQuery("Decoding call: %v", err)
+	}
+	msg, err := service.NewCallMessage(args)
+	if err != nil {
+		return nil, invalidQuery("Converting to call message: %v", err)
+	}
+
+	res := service.JsonCallRes{}
+	data, err := p.state.Call(*msg)
+	if execErr, ok := err.(*state.ExecutionError); ok {
+		res.Error = execErr.Err
+		res.Reason = execErr.Reason
+	} else if err != nil {
+		return nil, invalidQuery("Executing call: %v", err)
+	}
+	res.Data = common.ToHex(data)
+
+	return jsonResponse(req.Data, res)
+}
+
+func jsonResponse(key []byte, value interface{}) (*types.ResponseQuery, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseQuery{
+		Code:  types.CodeTypeOK,
+		Key:   key,
+		Value: js,
+	}, nil
+}
+
+func parseAddress(s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, invalidQuery("Invalid address %q", s)
+	}
+	return common.HexToAddress(s), nil
+}
+
+// parseHash parses a hex hash or storage slot, which may be shorter than 32
+// bytes
+func parseHash(s string) (common.Hash, error) {
+	h := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(h)%2 == 1 {
+		h = "0" + h
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) > common.HashLength {
+		return common.Hash{}, invalidQuery("Invalid hash %q", s)
+	}
+	return common.BytesToHash(b), nil
+}
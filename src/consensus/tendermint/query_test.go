@@ -0,0 +1,126 @@
+package tendermint
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/bear987978897/evm-lite/src/service"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/tendermint/tendermint/abci/types"
+)
+
+// query runs a query that must succeed and decodes its value into value
+func (tp *testProxy) query(t *testing.T, req types.RequestQuery, value interface{}) types.ResponseQuery {
+	res := tp.Query(req)
+	if res.Code != types.CodeTypeOK {
+		t.Fatalf("Query %s failed with code %d: %s", req.Path, res.Code, res.Log)
+	}
+	if err := json.Unmarshal(res.Value, value); err != nil {
+		t.Fatalf("Decoding value of %s: %v", req.Path, err)
+	}
+	return res
+}
+
+func TestQuery(t *testing.T) {
+	tp := newTestProxy(t)
+	defer tp.close()
+
+	// store writes 0x2a to slot 0 when it is created, and has code so that it
+	// is not empty; invalid runs an invalid opcode
+	store := crypto.CreateAddress(tp.from, 0)
+	invalid := crypto.CreateAddress(tp.from, 1)
+	tp.block(1,
+		tp.tx(t, 0, nil, common.FromHex("602a60005560018060106000396000f300")),
+		tp.tx(t, 1, nil, common.FromHex("600180600b6000396000f3fe")),
+	)
+	to := common.HexToAddress("b0b")
+	transfer := tp.tx(t, 2, &to, nil)
+	tp.block(2, transfer)
+
+	// Accounts are read at the requested height, or the last one
+	var account state.AccountProof
+	res := tp.query(t, types.RequestQuery{Path: fmt.Sprintf("/account/%s", to.Hex())}, &account)
+	if res.Height != 2 || account.Balance.ToInt().Int64() != 1 {
+		t.Fatalf("Account should have balance 1 at height 2, not %v at %d", account.Balance, res.Height)
+	}
+	res = tp.query(t, types.RequestQuery{Path: fmt.Sprintf("/account/%s", to.Hex()), Height: 1, Prove: true}, &account)
+	if res.Height != 1 || account.Balance.ToInt().Int64() != 0 {
+		t.Fatalf("Account should have balance 0 at height 1, not %v at %d", account.Balance, res.Height)
+	}
+
+	// Proofs start from the state root of the height
+	root, err := tp.state.GetIndexRoot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(account.AccountProof) == 0 || crypto.Keccak256Hash(account.AccountProof[0]) != root {
+		t.Fatalf("Account proof should start with the state root %s", root.Hex())
+	}
+	if res.Proof == nil || len(res.Proof.Ops) != 1 || res.Proof.Ops[0].Type != ProofOpAccount {
+		t.Fatalf("Proved query should return an account ProofOp, not %v", res.Proof)
+	}
+	var nodes []hexutil.Bytes
+	if err := rlp.DecodeBytes(res.Proof.Ops[0].Data, &nodes); err != nil || len(nodes) != len(account.AccountProof) {
+		t.Fatalf("ProofOp should hold the nodes of the account proof: %v", err)
+	}
+
+	var storage state.AccountProof
+	res = tp.query(t, types.RequestQuery{Path: fmt.Sprintf("/storage/%s/0x0", store.Hex()), Prove: true}, &storage)
+	if len(storage.StorageProof) != 1 || storage.StorageProof[0].Value.ToInt().Int64() != 42 {
+		t.Fatalf("Slot 0 should hold 42, not %+v", storage.StorageProof)
+	}
+	if len(res.Proof.Ops) != 2 || res.Proof.Ops[1].Type != ProofOpStorage {
+		t.Fatalf("Proved query should return a storage ProofOp, not %v", res.Proof)
+	}
+
+	// Receipts are returned with their proof in the receipt trie
+	tx, err := state.DecodeTx(transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipt state.ReceiptProof
+	res = tp.query(t, types.RequestQuery{Path: "/receipt/" + tx.Hash().Hex(), Prove: true}, &receipt)
+	if receipt.BlockNumber != 2 || receipt.Receipt == nil || receipt.Receipt.TxHash != tx.Hash() {
+		t.Fatalf("Receipt of the transfer should be in block 2, not %+v", receipt)
+	}
+	if len(receipt.Proof) == 0 || crypto.Keccak256Hash(receipt.Proof[0]) != receipt.ReceiptsRoot {
+		t.Fatal("Receipt proof should start with the receipts root")
+	}
+	if len(res.Proof.Ops) != 1 || res.Proof.Ops[0].Type != ProofOpReceipt {
+		t.Fatalf("Proved query should return a receipt ProofOp, not %v", res.Proof)
+	}
+
+	// Calls report execution errors in their value
+	args, err := json.Marshal(service.SendTxArgs{From: tp.from, To: &invalid, Gas: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var call service.JsonCallRes
+	tp.query(t, types.RequestQuery{Path: "/call", Data: args}, &call)
+	if call.Error == "" {
+		t.Fatalf("Call of invalid should fail, not %+v", call)
+	}
+
+	failures := []struct {
+		req  types.RequestQuery
+		code uint32
+	}{
+		{types.RequestQuery{Path: "/balance/" + to.Hex()}, CodeUnknownPath},
+		{types.RequestQuery{Path: "/account"}, CodeUnknownPath},
+		{types.RequestQuery{Path: "/account/0xb0b"}, CodeInvalidQuery},
+		{types.RequestQuery{Path: "/storage/" + store.Hex() + "/xyz"}, CodeInvalidQuery},
+		{types.RequestQuery{Path: "/account/" + to.Hex(), Height: 3}, CodeInvalidQuery},
+		{types.RequestQuery{Path: "/receipt/0x1234"}, CodeNotFound},
+		{types.RequestQuery{Path: "/call", Data: []byte("{")}, CodeInvalidQuery},
+	}
+	for _, e := range failures {
+		if res := tp.Query(e.req); res.Code != e.code || res.Log == "" {
+			t.Fatalf("Query %s should fail with code %d, not %d", e.req.Path, e.code, res.Code)
+		}
+	}
+}
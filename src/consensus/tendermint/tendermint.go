@@ -7,6 +7,7 @@ import (
 	"github.com/bear987978897/evm-lite/src/config"
 	"github.com/bear987978897/evm-lite/src/service"
 	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
 	"github.com/tendermint/tendermint/libs/log"
 	node "github.com/tendermint/tendermint/node"
@@ -39,7 +40,19 @@ func (t *Tendermint) Init(state *state.State, service *service.Service) error {
 	t.logger.Debug("INIT")
 
 	realConfig := t.config.ToRealTmConfig()
-	abciApp := NewABCIProxy(state, t.logger)
+	metrics, err := NewMetricsSink(t.config.MetricsFormat, t.config.MetricsFile)
+	if err != nil {
+		return fmt.Errorf("Block metrics: %v", err)
+	}
+	var staking *common.Address
+	if t.config.StakingContract != "" {
+		if !common.IsHexAddress(t.config.StakingContract) {
+			return fmt.Errorf("Invalid staking contract address %q", t.config.StakingContract)
+		}
+		addr := common.HexToAddress(t.config.StakingContract)
+		staking = &addr
+	}
+	abciApp := NewABCIProxy(state, metrics, staking, t.logger)
 	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
 	node, err := DefaultNewNodeWithApp(realConfig, abciApp, logger)
 	if err != nil {
@@ -65,15 +78,14 @@ func (t *Tendermint) Run() error {
 	for {
 		select {
 		case tx := <-submitCh:
-			_, err := t.rpcclient.BroadcastTxSync(tx)
-
+			res, err := t.rpcclient.BroadcastTxSync(tx)
 			if err != nil {
-				fmt.Errorf("Failed to broacast transaction: %v", err)
+				t.logger.WithError(err).Error("Failed to broadcast transaction")
+			} else if res.Code != 0 {
+				t.logger.WithField("code", res.Code).Error("Transaction rejected by mempool: ", res.Log)
 			}
 		}
 	}
-
-	return nil
 }
 
 func (t *Tendermint) Info() (map[string]string, error) {
@@ -0,0 +1,125 @@
+package tendermint
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// ValidatorUpdateEvent is the signature of the event with which the staking
+// contract updates the validator set:
+//
+//   event ValidatorUpdate(bytes32 pubKey, uint64 power);
+//
+// pubKey is the Ed25519 public key of a validator, and a power of 0 removes it
+// from the set. Tendermint halts on invalid updates, so removals of validators
+// that are not in the set, and removals that would leave it empty, are
+// ignored.
+const ValidatorUpdateEvent = "ValidatorUpdate(bytes32,uint64)"
+
+var validatorUpdateTopic = crypto.Keccak256Hash([]byte(ValidatorUpdateEvent))
+
+// validatorSet is a set of validators, by address, with their voting power
+type validatorSet map[string]int64
+
+// newValidatorSet returns the set of the genesis validators of Tendermint
+func newValidatorSet(validators []types.ValidatorUpdate) (validatorSet, error) {
+	set := make(validatorSet)
+	if err := set.apply(validators); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// apply applies validator updates to the set
+func (s validatorSet) apply(updates []types.ValidatorUpdate) error {
+	for _, u := range updates {
+		pubKey, err := tmtypes.PB2TM.PubKey(u.PubKey)
+		if err != nil {
+			return err
+		}
+		if u.Power == 0 {
+			delete(s, string(pubKey.Address()))
+		} else {
+			s[string(pubKey.Address())] = u.Power
+		}
+	}
+	return nil
+}
+
+// validatorUpdates collects the validator updates of a block, in the order in
+// which their validators were first updated. Only the last update of every
+// validator is kept, because Tendermint rejects duplicates. Updates are keyed
+// by the address of their validator.
+type validatorUpdates struct {
+	order   []string
+	updates map[string]types.ValidatorUpdate
+}
+
+func newValidatorUpdates() *validatorUpdates {
+	return &validatorUpdates{updates: make(map[string]types.ValidatorUpdate)}
+}
+
+// add records the validator update of a log, if it is a ValidatorUpdate event
+// emitted by contract. It returns false for malformed events, which are
+// ignored.
+func (v *validatorUpdates) add(contract common.Address, log *ethTypes.Log) bool {
+	if log.Address != contract || len(log.Topics) != 1 || log.Topics[0] != validatorUpdateTopic {
+		return true
+	}
+	if len(log.Data) != 64 {
+		return false
+	}
+
+	pubKey := common.CopyBytes(log.Data[:32])
+	power := new(big.Int).SetBytes(log.Data[32:])
+	if !power.IsInt64() || power.Int64() > tmtypes.MaxTotalVotingPower {
+		return false
+	}
+
+	var ed25519Key ed25519.PubKeyEd25519
+	copy(ed25519Key[:], pubKey)
+	key := string(ed25519Key.Address())
+	if _, ok := v.updates[key]; !ok {
+		v.order = append(v.order, key)
+	}
+	v.updates[key] = types.Ed25519ValidatorUpdate(pubKey, power.Int64())
+
+	return true
+}
+
+// list returns the updates of the block to the validator set set, and the
+// number of removals that it ignored: those of validators that are not in set,
+// and those that would leave it empty. A nil set is unknown, and no removal is
+// ignored.
+func (v *validatorUpdates) list(set validatorSet) ([]types.ValidatorUpdate, int) {
+	// Validators added by the block count towards the ones that are left
+	remaining := len(set)
+	for _, key := range v.order {
+		if _, ok := set[key]; !ok && v.updates[key].Power > 0 {
+			remaining++
+		}
+	}
+
+	var (
+		list    []types.ValidatorUpdate
+		ignored int
+	)
+	for _, key := range v.order {
+		update := v.updates[key]
+		if update.Power == 0 && set != nil {
+			if _, ok := set[key]; !ok || remaining == 1 {
+				ignored++
+				continue
+			}
+			remaining--
+		}
+		list = append(list, update)
+	}
+	return list, ignored
+}
@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"math/big"
+
 	"github.com/bear987978897/evm-lite/src/config"
 	"github.com/bear987978897/evm-lite/src/consensus"
 	"github.com/bear987978897/evm-lite/src/service"
@@ -21,10 +23,23 @@ func NewEngine(config config.Config,
 	logger *logrus.Logger) (*Engine, error) {
 	submitCh := make(chan []byte)
 
+	var chainID *big.Int
+	if config.Eth.ChainID != 0 {
+		chainID = new(big.Int).SetUint64(config.Eth.ChainID)
+	}
+
 	state, err := state.NewState(logger,
 		config.Eth.DbFile,
 		config.Eth.Cache,
-		config.Eth.Genesis)
+		config.Eth.Genesis,
+		chainID,
+		state.TxPoolConfig{
+			PriceBump:    config.Eth.PriceBump,
+			AccountSlots: config.Eth.AccountSlots,
+			GlobalSlots:  config.Eth.GlobalSlots,
+			AccountQueue: config.Eth.AccountQueue,
+			GlobalQueue:  config.Eth.GlobalQueue,
+		})
 	if err != nil {
 		return nil, err
 	}
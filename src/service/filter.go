@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// FilterCriteria selects logs by block range, emitting address and topics.
+// Topics are positional: an empty position matches any topic, and a position
+// with several topics matches any of them. BlockHash restricts the query to a
+// single block and excludes FromBlock and ToBlock. Block ranges are ignored by
+// subscriptions.
+type FilterCriteria struct {
+	BlockHash *common.Hash
+	FromBlock *rpcBlockNumber
+	ToBlock   *rpcBlockNumber
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
+// UnmarshalJSON accepts the Ethereum JSON-RPC filter object, where address is
+// either an address or an array of addresses and each topic is either null, a
+// topic, or an array of topics.
+func (fc *FilterCriteria) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		BlockHash *common.Hash      `json:"blockHash"`
+		FromBlock *rpcBlockNumber   `json:"fromBlock"`
+		ToBlock   *rpcBlockNumber   `json:"toBlock"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if raw.BlockHash != nil && (raw.FromBlock != nil || raw.ToBlock != nil) {
+		return fmt.Errorf("blockHash cannot be combined with fromBlock/toBlock")
+	}
+	fc.BlockHash = raw.BlockHash
+	fc.FromBlock = raw.FromBlock
+	fc.ToBlock = raw.ToBlock
+
+	addresses, err := decodeAddresses(raw.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+	fc.Addresses = addresses
+
+	fc.Topics = make([][]common.Hash, len(raw.Topics))
+	for i, t := range raw.Topics {
+		topics, err := decodeTopics(t)
+		if err != nil {
+			return fmt.Errorf("invalid topic %d: %v", i, err)
+		}
+		fc.Topics[i] = topics
+	}
+
+	return nil
+}
+
+func decodeAddresses(data json.RawMessage) ([]common.Address, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var addresses []common.Address
+		err := json.Unmarshal(data, &addresses)
+		return addresses, err
+	}
+	var address common.Address
+	if err := json.Unmarshal(data, &address); err != nil {
+		return nil, err
+	}
+	return []common.Address{address}, nil
+}
+
+func decodeTopics(data json.RawMessage) ([]common.Hash, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var topics []common.Hash
+		err := json.Unmarshal(data, &topics)
+		return topics, err
+	}
+	var topic common.Hash
+	if err := json.Unmarshal(data, &topic); err != nil {
+		return nil, err
+	}
+	return []common.Hash{topic}, nil
+}
+
+// getLogs runs a log query against the State. Omitted block numbers default
+// to the latest block.
+func (m *Service) getLogs(fc FilterCriteria) ([]*ethTypes.Log, error) {
+	if fc.BlockHash != nil {
+		return m.state.GetBlockLogs(*fc.BlockHash, fc.Addresses, fc.Topics)
+	}
+
+	from, to := latestBlockNumber, latestBlockNumber
+	if fc.FromBlock != nil {
+		from = *fc.FromBlock
+	}
+	if fc.ToBlock != nil {
+		to = *fc.ToBlock
+	}
+
+	return m.state.GetLogs(m.blockNumber(from), m.blockNumber(to), fc.Addresses, fc.Topics)
+}
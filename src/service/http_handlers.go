@@ -1,13 +1,13 @@
 package service
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"math/big"
 	"net/http"
+	"strconv"
 
 	"github.com/bear987978897/evm-lite/src/service/templates"
 	"github.com/bear987978897/evm-lite/src/state"
@@ -16,7 +16,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -99,6 +98,10 @@ This endpoints allows calling SmartContract code for READONLY operations. These
 calls will NOT modify the EVM state.
 
 The data does NOT need to be signed.
+
+If the execution fails, the response contains the EVM error and, if the contract
+reverted with one, the decoded revert reason. The data then holds the raw data
+returned by REVERT.
 */
 func callHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	m.logger.WithField("request", r).Debug("POST call")
@@ -120,14 +123,72 @@ func callHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 		return
 	}
 
+	res := JsonCallRes{}
+
 	data, err := m.state.Call(*callMessage)
-	if err != nil {
+	if execErr, ok := err.(*state.ExecutionError); ok {
+		res.Error = execErr.Err
+		res.Reason = execErr.Reason
+	} else if err != nil {
 		m.logger.WithError(err).Error("Executing Call")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	res.Data = common.ToHex(data)
+
+	js, err := json.Marshal(res)
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/*
+POST /estimateGas
+data: JSON SendTxArgs
+returns: JSON JsonEstimateGasRes
+
+This endpoint returns the lowest gas limit with which a transaction would
+execute successfully in the current state. It is found by binary search, by
+executing the transaction with different gas limits like the /call endpoint.
+If the gas field is set, it is used as the upper bound of the search.
+
+The data does NOT need to be signed. If the transaction fails even with the
+highest gas limit, an error is returned, containing the revert reason if the
+contract provided one.
+*/
+func estimateGasHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	m.logger.WithField("request", r).Debug("POST estimateGas")
+
+	decoder := json.NewDecoder(r.Body)
+	var txArgs SendTxArgs
+	err := decoder.Decode(&txArgs)
+	if err != nil {
+		m.logger.WithError(err).Error("Decoding JSON txArgs")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
 
-	res := JsonCallRes{Data: common.ToHex(data)}
+	callMessage, err := prepareEstimateMessage(txArgs, m.keyStore)
+	if err != nil {
+		m.logger.WithError(err).Error("Converting to CallMessage")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	gas, err := m.state.EstimateGas(*callMessage)
+	if err != nil {
+		m.logger.WithError(err).Error("Estimating Gas")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := JsonEstimateGasRes{Gas: gas}
 	js, err := json.Marshal(res)
 	if err != nil {
 		m.logger.WithError(err).Error("Marshaling JSON response")
@@ -152,11 +213,13 @@ for transactions whose originator is an account CONTROLLED by the evm-lite
 Service (ie. present in the Keystore).
 
 The Nonce field is not necessary either since the Service will fetch it from the
-State.
+State. If the Gas field is not set, the gas limit is estimated like with the
+/estimateGas endpoint.
 
 This is an ASYNCHRONOUS operation. It will return the hash of the transaction that
 was SUBMITTED to evm-lite but there is no guarantee that the transactions will
-get applied to the State.
+get applied to the State. With Raft consensus, it waits until the transaction
+reaches the leader, and fails with a 503 if it cannot.
 
 One should use the /receipt endpoint to retrieve the corresponding receipt and
 verify if/how the State was modified.
@@ -191,23 +254,12 @@ func transactionHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 		"value":    tx.Value(),
 	}).Debug("Service decoded tx")
 
-	// if err := m.state.CheckTx(tx); err != nil {
-	// 	m.logger.WithError(err).Error("Checking Transaction")
-	// 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	// 	return
-	// }
-
-	data, err := rlp.EncodeToBytes(tx)
-	if err != nil {
-		m.logger.WithError(err).Error("Encoding Transaction")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := m.submitTx(tx); err != nil {
+		m.logger.WithError(err).Error("Checking Transaction")
+		txError(w, err)
 		return
 	}
 
-	m.logger.Debug("submitting tx")
-	m.submitCh <- data
-	m.logger.Debug("submitted tx")
-
 	res := JsonTxRes{TxHash: tx.Hash().Hex()}
 	js, err := json.Marshal(res)
 	if err != nil {
@@ -235,7 +287,8 @@ This allows executing transactions on behalf of accounts that are NOT CONTROLLED
 by the evm-lite service.
 
 Like the /tx endpoint, this is an ASYNCHRONOUS operation and the effect on the
-State should be verified by fetching the transaction' receipt.
+State should be verified by fetching the transaction' receipt. It also waits
+for the transaction to reach the Raft leader.
 */
 func rawTransactionHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	m.logger.WithField("request", r).Debug("POST rawtx")
@@ -259,33 +312,28 @@ func rawTransactionHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	}
 	m.logger.WithField("raw tx bytes", rawTxBytes).Debug()
 
-	var t ethTypes.Transaction
-	if err := rlp.Decode(bytes.NewReader(rawTxBytes), &t); err != nil {
+	t, err := state.DecodeTx(rawTxBytes)
+	if err != nil {
 		m.logger.WithError(err).Error("Decoding Transaction")
+		txError(w, err)
 		return
 	}
-	// m.logger.WithField("ethTypes.Transaction", &t).Debug()
 
-	// m.logger.WithFields(logrus.Fields{
-	// 	"hash":     t.Hash().Hex(),
-	// 	"to":       t.To(),
-	// 	"payload":  fmt.Sprintf("%x", t.Data()),
-	// 	"gas":      t.Gas(),
-	// 	"gasPrice": t.GasPrice(),
-	// 	"nonce":    t.Nonce(),
-	// 	"value":    t.Value(),
-	// }).Debug("Service decoded tx")
-
-
-	// if err := m.state.CheckTx(&t); err != nil {
-	// 	m.logger.WithError(err).Error("Checking Transaction")
-	// 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	// 	return
-	// }
+	m.logger.WithFields(logrus.Fields{
+		"hash":     t.Hash().Hex(),
+		"to":       t.To(),
+		"payload":  fmt.Sprintf("%x", t.Data()),
+		"gas":      t.Gas(),
+		"gasPrice": t.GasPrice(),
+		"nonce":    t.Nonce(),
+		"value":    t.Value(),
+	}).Debug("Service decoded tx")
 
-	m.logger.Debug("submitting tx")
-	m.submitCh <- rawTxBytes
-	m.logger.Debug("submitted tx")
+	if err := m.submitTx(t); err != nil {
+		m.logger.WithError(err).Error("Checking Transaction")
+		txError(w, err)
+		return
+	}
 
 	res := JsonTxRes{TxHash: t.Hash().Hex()}
 	js, err := json.Marshal(res)
@@ -308,7 +356,8 @@ This endpoint allows to retrieve the EVM receipt of a specific transactions if i
 exists. When a transaction is applied to the EVM , a receipt is saved to allow
 checking if/how the transaction affected the state. This is where one can see such
 information as the address of a newly created contract, how much gas was use and
-the EVM Logs produced by the execution of the transaction.
+the EVM Logs produced by the execution of the transaction. Receipts of failed
+transactions also contain the EVM error and the decoded revert reason, if any.
 */
 func transactionReceiptHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	param := r.URL.Path[len("/tx/"):]
@@ -329,14 +378,20 @@ func transactionReceiptHandler(w http.ResponseWriter, r *http.Request, m *Servic
 		return
 	}
 
-	signer := ethTypes.NewEIP155Signer(big.NewInt(1))
-	from, err := ethTypes.Sender(signer, tx)
+	from, err := ethTypes.Sender(m.state.GetSigner(), tx)
 	if err != nil {
 		m.logger.WithError(err).Error("Getting Tx Sender")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	execErr, err := m.state.GetExecutionError(txHash)
+	if err != nil {
+		m.logger.WithError(err).Error("Getting Execution Error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	jsonReceipt := JsonReceipt{
 		Root:              common.BytesToHash(receipt.PostState),
 		TransactionHash:   txHash,
@@ -347,7 +402,12 @@ func transactionReceiptHandler(w http.ResponseWriter, r *http.Request, m *Servic
 		ContractAddress:   receipt.ContractAddress,
 		Logs:              receipt.Logs,
 		LogsBloom:         receipt.Bloom,
-		Status:            receipt.Status,
+		Status:            receiptStatus(receipt, execErr),
+	}
+
+	if execErr != nil {
+		jsonReceipt.Error = execErr.Err
+		jsonReceipt.RevertReason = execErr.Reason
 	}
 
 	if receipt.Logs == nil {
@@ -365,17 +425,139 @@ func transactionReceiptHandler(w http.ResponseWriter, r *http.Request, m *Servic
 	w.Write(js)
 }
 
+/*
+GET /txpool/content
+returns: JSON JsonTxPoolContent
+
+This endpoint lists the transactions that were submitted to this node but are
+not part of a block yet, by sender and nonce. Pending transactions have been
+forwarded to the consensus system. Queued transactions wait for transactions
+with lower nonces.
+*/
+func poolContentHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	m.logger.WithField("request", r).Debug("GET txpool/content")
+
+	js, err := json.Marshal(m.poolContent())
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/*
+GET /txpool/status
+returns: JSON JsonTxPoolStatus
+
+This endpoint returns the number of pending and queued transactions.
+*/
+func poolStatusHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	m.logger.WithField("request", r).Debug("GET txpool/status")
+
+	pending, queued := m.state.GetPoolStats()
+
+	js, err := json.Marshal(JsonTxPoolStatus{Pending: pending, Queued: queued})
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/*
+GET /txpool/tx/{tx_hash}
+ex: /txpool/tx/0xbfe1aa80eb704d6342c553ac9f423024f448f7c74b3e38559429d4b7c98ffb99
+returns: JSON JsonTxStatus
+
+This endpoint tells what happened to a transaction submitted to /tx or /rawtx:
+"pending" (forwarded to the consensus system), "queued" (waiting for lower
+nonces), "included" (in a block, the receipt is available), "dropped" (removed
+from the TxPool without being included, with a reason) or "unknown".
+*/
+func txStatusHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	param := r.URL.Path[len("/txpool/tx/"):]
+	txHash := common.HexToHash(param)
+	m.logger.WithField("tx_hash", txHash.Hex()).Debug("GET txpool/tx")
+
+	status, err := m.txStatus(txHash)
+	if err != nil {
+		m.logger.WithError(err).Error("Getting Transaction status")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(status)
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/*
+POST /logs
+data: JSON FilterCriteria
+ex: {"fromBlock":"0x0","toBlock":"latest","address":"0xe32e14de8b81d8d3aedacb1868619c74a68feab0","topics":[null,"0x000000000000000000000000629007eb99ff5c3539ada8a5800847eacfc25727"]}
+returns: JSON JsonLogList
+
+This endpoint returns the EVM Logs of a range of blocks, or of a single block
+given its hash, filtered by contract address and topics. The filter has the same
+format as the one of eth_getLogs. Queries use an index of the blocks' blooms so
+that logs can be retrieved without knowing the hashes of the transactions that
+emitted them.
+*/
+func logsHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	m.logger.WithField("request", r).Debug("POST logs")
+
+	decoder := json.NewDecoder(r.Body)
+	var crit FilterCriteria
+	err := decoder.Decode(&crit)
+	if err != nil {
+		m.logger.WithError(err).Error("Decoding JSON filter")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	logs, err := m.getLogs(crit)
+	if err != nil {
+		m.logger.WithError(err).Error("Getting Logs")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(JsonLogList{Logs: logs})
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
 /*
 GET /info
 returns: JSON (depends on underlying consensus system)
 
 Info returns information about the consensus system. Each consensus system that
-plugs into evm-lite must implement an Info function.
+plugs into evm-lite must implement an Info function. The chain ID of the
+network is added as chain_id.
 */
 func infoHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	m.logger.Debug("GET info")
 
-	stats, err := m.getInfo()
+	stats, err := m.info()
 	if err != nil {
 		m.logger.WithError(err).Error("Getting Info")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -403,7 +585,7 @@ plugs into evm-lite must implement an Info function.
 func htmlInfoHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	m.logger.Debug("GET html/info")
 
-	stats, err := m.getInfo()
+	stats, err := m.info()
 	if err != nil {
 		m.logger.WithError(err).Error("Getting Info")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -420,7 +602,80 @@ func htmlInfoHandler(w http.ResponseWriter, r *http.Request, m *Service) {
 	t.Execute(w, stats)
 }
 
+// info returns the information of the consensus system, with the chain ID, the
+// genesis hash, and the number and gas of the last block
+func (m *Service) info() (map[string]string, error) {
+	stats, err := m.getInfo()
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		stats = make(map[string]string)
+	}
+	stats["chain_id"] = m.state.GetChainID().String()
+	stats["genesis_hash"] = m.state.GetGenesisHash().Hex()
+	block := m.state.CurrentBlock()
+	stats["block_number"] = strconv.FormatUint(block.NumberU64(), 10)
+	stats["block_gas_limit"] = strconv.FormatUint(block.GasLimit(), 10)
+	stats["block_gas_used"] = strconv.FormatUint(block.GasUsed(), 10)
+
+	return stats, nil
+}
+
 //------------------------------------------------------------------------------
+
+// txError replies to a rejected transaction. Admission errors are returned as
+// a JSON TxError, with a status that tells clients whether the transaction is
+// invalid (400), conflicts with the sender's nonce or a known transaction
+// (409), cannot be paid for (422) or does not fit in the TxPool (503).
+// Transactions that the consensus system did not accept are also reported with
+// a 503.
+func txError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*submitError); ok {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	txErr, ok := err.(*state.TxError)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch txErr.Code {
+	case state.CodeNonceTooLow, state.CodeNonceTooHigh, state.CodeReplaceUnderpriced, state.CodeKnownTx:
+		status = http.StatusConflict
+	case state.CodeInsufficientFunds, state.CodeGasLimit:
+		status = http.StatusUnprocessableEntity
+	case state.CodeTxPoolFull:
+		status = http.StatusServiceUnavailable
+	}
+
+	js, err := json.Marshal(txErr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+//receiptStatus returns the status of a receipt. Receipts that carry an
+//intermediate state root do not persist their status, which is then derived
+//from the execution error of the transaction.
+func receiptStatus(receipt *ethTypes.Receipt, execErr *state.ExecutionError) uint64 {
+	if len(receipt.PostState) == 0 {
+		return receipt.Status
+	}
+	if execErr != nil {
+		return ethTypes.ReceiptStatusFailed
+	}
+	return ethTypes.ReceiptStatusSuccessful
+}
+
 func prepareCallMessage(args SendTxArgs, ks *keystore.KeyStore) (*ethTypes.Message, error) {
 	var err error
 	args, err = prepareSendTxArgs(args)
@@ -446,8 +701,49 @@ func prepareCallMessage(args SendTxArgs, ks *keystore.KeyStore) (*ethTypes.Messa
 
 }
 
+//NewCallMessage converts the arguments of a call, as accepted by POST /call, to
+//a message for State.Call. Unset fields take the same defaults as for /call.
+func NewCallMessage(args SendTxArgs) (*ethTypes.Message, error) {
+	return prepareCallMessage(args, nil)
+}
+
+//prepareEstimateMessage is like prepareCallMessage, but leaves the gas limit
+//unset if it is not specified so that the estimation can search up to its cap.
+func prepareEstimateMessage(args SendTxArgs, ks *keystore.KeyStore) (*ethTypes.Message, error) {
+	gas := args.Gas
+
+	callMsg, err := prepareCallMessage(args, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := ethTypes.NewMessage(
+		callMsg.From(),
+		callMsg.To(),
+		0,
+		callMsg.Value(),
+		gas,
+		callMsg.GasPrice(),
+		callMsg.Data(),
+		false,
+	)
+
+	return &msg, nil
+}
+
 func prepareTransaction(args SendTxArgs, state *state.State, ks *keystore.KeyStore) (*ethTypes.Transaction, error) {
 	var err error
+
+	if args.Gas == 0 {
+		msg, err := prepareEstimateMessage(args, ks)
+		if err != nil {
+			return nil, err
+		}
+		if args.Gas, err = state.EstimateGas(*msg); err != nil {
+			return nil, err
+		}
+	}
+
 	args, err = prepareSendTxArgs(args)
 	if err != nil {
 		return nil, err
@@ -474,7 +770,7 @@ func prepareTransaction(args SendTxArgs, state *state.State, ks *keystore.KeySto
 			common.FromHex(args.Data))
 	}
 
-	signer := ethTypes.NewEIP155Signer(big.NewInt(1))
+	signer := state.GetSigner()
 
 	account, err := ks.Find(accounts.Account{Address: args.From})
 	if err != nil {
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Standard JSON-RPC 2.0 error codes, and the generic server error used by
+// Ethereum clients for failed calls.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+	// Returned by Ethereum clients when execution is reverted, with the revert
+	// data in the error's data field
+	rpcRevertError = 3
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func newRPCError(code int, format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// rpcMethod implements a JSON-RPC method. Errors that are not an *rpcError are
+// reported as server errors.
+type rpcMethod func(m *Service, params json.RawMessage) (interface{}, error)
+
+var rpcMethods = map[string]rpcMethod{
+	"web3_clientVersion":        web3ClientVersion,
+	"web3_sha3":                 web3Sha3,
+	"net_version":               netVersion,
+	"net_listening":             netListening,
+	"eth_chainId":               ethChainID,
+	"eth_blockNumber":           ethBlockNumber,
+	"eth_gasPrice":              ethGasPrice,
+	"eth_accounts":              ethAccounts,
+	"eth_getBalance":            ethGetBalance,
+	"eth_getTransactionCount":   ethGetTransactionCount,
+	"eth_getCode":               ethGetCode,
+	"eth_getStorageAt":          ethGetStorageAt,
+	"eth_call":                  ethCall,
+	"eth_estimateGas":           ethEstimateGas,
+	"eth_sendTransaction":       ethSendTransaction,
+	"eth_sendRawTransaction":    ethSendRawTransaction,
+	"eth_getTransactionByHash":  ethGetTransactionByHash,
+	"eth_getTransactionReceipt": ethGetTransactionReceipt,
+	"eth_getBlockByNumber":      ethGetBlockByNumber,
+	"eth_getBlockByHash":        ethGetBlockByHash,
+	"eth_getLogs":               ethGetLogs,
+	"txpool_content":            txpoolContent,
+	"txpool_status":             txpoolStatus,
+}
+
+/*
+POST /rpc
+data: JSON-RPC 2.0 request, or batch (array) of requests
+returns: JSON-RPC 2.0 response, or batch of responses
+
+This endpoint exposes a subset of the Ethereum JSON-RPC API so that standard
+tooling (web3.js, ethers, Truffle, MetaMask...) can talk to evm-lite. Methods
+are mapped onto the same State accessors as the REST endpoints.
+*/
+func rpcHandler(w http.ResponseWriter, r *http.Request, m *Service) {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.logger.WithError(err).Error("Reading request body")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := m.serveRPC(body, nil)
+
+	//Nothing to answer when the request only contained notifications
+	if res == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// serveRPC executes a JSON-RPC request or batch of requests, received on the
+// WebSocket connection c or over HTTP if c is nil, and returns the encoded
+// response. It returns nil if there is nothing to answer.
+func (m *Service) serveRPC(body []byte, c *wsConn) []byte {
+	var res interface{}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			res = errorResponse(nil, newRPCError(rpcParseError, "parse error: %v", err))
+		} else if len(batch) == 0 {
+			res = errorResponse(nil, newRPCError(rpcInvalidRequest, "empty batch"))
+		} else {
+			responses := []*rpcResponse{}
+			for _, raw := range batch {
+				if resp := m.handleRPC(raw, c); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if len(responses) > 0 {
+				res = responses
+			}
+		}
+	} else if resp := m.handleRPC(body, c); resp != nil {
+		res = resp
+	}
+
+	if res == nil {
+		return nil
+	}
+
+	js, err := json.Marshal(res)
+	if err != nil {
+		m.logger.WithError(err).Error("Marshaling JSON response")
+		js, _ = json.Marshal(errorResponse(nil, newRPCError(rpcInternalError, "%v", err)))
+	}
+	return js
+}
+
+// handleRPC executes a single JSON-RPC request. It returns nil for
+// notifications, which must not be answered.
+func (m *Service) handleRPC(raw json.RawMessage, c *wsConn) *rpcResponse {
+	if !json.Valid(raw) {
+		return errorResponse(nil, newRPCError(rpcParseError, "parse error"))
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.Method == "" {
+		return errorResponse(nil, newRPCError(rpcInvalidRequest, "invalid request"))
+	}
+
+	m.logger.WithField("method", req.Method).Debug("RPC")
+
+	var js []byte
+
+	method, rpcErr := lookupMethod(req.Method, c)
+	if rpcErr == nil {
+		result, err := method(m, req.Params)
+		if err != nil {
+			m.logger.WithError(err).WithField("method", req.Method).Debug("RPC error")
+			if e, ok := err.(*rpcError); ok {
+				rpcErr = e
+			} else if e, ok := err.(*state.ExecutionError); ok && e.Reverted() {
+				rpcErr = &rpcError{Code: rpcRevertError, Message: e.Error(), Data: e.Data}
+			} else if e, ok := err.(*state.TxError); ok {
+				rpcErr = &rpcError{Code: rpcServerError, Message: e.Error(), Data: e.Code}
+			} else {
+				rpcErr = newRPCError(rpcServerError, "%v", err)
+			}
+		} else if js, err = json.Marshal(result); err != nil {
+			m.logger.WithError(err).Error("Marshaling RPC result")
+			rpcErr = newRPCError(rpcInternalError, "%v", err)
+		}
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr)
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: js}
+}
+
+// lookupMethod finds the implementation of a JSON-RPC method. Methods that
+// need a WebSocket connection are bound to c, and are not available if c is
+// nil.
+func lookupMethod(name string, c *wsConn) (rpcMethod, *rpcError) {
+	if method, ok := wsMethods[name]; ok {
+		if c == nil {
+			return nil, newRPCError(rpcMethodNotFound, "notifications not supported")
+		}
+		return func(m *Service, params json.RawMessage) (interface{}, error) {
+			return method(m, c, params)
+		}, nil
+	}
+	if method, ok := rpcMethods[name]; ok {
+		return method, nil
+	}
+	return nil, newRPCError(rpcMethodNotFound, "the method %s does not exist/is not available", name)
+}
+
+func errorResponse(id json.RawMessage, err *rpcError) *rpcResponse {
+	return &rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// parseParams decodes positional params into args. The first required args
+// must be present; trailing args that are omitted keep their current value, so
+// they act as defaults.
+func parseParams(params json.RawMessage, required int, args ...interface{}) error {
+	var raw []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &raw); err != nil {
+			return newRPCError(rpcInvalidParams, "non-array params")
+		}
+	}
+	if len(raw) > len(args) {
+		return newRPCError(rpcInvalidParams, "too many arguments, want at most %d", len(args))
+	}
+	if len(raw) < required {
+		return newRPCError(rpcInvalidParams, "missing value for required argument %d", len(raw))
+	}
+	for i := range raw {
+		if err := json.Unmarshal(raw[i], args[i]); err != nil {
+			return newRPCError(rpcInvalidParams, "invalid argument %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// rpcBlockNumber is a block number parameter. Besides hex numbers it accepts
+// the "earliest", "latest" and "pending" tags.
+type rpcBlockNumber int64
+
+const (
+	pendingBlockNumber  = rpcBlockNumber(-2)
+	latestBlockNumber   = rpcBlockNumber(-1)
+	earliestBlockNumber = rpcBlockNumber(0)
+)
+
+func (bn *rpcBlockNumber) UnmarshalJSON(data []byte) error {
+	input := strings.Trim(strings.TrimSpace(string(data)), `"`)
+	switch input {
+	case "earliest":
+		*bn = earliestBlockNumber
+		return nil
+	case "latest":
+		*bn = latestBlockNumber
+		return nil
+	case "pending":
+		*bn = pendingBlockNumber
+		return nil
+	}
+	n, err := hexutil.DecodeUint64(input)
+	if err != nil {
+		return err
+	}
+	if n > math.MaxInt64 {
+		return fmt.Errorf("block number larger than int64")
+	}
+	*bn = rpcBlockNumber(n)
+	return nil
+}
@@ -0,0 +1,456 @@
+package service
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/bear987978897/evm-lite/src/version"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethState "github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func web3ClientVersion(m *Service, params json.RawMessage) (interface{}, error) {
+	return "evm-lite/v" + version.Version, nil
+}
+
+func web3Sha3(m *Service, params json.RawMessage) (interface{}, error) {
+	var data hexutil.Bytes
+	if err := parseParams(params, 1, &data); err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(crypto.Keccak256(data)), nil
+}
+
+func netVersion(m *Service, params json.RawMessage) (interface{}, error) {
+	return m.state.GetChainID().String(), nil
+}
+
+func netListening(m *Service, params json.RawMessage) (interface{}, error) {
+	return true, nil
+}
+
+func ethChainID(m *Service, params json.RawMessage) (interface{}, error) {
+	return (*hexutil.Big)(m.state.GetChainID()), nil
+}
+
+func ethBlockNumber(m *Service, params json.RawMessage) (interface{}, error) {
+	return hexutil.Uint64(m.state.CurrentBlock().NumberU64()), nil
+}
+
+func ethGasPrice(m *Service, params json.RawMessage) (interface{}, error) {
+	return (*hexutil.Big)(big.NewInt(0)), nil
+}
+
+func ethAccounts(m *Service, params json.RawMessage) (interface{}, error) {
+	addresses := []common.Address{}
+	for _, account := range m.keyStore.Accounts() {
+		addresses = append(addresses, account.Address)
+	}
+	return addresses, nil
+}
+
+func ethGetBalance(m *Service, params json.RawMessage) (interface{}, error) {
+	var address common.Address
+	bn := latestBlockNumber
+	if err := parseParams(params, 1, &address, &bn); err != nil {
+		return nil, err
+	}
+	st, err := m.stateAt(bn)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(st.GetBalance(address)), nil
+}
+
+func ethGetTransactionCount(m *Service, params json.RawMessage) (interface{}, error) {
+	var address common.Address
+	bn := latestBlockNumber
+	if err := parseParams(params, 1, &address, &bn); err != nil {
+		return nil, err
+	}
+	//The pending nonce accounts for transactions submitted to the pool
+	if bn == pendingBlockNumber {
+		return hexutil.Uint64(m.state.GetPoolNonce(address)), nil
+	}
+	st, err := m.stateAt(bn)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Uint64(st.GetNonce(address)), nil
+}
+
+func ethGetCode(m *Service, params json.RawMessage) (interface{}, error) {
+	var address common.Address
+	bn := latestBlockNumber
+	if err := parseParams(params, 1, &address, &bn); err != nil {
+		return nil, err
+	}
+	st, err := m.stateAt(bn)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(st.GetCode(address)), nil
+}
+
+func ethGetStorageAt(m *Service, params json.RawMessage) (interface{}, error) {
+	var address common.Address
+	var key string
+	bn := latestBlockNumber
+	if err := parseParams(params, 2, &address, &key, &bn); err != nil {
+		return nil, err
+	}
+	st, err := m.stateAt(bn)
+	if err != nil {
+		return nil, err
+	}
+	value := st.GetState(address, common.HexToHash(key))
+	return hexutil.Bytes(value.Bytes()), nil
+}
+
+func ethCall(m *Service, params json.RawMessage) (interface{}, error) {
+	var args RPCCallArgs
+	bn := latestBlockNumber
+	if err := parseParams(params, 1, &args, &bn); err != nil {
+		return nil, err
+	}
+	//Calls are executed on top of the WAS, there is no historical EVM state
+	if bn >= 0 && uint64(bn) != m.state.CurrentBlock().NumberU64() {
+		return nil, newRPCError(rpcInvalidParams, "calls can only be executed on the latest block")
+	}
+
+	callMessage, err := prepareCallMessage(args.toSendTxArgs(), m.keyStore)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := m.state.Call(*callMessage)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(data), nil
+}
+
+func ethEstimateGas(m *Service, params json.RawMessage) (interface{}, error) {
+	var args RPCCallArgs
+	bn := latestBlockNumber
+	if err := parseParams(params, 1, &args, &bn); err != nil {
+		return nil, err
+	}
+	//Estimations are executed on top of the WAS, like calls
+	if bn >= 0 && uint64(bn) != m.state.CurrentBlock().NumberU64() {
+		return nil, newRPCError(rpcInvalidParams, "gas can only be estimated on the latest block")
+	}
+
+	callMessage, err := prepareEstimateMessage(args.toSendTxArgs(), m.keyStore)
+	if err != nil {
+		return nil, err
+	}
+
+	gas, err := m.state.EstimateGas(*callMessage)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Uint64(gas), nil
+}
+
+func ethSendTransaction(m *Service, params json.RawMessage) (interface{}, error) {
+	var args RPCCallArgs
+	if err := parseParams(params, 1, &args); err != nil {
+		return nil, err
+	}
+
+	tx, err := prepareTransaction(args.toSendTxArgs(), m.state, m.keyStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.submitTx(tx); err != nil {
+		return nil, err
+	}
+
+	return tx.Hash(), nil
+}
+
+func ethSendRawTransaction(m *Service, params json.RawMessage) (interface{}, error) {
+	var data hexutil.Bytes
+	if err := parseParams(params, 1, &data); err != nil {
+		return nil, err
+	}
+
+	tx, err := state.DecodeTx(data)
+	if err != nil {
+		return nil, newRPCError(rpcInvalidParams, "%v", err)
+	}
+
+	if err := m.submitTx(tx); err != nil {
+		return nil, err
+	}
+
+	return tx.Hash(), nil
+}
+
+func ethGetTransactionByHash(m *Service, params json.RawMessage) (interface{}, error) {
+	var hash common.Hash
+	if err := parseParams(params, 1, &hash); err != nil {
+		return nil, err
+	}
+
+	//Unknown transactions are not an error, the result is null
+	blockHash, blockNumber, index, err := m.state.GetTransactionLocation(hash)
+	if err != nil {
+		tx, status, _ := m.state.GetTxStatus(hash)
+		if status == state.TxStatusPending || status == state.TxStatusQueued {
+			return newRPCPendingTransaction(tx, m.state.GetSigner()), nil
+		}
+		return nil, nil
+	}
+
+	tx, err := m.state.GetTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRPCTransaction(tx, m.state.GetSigner(), blockHash, blockNumber, index), nil
+}
+
+func ethGetTransactionReceipt(m *Service, params json.RawMessage) (interface{}, error) {
+	var hash common.Hash
+	if err := parseParams(params, 1, &hash); err != nil {
+		return nil, err
+	}
+
+	//Transactions that are unknown or not yet in a block have no receipt. It
+	//is not an error, the result is null.
+	blockHash, blockNumber, index, err := m.state.GetTransactionLocation(hash)
+	if err != nil {
+		return nil, nil
+	}
+
+	tx, err := m.state.GetTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt, err := m.state.GetReceipt(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := ethTypes.Sender(m.state.GetSigner(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcReceipt := RPCReceipt{
+		BlockHash:         blockHash,
+		BlockNumber:       hexutil.Uint64(blockNumber),
+		TransactionHash:   hash,
+		TransactionIndex:  hexutil.Uint64(index),
+		From:              from,
+		To:                tx.To(),
+		GasUsed:           hexutil.Uint64(receipt.GasUsed),
+		CumulativeGasUsed: hexutil.Uint64(receipt.CumulativeGasUsed),
+		Logs:              receipt.Logs,
+		LogsBloom:         receipt.Bloom,
+	}
+
+	if receipt.Logs == nil {
+		rpcReceipt.Logs = []*ethTypes.Log{}
+	}
+
+	if receipt.ContractAddress != (common.Address{}) {
+		rpcReceipt.ContractAddress = &receipt.ContractAddress
+	}
+
+	execErr, err := m.state.GetExecutionError(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcReceipt.Root = receipt.PostState
+	rpcReceipt.Status = hexutil.Uint64(receiptStatus(receipt, execErr))
+	if execErr != nil {
+		rpcReceipt.Error = execErr.Err
+		rpcReceipt.RevertReason = execErr.Reason
+	}
+
+	return rpcReceipt, nil
+}
+
+func ethGetBlockByNumber(m *Service, params json.RawMessage) (interface{}, error) {
+	var bn rpcBlockNumber
+	var fullTx bool
+	if err := parseParams(params, 1, &bn, &fullTx); err != nil {
+		return nil, err
+	}
+
+	//Unknown blocks are not an error, the result is null
+	block, err := m.state.GetBlockByNumber(m.blockNumber(bn))
+	if err != nil {
+		return nil, nil
+	}
+
+	return newRPCBlock(block, m.state.GetSigner(), fullTx), nil
+}
+
+func ethGetBlockByHash(m *Service, params json.RawMessage) (interface{}, error) {
+	var hash common.Hash
+	var fullTx bool
+	if err := parseParams(params, 1, &hash, &fullTx); err != nil {
+		return nil, err
+	}
+
+	//Unknown blocks are not an error, the result is null
+	block, err := m.state.GetBlockByHash(hash)
+	if err != nil {
+		return nil, nil
+	}
+
+	return newRPCBlock(block, m.state.GetSigner(), fullTx), nil
+}
+
+func ethGetLogs(m *Service, params json.RawMessage) (interface{}, error) {
+	var crit FilterCriteria
+	if err := parseParams(params, 1, &crit); err != nil {
+		return nil, err
+	}
+	return m.getLogs(crit)
+}
+
+func txpoolContent(m *Service, params json.RawMessage) (interface{}, error) {
+	return m.poolContent(), nil
+}
+
+func txpoolStatus(m *Service, params json.RawMessage) (interface{}, error) {
+	pending, queued := m.state.GetPoolStats()
+	return map[string]hexutil.Uint{
+		"pending": hexutil.Uint(pending),
+		"queued":  hexutil.Uint(queued),
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// blockNumber resolves a block number parameter. The state of pending
+// transactions is not exposed, so "pending" resolves to the latest block.
+func (m *Service) blockNumber(bn rpcBlockNumber) uint64 {
+	if bn < 0 {
+		return m.state.CurrentBlock().NumberU64()
+	}
+	return uint64(bn)
+}
+
+// stateAt opens the state committed with the given block
+func (m *Service) stateAt(bn rpcBlockNumber) (*ethState.StateDB, error) {
+	st, err := m.state.StateAt(m.blockNumber(bn))
+	if err != nil {
+		return nil, newRPCError(rpcServerError, "%v", err)
+	}
+	return st, nil
+}
+
+func (args RPCCallArgs) toSendTxArgs() SendTxArgs {
+	res := SendTxArgs{
+		From: args.From,
+		To:   args.To,
+	}
+	if args.Gas != nil {
+		res.Gas = uint64(*args.Gas)
+	}
+	if args.GasPrice != nil {
+		res.GasPrice = args.GasPrice.ToInt()
+	}
+	if args.Value != nil {
+		res.Value = args.Value.ToInt()
+	}
+	//input is the newer name of data, it takes precedence
+	if args.Input != nil {
+		res.Data = args.Input.String()
+	} else if args.Data != nil {
+		res.Data = args.Data.String()
+	}
+	if args.Nonce != nil {
+		nonce := uint64(*args.Nonce)
+		res.Nonce = &nonce
+	}
+	return res
+}
+
+func newRPCTransaction(tx *ethTypes.Transaction,
+	signer ethTypes.Signer,
+	blockHash common.Hash,
+	blockNumber uint64,
+	index uint64) *RPCTransaction {
+
+	from, _ := ethTypes.Sender(signer, tx)
+	v, r, s := tx.RawSignatureValues()
+	txIndex := hexutil.Uint64(index)
+
+	return &RPCTransaction{
+		BlockHash:        &blockHash,
+		BlockNumber:      (*hexutil.Big)(new(big.Int).SetUint64(blockNumber)),
+		From:             from,
+		Gas:              hexutil.Uint64(tx.Gas()),
+		GasPrice:         (*hexutil.Big)(tx.GasPrice()),
+		Hash:             tx.Hash(),
+		Input:            hexutil.Bytes(tx.Data()),
+		Nonce:            hexutil.Uint64(tx.Nonce()),
+		To:               tx.To(),
+		TransactionIndex: &txIndex,
+		Value:            (*hexutil.Big)(tx.Value()),
+		V:                (*hexutil.Big)(v),
+		R:                (*hexutil.Big)(r),
+		S:                (*hexutil.Big)(s),
+	}
+}
+
+// newRPCPendingTransaction returns the representation of a transaction that is
+// not part of a block yet
+func newRPCPendingTransaction(tx *ethTypes.Transaction, signer ethTypes.Signer) *RPCTransaction {
+	rpcTx := newRPCTransaction(tx, signer, common.Hash{}, 0, 0)
+	rpcTx.BlockHash = nil
+	rpcTx.BlockNumber = nil
+	rpcTx.TransactionIndex = nil
+	return rpcTx
+}
+
+func newRPCBlock(block *ethTypes.Block, signer ethTypes.Signer, fullTx bool) *RPCBlock {
+	header := block.Header()
+
+	transactions := []interface{}{}
+	for i, tx := range block.Transactions() {
+		if fullTx {
+			transactions = append(transactions,
+				newRPCTransaction(tx, signer, block.Hash(), block.NumberU64(), uint64(i)))
+		} else {
+			transactions = append(transactions, tx.Hash())
+		}
+	}
+
+	return &RPCBlock{
+		Number:           (*hexutil.Big)(header.Number),
+		Hash:             block.Hash(),
+		ParentHash:       header.ParentHash,
+		Nonce:            header.Nonce,
+		MixHash:          header.MixDigest,
+		Sha3Uncles:       header.UncleHash,
+		LogsBloom:        header.Bloom,
+		StateRoot:        header.Root,
+		Miner:            header.Coinbase,
+		Difficulty:       (*hexutil.Big)(header.Difficulty),
+		TotalDifficulty:  (*hexutil.Big)(big.NewInt(0)),
+		ExtraData:        hexutil.Bytes(header.Extra),
+		Size:             hexutil.Uint64(block.Size()),
+		GasLimit:         hexutil.Uint64(header.GasLimit),
+		GasUsed:          hexutil.Uint64(header.GasUsed),
+		Timestamp:        (*hexutil.Big)(header.Time),
+		TransactionsRoot: header.TxHash,
+		ReceiptsRoot:     header.ReceiptHash,
+		Transactions:     transactions,
+		Uncles:           []common.Hash{},
+	}
+}
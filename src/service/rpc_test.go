@@ -0,0 +1,221 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestRPCMethods(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	var chainID hexutil.Big
+	if err := ts.rpc(t, &chainID, "eth_chainId"); err != nil {
+		t.Fatal(err)
+	}
+	if chainID.ToInt().Cmp(ts.state.GetChainID()) != 0 {
+		t.Fatalf("Chain ID should be %v, not %v", ts.state.GetChainID(), chainID.ToInt())
+	}
+
+	var hash hexutil.Bytes
+	if err := ts.rpc(t, &hash, "web3_sha3", "0x68656c6c6f"); err != nil {
+		t.Fatal(err)
+	}
+	if want := crypto.Keccak256([]byte("hello")); !strings.EqualFold(hash.String(), hexutil.Encode(want)) {
+		t.Fatalf("web3_sha3 should return %x, not %s", want, hash)
+	}
+
+	// Transactions are submitted raw, and are pending until committed
+	tx := ts.transfer(t, 0)
+	var txHash common.Hash
+	if err := ts.rpc(t, &txHash, "eth_sendRawTransaction", hexutil.Bytes(mustEncode(t, tx))); err != nil {
+		t.Fatal(err)
+	}
+	if txHash != tx.Hash() {
+		t.Fatalf("eth_sendRawTransaction should return %s, not %s", tx.Hash().Hex(), txHash.Hex())
+	}
+	var nonce hexutil.Uint64
+	if err := ts.rpc(t, &nonce, "eth_getTransactionCount", ts.from, "pending"); err != nil {
+		t.Fatal(err)
+	}
+	if nonce != 1 {
+		t.Fatalf("Pending nonce should be 1, not %d", nonce)
+	}
+	if err := ts.rpc(t, &nonce, "eth_getTransactionCount", ts.from); err != nil {
+		t.Fatal(err)
+	}
+	if nonce != 0 {
+		t.Fatalf("Latest nonce should be 0, not %d", nonce)
+	}
+
+	ts.commit(t, tx)
+
+	var number hexutil.Uint64
+	if err := ts.rpc(t, &number, "eth_blockNumber"); err != nil {
+		t.Fatal(err)
+	}
+	if number != 1 {
+		t.Fatalf("Block number should be 1, not %d", number)
+	}
+
+	// Balances are read at the requested block
+	b0b := common.HexToAddress("b0b")
+	var balance hexutil.Big
+	if err := ts.rpc(t, &balance, "eth_getBalance", b0b, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if balance.ToInt().Int64() != 1 {
+		t.Fatalf("Latest balance should be 1, not %v", balance.ToInt())
+	}
+	if err := ts.rpc(t, &balance, "eth_getBalance", b0b, "earliest"); err != nil {
+		t.Fatal(err)
+	}
+	if balance.ToInt().Int64() != 0 {
+		t.Fatalf("Genesis balance should be 0, not %v", balance.ToInt())
+	}
+
+	var block RPCBlock
+	if err := ts.rpc(t, &block, "eth_getBlockByNumber", "0x1", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions) != 1 || block.Transactions[0] != tx.Hash().Hex() {
+		t.Fatalf("Block 1 should hold the hash of the transaction, not %v", block.Transactions)
+	}
+	var missing json.RawMessage
+	if err := ts.rpc(t, &missing, "eth_getBlockByNumber", "0x10", false); err != nil {
+		t.Fatal(err)
+	}
+	if string(missing) != "null" {
+		t.Fatalf("Unknown block should be null, not %s", missing)
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	expected := []struct {
+		method string
+		params []interface{}
+		code   int
+	}{
+		{"eth_unknown", nil, rpcMethodNotFound},
+		{"eth_subscribe", []interface{}{"newHeads"}, rpcMethodNotFound},
+		{"eth_getBalance", nil, rpcInvalidParams},
+		{"eth_getBalance", []interface{}{"0xb0b", "latest", 1}, rpcInvalidParams},
+		{"eth_getBalance", []interface{}{"0xb0b", "tomorrow"}, rpcInvalidParams},
+		{"eth_sendRawTransaction", []interface{}{"0x0102"}, rpcInvalidParams},
+	}
+	for _, e := range expected {
+		err := ts.rpc(t, nil, e.method, e.params...)
+		if err == nil || err.Code != e.code {
+			t.Fatalf("%s %v should fail with code %d, not %v", e.method, e.params, e.code, err)
+		}
+	}
+
+	// Transactions rejected by the TxPool carry the code of their TxError
+	err := ts.rpc(t, nil, "eth_sendRawTransaction", hexutil.Bytes(mustEncode(t, ts.transfer(t, 5))))
+	if err != nil {
+		t.Fatalf("Queued transaction should be accepted, got %v", err)
+	}
+	err = ts.rpc(t, nil, "eth_sendRawTransaction", hexutil.Bytes(mustEncode(t, ts.transfer(t, 5))))
+	if err == nil || err.Code != rpcServerError || err.Data == nil {
+		t.Fatalf("Known transaction should fail with a TxError code, not %v", err)
+	}
+
+	bodies := []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": `, rpcParseError},
+		{`{"jsonrpc": "2.0", "id": 1}`, rpcInvalidRequest},
+		{`[]`, rpcInvalidRequest},
+		{`[1`, rpcParseError},
+	}
+	for _, b := range bodies {
+		var res rpcResponse
+		if err := json.Unmarshal(ts.serveRPC([]byte(b.body), nil), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error == nil || res.Error.Code != b.code {
+			t.Fatalf("%s should fail with code %d, not %v", b.body, b.code, res.Error)
+		}
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	// Notifications, without id, are not answered
+	body := `[
+		{"jsonrpc": "2.0", "id": 1, "method": "eth_blockNumber"},
+		{"jsonrpc": "2.0", "method": "eth_blockNumber"},
+		{"jsonrpc": "2.0", "id": "two", "method": "eth_unknown"},
+		42
+	]`
+	var res []rpcResponse
+	if err := json.Unmarshal(ts.serveRPC([]byte(body), nil), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("Batch should have 3 responses, not %d", len(res))
+	}
+	if string(res[0].ID) != "1" || string(res[0].Result) != `"0x0"` || res[0].Error != nil {
+		t.Fatalf("First response should be block 0x0, not %+v", res[0])
+	}
+	if string(res[1].ID) != `"two"` || res[1].Error == nil || res[1].Error.Code != rpcMethodNotFound {
+		t.Fatalf("Second response should be a method not found error, not %+v", res[1])
+	}
+	if res[2].Error == nil || res[2].Error.Code != rpcInvalidRequest {
+		t.Fatalf("Third response should be an invalid request error, not %+v", res[2])
+	}
+
+	if res := ts.serveRPC([]byte(`[{"jsonrpc": "2.0", "method": "eth_blockNumber"}]`), nil); res != nil {
+		t.Fatalf("Batch of notifications should not be answered, got %s", res)
+	}
+}
+
+func TestRPCHandler(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	handler := ts.makeHandler(rpcHandler)
+
+	body := `{"jsonrpc": "2.0", "id": 7, "method": "net_version"}`
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/rpc", strings.NewReader(body)))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Response should be 200 application/json, not %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var res rpcResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.ID) != "7" || string(res.Result) != `"1"` {
+		t.Fatalf("net_version should return \"1\", not %+v", res)
+	}
+
+	// Notifications get an empty response
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "net_version"}`)))
+	if w.Body.Len() != 0 {
+		t.Fatalf("Notification should not be answered, got %s", w.Body)
+	}
+}
+
+func mustEncode(t *testing.T, v interface{}) []byte {
+	data, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
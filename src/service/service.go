@@ -1,20 +1,30 @@
 package service
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bear987978897/evm-lite/src/state"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
 var defaultGas = uint64(90000)
 
+// txEventBuffer is the number of NewTxsEvents that can be queued between the
+// TxPool and the Service
+const txEventBuffer = 64
+
 type infoCallback func() (map[string]string, error)
 
 type Service struct {
@@ -26,7 +36,25 @@ type Service struct {
 	keyStore    *keystore.KeyStore
 	pwdFile     string
 	getInfo     infoCallback
+	subs        *subscriptionHub
 	logger      *logrus.Logger
+
+	// Pending transactions whose submission to the consensus system a
+	// transaction endpoint waits for
+	submitTimeout time.Duration
+	submitLock    sync.Mutex
+	submissions   map[common.Hash]chan error
+}
+
+// submitError is returned by the transaction endpoints when the consensus
+// system could not accept a pending transaction, which was then dropped from
+// the TxPool
+type submitError struct {
+	err error
+}
+
+func (e *submitError) Error() string {
+	return fmt.Sprintf("Transaction not submitted: %v", e.err)
 }
 
 func NewService(keystoreDir, apiAddr, pwdFile string,
@@ -39,7 +67,9 @@ func NewService(keystoreDir, apiAddr, pwdFile string,
 		pwdFile:     pwdFile,
 		state:       state,
 		submitCh:    submitCh,
-		logger:      logger}
+		subs:        newSubscriptionHub(logger),
+		logger:      logger,
+		submissions: make(map[common.Hash]chan error)}
 }
 
 func (m *Service) Run() {
@@ -47,6 +77,11 @@ func (m *Service) Run() {
 
 	m.checkErr(m.unlockAccounts())
 
+	go m.subs.run(m.state)
+
+	txCh := make(chan state.NewTxsEvent, txEventBuffer)
+	go m.forwardTxs(txCh, m.state.SubscribeNewTxsEvent(txCh))
+
 	m.logger.Info("serving api...")
 	m.serveAPI()
 }
@@ -59,6 +94,26 @@ func (m *Service) SetInfoCallback(f infoCallback) {
 	m.getInfo = f
 }
 
+// SetSubmitTimeout makes the transaction endpoints wait, for up to timeout,
+// until the consensus system reports with TxSubmitted whether it accepted the
+// transactions that they made pending. Consensus systems that can fail to
+// accept them set it in Init.
+func (m *Service) SetSubmitTimeout(timeout time.Duration) {
+	m.submitTimeout = timeout
+}
+
+// TxSubmitted reports whether the consensus system accepted a transaction that
+// it read from submitCh: err is nil if it did
+func (m *Service) TxSubmitted(hash common.Hash, err error) {
+	m.submitLock.Lock()
+	defer m.submitLock.Unlock()
+
+	if ch, ok := m.submissions[hash]; ok {
+		ch <- err
+		delete(m.submissions, hash)
+	}
+}
+
 func (m *Service) makeKeyStore() error {
 
 	scryptN := keystore.StandardScryptN
@@ -99,11 +154,18 @@ func (m *Service) serveAPI() {
 	r.HandleFunc("/account/{address}", m.makeHandler(accountHandler)).Methods("GET")
 	r.HandleFunc("/accounts", m.makeHandler(accountsHandler)).Methods("GET")
 	r.HandleFunc("/call", m.makeHandler(callHandler)).Methods("POST")
+	r.HandleFunc("/estimateGas", m.makeHandler(estimateGasHandler)).Methods("POST")
 	r.HandleFunc("/tx", m.makeHandler(transactionHandler)).Methods("POST")
 	r.HandleFunc("/rawtx", m.makeHandler(rawTransactionHandler)).Methods("POST")
 	r.HandleFunc("/tx/{tx_hash}", m.makeHandler(transactionReceiptHandler)).Methods("GET")
+	r.HandleFunc("/logs", m.makeHandler(logsHandler)).Methods("POST")
+	r.HandleFunc("/txpool/content", m.makeHandler(poolContentHandler)).Methods("GET")
+	r.HandleFunc("/txpool/status", m.makeHandler(poolStatusHandler)).Methods("GET")
+	r.HandleFunc("/txpool/tx/{tx_hash}", m.makeHandler(txStatusHandler)).Methods("GET")
 	r.HandleFunc("/info", m.makeHandler(infoHandler)).Methods("GET")
 	r.HandleFunc("/html/info", m.makeHandler(htmlInfoHandler)).Methods("GET")
+	r.HandleFunc("/rpc", m.makeHandler(rpcHandler)).Methods("POST")
+	r.HandleFunc("/ws", m.wsHandler).Methods("GET")
 	http.Handle("/", &CORSServer{r})
 	http.ListenAndServe(m.apiAddr, nil)
 }
@@ -135,6 +197,93 @@ func (m *Service) makeHandler(fn func(http.ResponseWriter, *http.Request, *Servi
 	}
 }
 
+// submitTx adds a transaction to the TxPool. Rejected transactions are
+// reported with a *state.TxError. Accepted transactions reach the consensus
+// system through forwardTxs once they are pending. With a submit timeout,
+// submitTx then waits for the consensus system to accept the transaction, and
+// returns a *submitError if it does not. It is called with the Service lock
+// held, and releases it while waiting.
+func (m *Service) submitTx(tx *ethTypes.Transaction) error {
+	m.logger.WithField("hash", tx.Hash().Hex()).Debug("submitting tx")
+	if m.submitTimeout == 0 {
+		return m.state.AddTx(tx)
+	}
+
+	// Wait from before AddTx, which can submit the transaction right away
+	hash := tx.Hash()
+	ch := make(chan error, 1)
+	m.submitLock.Lock()
+	m.submissions[hash] = ch
+	m.submitLock.Unlock()
+	defer func() {
+		m.submitLock.Lock()
+		if m.submissions[hash] == ch {
+			delete(m.submissions, hash)
+		}
+		m.submitLock.Unlock()
+	}()
+
+	if err := m.state.AddTx(tx); err != nil {
+		return err
+	}
+	// Queued transactions are submitted once the ones before them are
+	// included
+	if _, status, _ := m.state.GetTxStatus(hash); status == state.TxStatusQueued {
+		return nil
+	}
+
+	m.Unlock()
+	defer m.Lock()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			return &submitError{err}
+		}
+		return nil
+	case <-time.After(m.submitTimeout):
+		m.logger.WithField("hash", hash.Hex()).Warn("Timed out waiting for submission")
+		return nil
+	}
+}
+
+// forwardTxs submits the transactions that become pending in the TxPool to the
+// consensus system, in order, and notifies newPendingTransactions subscribers.
+// Transactions are buffered here so that the TxPool, and hence Commit, never
+// waits for the consensus system to read submitCh.
+func (m *Service) forwardTxs(ch chan state.NewTxsEvent, sub event.Subscription) {
+	defer sub.Unsubscribe()
+
+	var queue [][]byte
+	for {
+		var (
+			submitCh chan []byte
+			next     []byte
+		)
+		if len(queue) > 0 {
+			submitCh, next = m.submitCh, queue[0]
+		}
+
+		select {
+		case ev := <-ch:
+			for _, tx := range ev.Txs {
+				data, err := rlp.EncodeToBytes(tx)
+				if err != nil {
+					m.logger.WithError(err).Error("Encoding Transaction")
+					continue
+				}
+				queue = append(queue, data)
+				m.subs.postPendingTx(tx.Hash())
+			}
+		case submitCh <- next:
+			queue = queue[1:]
+			m.logger.Debug("submitted tx")
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
 func (m *Service) checkErr(err error) {
 	if err != nil {
 		m.logger.WithError(err).Error("ERROR")
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// testService is a Service on top of a fresh State, whose genesis funds the
+// account of key
+type testService struct {
+	*Service
+	key  *ecdsa.PrivateKey
+	from common.Address
+	dir  string
+}
+
+func newTestService(t *testing.T) *testService {
+	dir, err := ioutil.TempDir("", "evml-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := state.NewTestAccount(t)
+	s := account.NewState(t, dir, "")
+
+	m := NewService(filepath.Join(dir, "keystore"),
+		"",
+		filepath.Join(dir, "pwd.txt"),
+		s,
+		make(chan []byte, 16),
+		bcommon.NewTestLogger(t))
+
+	return &testService{Service: m, key: account.Key, from: account.Address, dir: dir}
+}
+
+func (ts *testService) close() {
+	os.RemoveAll(ts.dir)
+}
+
+// transfer returns a signed transfer of 1 wei from the funded account
+func (ts *testService) transfer(t *testing.T, nonce uint64) *ethTypes.Transaction {
+	tx, err := ethTypes.SignTx(
+		ethTypes.NewTransaction(nonce, common.HexToAddress("b0b"), big.NewInt(1), 21000, big.NewInt(0), nil),
+		ts.state.GetSigner(),
+		ts.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// commit applies transactions to the State as a block, as a consensus system
+// would
+func (ts *testService) commit(t *testing.T, txs ...*ethTypes.Transaction) {
+	for _, tx := range txs {
+		data, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.state.ApplyTransaction(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ts.state.Commit(ts.state.LastIndex() + 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rpc calls a JSON-RPC method and decodes its result into result, unless it
+// is nil. It returns the error of the response.
+func (ts *testService) rpc(t *testing.T, result interface{}, method string, params ...interface{}) *rpcError {
+	if params == nil {
+		params = []interface{}{}
+	}
+	req, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res rpcResponse
+	if err := json.Unmarshal(ts.serveRPC(req, nil), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			t.Fatalf("Decoding result of %s %s: %v", method, res.Result, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of subscriptions supported by eth_subscribe
+const (
+	newHeadsSubscription               = "newHeads"
+	logsSubscription                   = "logs"
+	newPendingTransactionsSubscription = "newPendingTransactions"
+)
+
+// chainEventBuffer is the number of committed blocks that can be queued
+// between the State and the subscription hub.
+const chainEventBuffer = 16
+
+type subscription struct {
+	id   string
+	kind string
+	crit FilterCriteria
+	conn *wsConn
+}
+
+func newSubscription(kind string, crit FilterCriteria, conn *wsConn) *subscription {
+	var id [16]byte
+	rand.Read(id[:])
+	return &subscription{
+		id:   hexutil.Encode(id[:]),
+		kind: kind,
+		crit: crit,
+		conn: conn,
+	}
+}
+
+// notify queues an eth_subscription notification on the subscriber's
+// connection.
+func (s *subscription) notify(result interface{}) {
+	msg, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_subscription",
+		"params": map[string]interface{}{
+			"subscription": s.id,
+			"result":       result,
+		},
+	})
+	if err != nil {
+		s.conn.logger.WithError(err).Error("Marshaling subscription notification")
+		return
+	}
+	s.conn.queue(msg)
+}
+
+// subscriptionHub dispatches committed blocks, logs and submitted transactions
+// to the active subscriptions. It never blocks on subscribers: notifications
+// are queued on each connection, and connections that fall behind are closed,
+// so that a slow subscriber cannot stall the State, and hence consensus.
+type subscriptionHub struct {
+	sync.Mutex
+	subs   map[string]*subscription
+	logger *logrus.Logger
+}
+
+func newSubscriptionHub(logger *logrus.Logger) *subscriptionHub {
+	return &subscriptionHub{
+		subs:   make(map[string]*subscription),
+		logger: logger,
+	}
+}
+
+// run feeds the hub with the blocks committed by the State
+func (h *subscriptionHub) run(st *state.State) {
+	ch := make(chan state.ChainEvent, chainEventBuffer)
+	sub := st.SubscribeChainEvent(ch)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-ch:
+			h.postChainEvent(ev)
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
+func (h *subscriptionHub) postChainEvent(ev state.ChainEvent) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, s := range h.subs {
+		switch s.kind {
+		case newHeadsSubscription:
+			s.notify(ev.Block.Header())
+		case logsSubscription:
+			for _, log := range state.FilterLogs(ev.Logs, s.crit.Addresses, s.crit.Topics) {
+				s.notify(log)
+			}
+		}
+	}
+}
+
+func (h *subscriptionHub) postPendingTx(hash common.Hash) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, s := range h.subs {
+		if s.kind == newPendingTransactionsSubscription {
+			s.notify(hash)
+		}
+	}
+}
+
+func (h *subscriptionHub) add(subs ...*subscription) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, s := range subs {
+		h.subs[s.id] = s
+	}
+}
+
+// remove cancels a subscription. It reports false if the subscription does
+// not exist or belongs to another connection.
+func (h *subscriptionHub) remove(conn *wsConn, id string) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	s, ok := h.subs[id]
+	if !ok || s.conn != conn {
+		return false
+	}
+	delete(h.subs, id)
+	return true
+}
+
+// removeConn cancels all the subscriptions of a connection
+func (h *subscriptionHub) removeConn(conn *wsConn) {
+	h.Lock()
+	defer h.Unlock()
+
+	for id, s := range h.subs {
+		if s.conn == conn {
+			delete(h.subs, id)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// poolContent returns the transactions of the TxPool, in the format of geth's
+// txpool_content: by sender, then by decimal nonce.
+func (m *Service) poolContent() *JsonTxPoolContent {
+	signer := m.state.GetSigner()
+	pending, queued := m.state.GetPoolContent()
+
+	flatten := func(txs map[common.Address][]*ethTypes.Transaction) map[common.Address]map[string]*RPCTransaction {
+		res := make(map[common.Address]map[string]*RPCTransaction)
+		for from, list := range txs {
+			dump := make(map[string]*RPCTransaction)
+			for _, tx := range list {
+				dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx, signer)
+			}
+			res[from] = dump
+		}
+		return res
+	}
+
+	return &JsonTxPoolContent{
+		Pending: flatten(pending),
+		Queued:  flatten(queued),
+	}
+}
+
+// txStatus looks up a transaction in the committed blocks and in the TxPool
+func (m *Service) txStatus(hash common.Hash) (*JsonTxStatus, error) {
+	tx, status, reason := m.state.GetTxStatus(hash)
+
+	res := &JsonTxStatus{
+		TxHash: hash,
+		Status: status,
+		Reason: reason,
+	}
+
+	switch status {
+	case state.TxStatusIncluded:
+		blockHash, blockNumber, index, err := m.state.GetTransactionLocation(hash)
+		if err != nil {
+			return nil, err
+		}
+		res.Transaction = newRPCTransaction(tx, m.state.GetSigner(), blockHash, blockNumber, index)
+	case state.TxStatusPending, state.TxStatusQueued:
+		res.Transaction = newRPCPendingTransaction(tx, m.state.GetSigner())
+	}
+
+	return res, nil
+}
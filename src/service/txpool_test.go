@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestPendingTransaction(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	tx := ts.transfer(t, 0)
+	if err := ts.state.AddTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pending transaction is returned by eth_getTransactionByHash, without
+	// block, but has no receipt until it is in a block
+	var rpcTx map[string]interface{}
+	if err := ts.rpc(t, &rpcTx, "eth_getTransactionByHash", tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if rpcTx == nil || rpcTx["hash"] != tx.Hash().Hex() || rpcTx["blockHash"] != nil {
+		t.Fatalf("Pending transaction should be returned without block, not %v", rpcTx)
+	}
+	var receipt json.RawMessage
+	if err := ts.rpc(t, &receipt, "eth_getTransactionReceipt", tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if string(receipt) != "null" {
+		t.Fatalf("Pending transaction should have no receipt, not %s", receipt)
+	}
+
+	status, err := ts.txStatus(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != state.TxStatusPending || status.Transaction == nil {
+		t.Fatalf("Transaction should be pending, not %s", status.Status)
+	}
+
+	var poolStatus map[string]string
+	if err := ts.rpc(t, &poolStatus, "txpool_status"); err != nil {
+		t.Fatal(err)
+	}
+	if poolStatus["pending"] != "0x1" || poolStatus["queued"] != "0x0" {
+		t.Fatalf("Pool should hold 1 pending transaction, not %v", poolStatus)
+	}
+
+	// Once included, the transaction has a receipt
+	ts.commit(t, tx)
+
+	var included map[string]interface{}
+	if err := ts.rpc(t, &included, "eth_getTransactionReceipt", tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if included == nil || included["status"] != "0x1" || included["blockNumber"] != "0x1" {
+		t.Fatalf("Receipt should be successful in block 1, not %v", included)
+	}
+
+	status, err = ts.txStatus(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != state.TxStatusIncluded {
+		t.Fatalf("Transaction should be included, not %s", status.Status)
+	}
+}
+
+func TestWaitForSubmission(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	ts.SetSubmitTimeout(5 * time.Second)
+	txCh := make(chan state.NewTxsEvent, txEventBuffer)
+	go ts.forwardTxs(txCh, ts.state.SubscribeNewTxsEvent(txCh))
+
+	// The consensus system accepts the first transaction, and fails to submit
+	// the second one, which it drops like Raft does
+	go func() {
+		for i := 0; i < 2; i++ {
+			hash := crypto.Keccak256Hash(<-ts.submitCh)
+			var err error
+			if i == 1 {
+				err = errors.New("No Raft leader")
+				ts.state.DropTx(hash, err.Error())
+			}
+			ts.TxSubmitted(hash, err)
+		}
+	}()
+
+	handler := ts.makeHandler(rawTransactionHandler)
+	post := func(nonce uint64) *httptest.ResponseRecorder {
+		data, err := rlp.EncodeToBytes(ts.transfer(t, nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/rawtx", strings.NewReader(hexutil.Encode(data))))
+		return w
+	}
+
+	if w := post(0); w.Code != http.StatusOK {
+		t.Fatalf("Submitted transaction should be answered with 200, not %d: %s", w.Code, w.Body)
+	}
+	w := post(1)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "No Raft leader") {
+		t.Fatalf("Transaction that was not submitted should be answered with 503, not %d: %s", w.Code, w.Body)
+	}
+
+	// A transaction that is queued behind a nonce gap does not wait
+	if w := post(3); w.Code != http.StatusOK {
+		t.Fatalf("Queued transaction should be answered with 200, not %d: %s", w.Code, w.Body)
+	}
+}
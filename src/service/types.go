@@ -3,7 +3,9 @@ package service
 import (
 	"math/big"
 
+	"github.com/bear987978897/evm-lite/src/state"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -28,14 +30,45 @@ type SendTxArgs struct {
 	Nonce    *uint64         `json:"nonce"`
 }
 
+type JsonLogList struct {
+	Logs []*ethTypes.Log `json:"logs"`
+}
+
 type JsonCallRes struct {
-	Data string `json:"data"`
+	Data   string `json:"data"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type JsonEstimateGasRes struct {
+	Gas uint64 `json:"gas"`
 }
 
 type JsonTxRes struct {
 	TxHash string `json:"txHash"`
 }
 
+// JsonTxPoolContent lists the pending and queued transactions of the TxPool by
+// sender and nonce
+type JsonTxPoolContent struct {
+	Pending map[common.Address]map[string]*RPCTransaction `json:"pending"`
+	Queued  map[common.Address]map[string]*RPCTransaction `json:"queued"`
+}
+
+type JsonTxPoolStatus struct {
+	Pending int `json:"pending"`
+	Queued  int `json:"queued"`
+}
+
+// JsonTxStatus is the status of a transaction submitted to this node. Reason
+// explains why a dropped transaction was dropped.
+type JsonTxStatus struct {
+	TxHash      common.Hash     `json:"txHash"`
+	Status      state.TxStatus  `json:"status"`
+	Reason      string          `json:"reason,omitempty"`
+	Transaction *RPCTransaction `json:"transaction,omitempty"`
+}
+
 type JsonReceipt struct {
 	Root              common.Hash     `json:"root"`
 	TransactionHash   common.Hash     `json:"transactionHash"`
@@ -47,4 +80,81 @@ type JsonReceipt struct {
 	Logs              []*ethTypes.Log `json:"logs"`
 	LogsBloom         ethTypes.Bloom  `json:"logsBloom"`
 	Status            uint64          `json:"status"`
+	Error             string          `json:"error,omitempty"`
+	RevertReason      string          `json:"revertReason,omitempty"`
+}
+
+// RPCCallArgs represents the arguments of the eth_call and eth_sendTransaction
+// JSON-RPC methods. Unlike SendTxArgs, quantities are hex encoded.
+type RPCCallArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Data     *hexutil.Bytes  `json:"data"`
+	Input    *hexutil.Bytes  `json:"input"`
+	Nonce    *hexutil.Uint64 `json:"nonce"`
+}
+
+// RPCTransaction is the JSON-RPC representation of a transaction
+type RPCTransaction struct {
+	BlockHash        *common.Hash    `json:"blockHash"`
+	BlockNumber      *hexutil.Big    `json:"blockNumber"`
+	From             common.Address  `json:"from"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	GasPrice         *hexutil.Big    `json:"gasPrice"`
+	Hash             common.Hash     `json:"hash"`
+	Input            hexutil.Bytes   `json:"input"`
+	Nonce            hexutil.Uint64  `json:"nonce"`
+	To               *common.Address `json:"to"`
+	TransactionIndex *hexutil.Uint64 `json:"transactionIndex"`
+	Value            *hexutil.Big    `json:"value"`
+	V                *hexutil.Big    `json:"v"`
+	R                *hexutil.Big    `json:"r"`
+	S                *hexutil.Big    `json:"s"`
+}
+
+// RPCReceipt is the JSON-RPC representation of a transaction receipt
+type RPCReceipt struct {
+	BlockHash         common.Hash     `json:"blockHash"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	TransactionHash   common.Hash     `json:"transactionHash"`
+	TransactionIndex  hexutil.Uint64  `json:"transactionIndex"`
+	From              common.Address  `json:"from"`
+	To                *common.Address `json:"to"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	CumulativeGasUsed hexutil.Uint64  `json:"cumulativeGasUsed"`
+	ContractAddress   *common.Address `json:"contractAddress"`
+	Logs              []*ethTypes.Log `json:"logs"`
+	LogsBloom         ethTypes.Bloom  `json:"logsBloom"`
+	Root              hexutil.Bytes   `json:"root,omitempty"`
+	Status            hexutil.Uint64  `json:"status"`
+	Error             string          `json:"error,omitempty"`
+	RevertReason      string          `json:"revertReason,omitempty"`
+}
+
+// RPCBlock is the JSON-RPC representation of a block. Transactions contains
+// either transaction hashes or RPCTransactions.
+type RPCBlock struct {
+	Number           *hexutil.Big        `json:"number"`
+	Hash             common.Hash         `json:"hash"`
+	ParentHash       common.Hash         `json:"parentHash"`
+	Nonce            ethTypes.BlockNonce `json:"nonce"`
+	MixHash          common.Hash         `json:"mixHash"`
+	Sha3Uncles       common.Hash         `json:"sha3Uncles"`
+	LogsBloom        ethTypes.Bloom      `json:"logsBloom"`
+	StateRoot        common.Hash         `json:"stateRoot"`
+	Miner            common.Address      `json:"miner"`
+	Difficulty       *hexutil.Big        `json:"difficulty"`
+	TotalDifficulty  *hexutil.Big        `json:"totalDifficulty"`
+	ExtraData        hexutil.Bytes       `json:"extraData"`
+	Size             hexutil.Uint64      `json:"size"`
+	GasLimit         hexutil.Uint64      `json:"gasLimit"`
+	GasUsed          hexutil.Uint64      `json:"gasUsed"`
+	Timestamp        *hexutil.Big        `json:"timestamp"`
+	TransactionsRoot common.Hash         `json:"transactionsRoot"`
+	ReceiptsRoot     common.Hash         `json:"receiptsRoot"`
+	Transactions     []interface{}       `json:"transactions"`
+	Uncles           []common.Hash       `json:"uncles"`
 }
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	wsWriteWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer
+	wsPongWait = 60 * time.Second
+	// Send pings to peer with this period. Must be less than wsPongWait.
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// Maximum size of a request
+	wsMaxMessageSize = 1024 * 1024
+	// Number of outgoing messages queued per connection before it is
+	// considered too slow and closed
+	wsSendBuffer = 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	//Like the CORS handler, accept requests from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsMethod implements a JSON-RPC method that is only available over
+// WebSocket, because it needs the connection.
+type wsMethod func(m *Service, c *wsConn, params json.RawMessage) (interface{}, error)
+
+var wsMethods = map[string]wsMethod{
+	"eth_subscribe":   ethSubscribe,
+	"eth_unsubscribe": ethUnsubscribe,
+}
+
+/*
+GET /ws
+upgrades to: WebSocket carrying JSON-RPC 2.0 messages
+
+Besides all the methods of the /rpc endpoint, WebSocket clients can create
+subscriptions with eth_subscribe. Supported subscriptions are newHeads, logs
+(with an optional address/topics filter) and newPendingTransactions.
+Notifications are queued per connection; a client that does not keep up with
+them is disconnected.
+*/
+func (m *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		m.logger.WithError(err).Error("Upgrading to WebSocket")
+		return
+	}
+
+	c := &wsConn{
+		ws:     ws,
+		send:   make(chan []byte, wsSendBuffer),
+		closed: make(chan struct{}),
+		logger: m.logger,
+	}
+
+	m.logger.WithField("remote", ws.RemoteAddr().String()).Debug("WebSocket connected")
+
+	go c.writeLoop()
+	c.readLoop(m)
+
+	m.subs.removeConn(c)
+	m.logger.WithField("remote", ws.RemoteAddr().String()).Debug("WebSocket disconnected")
+}
+
+type wsConn struct {
+	ws        *websocket.Conn
+	send      chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	logger    *logrus.Logger
+
+	//subscriptions created by the request being processed. They are only
+	//activated once the response is queued, so that notifications never
+	//precede it.
+	pending []*subscription
+}
+
+// queue schedules a message to be sent. It never blocks; if the connection's
+// buffer is full, the connection is closed.
+func (c *wsConn) queue(msg []byte) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+	default:
+		c.logger.WithField("remote", c.ws.RemoteAddr().String()).Warn("Closing slow WebSocket connection")
+		c.close()
+	}
+}
+
+func (c *wsConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+	})
+}
+
+func (c *wsConn) readLoop(m *Service) {
+	defer c.close()
+
+	c.ws.SetReadLimit(wsMaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				m.logger.WithError(err).Debug("Reading WebSocket")
+			}
+			return
+		}
+
+		m.Lock()
+		res := m.serveRPC(msg, c)
+		m.Unlock()
+
+		if res != nil {
+			c.queue(res)
+		}
+		if len(c.pending) > 0 {
+			m.subs.add(c.pending...)
+			c.pending = nil
+		}
+	}
+}
+
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func ethSubscribe(m *Service, c *wsConn, params json.RawMessage) (interface{}, error) {
+	var kind string
+	var crit FilterCriteria
+	if err := parseParams(params, 1, &kind, &crit); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case newHeadsSubscription, logsSubscription, newPendingTransactionsSubscription:
+	default:
+		return nil, newRPCError(rpcInvalidParams, "no %q subscription", kind)
+	}
+
+	s := newSubscription(kind, crit, c)
+	c.pending = append(c.pending, s)
+
+	return s.id, nil
+}
+
+func ethUnsubscribe(m *Service, c *wsConn, params json.RawMessage) (interface{}, error) {
+	var id string
+	if err := parseParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+	return m.subs.remove(c, id), nil
+}
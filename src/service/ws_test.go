@@ -0,0 +1,252 @@
+package service
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bear987978897/evm-lite/src/state"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/websocket"
+)
+
+// wsClient is a WebSocket connection to the /ws endpoint of a testService
+type wsClient struct {
+	*websocket.Conn
+	ts     *testService
+	server *httptest.Server
+	nextID int
+}
+
+// dialWS serves /ws, with the subscription hub fed by the State and the
+// TxPool, and connects to it
+func (ts *testService) dialWS(t *testing.T) *wsClient {
+	// The hub subscribes to the State before returning, so that no block is
+	// committed before it listens
+	blocks := make(chan state.ChainEvent, chainEventBuffer)
+	blocksSub := ts.state.SubscribeChainEvent(blocks)
+	go func() {
+		for {
+			select {
+			case ev := <-blocks:
+				ts.subs.postChainEvent(ev)
+			case <-blocksSub.Err():
+				return
+			}
+		}
+	}()
+	txCh := make(chan state.NewTxsEvent, txEventBuffer)
+	go ts.forwardTxs(txCh, ts.state.SubscribeNewTxsEvent(txCh))
+
+	server := httptest.NewServer(http.HandlerFunc(ts.wsHandler))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return &wsClient{Conn: conn, ts: ts, server: server}
+}
+
+func (c *wsClient) close() {
+	c.Close()
+	c.server.Close()
+}
+
+// call sends a request and returns its response. Notifications received in
+// the meantime are returned too.
+func (c *wsClient) call(t *testing.T, method string, params ...interface{}) (rpcResponse, []json.RawMessage) {
+	c.nextID++
+	if params == nil {
+		params = []interface{}{}
+	}
+	err := c.WriteJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      c.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notifications []json.RawMessage
+	for {
+		msg := c.read(t)
+		var res rpcResponse
+		if err := json.Unmarshal(msg, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ID == nil {
+			notifications = append(notifications, msg)
+			continue
+		}
+		if string(res.ID) != strconv.Itoa(c.nextID) {
+			t.Fatalf("Response to request %d, got %s", c.nextID, res.ID)
+		}
+		return res, notifications
+	}
+}
+
+// subscribe creates a subscription and waits for the hub to activate it
+func (c *wsClient) subscribe(t *testing.T, params ...interface{}) string {
+	res, _ := c.call(t, "eth_subscribe", params...)
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	var id string
+	if err := json.Unmarshal(res.Result, &id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscriptions are activated after the response is queued
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.ts.subs.Lock()
+		_, ok := c.ts.subs.subs[id]
+		c.ts.subs.Unlock()
+		if ok {
+			return id
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Subscription %s not activated", id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *wsClient) read(t *testing.T) []byte {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+type wsNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func TestWSSubscriptions(t *testing.T) {
+	ts := newTestService(t)
+	defer ts.close()
+
+	c := ts.dialWS(t)
+	defer c.close()
+
+	// The methods of /rpc are served too
+	if res, _ := c.call(t, "eth_blockNumber"); res.Error != nil || string(res.Result) != `"0x0"` {
+		t.Fatalf("eth_blockNumber should return 0x0, not %+v", res)
+	}
+	if res, _ := c.call(t, "eth_subscribe", "syncing"); res.Error == nil || res.Error.Code != rpcInvalidParams {
+		t.Fatalf("Unknown subscription should fail with invalid params, not %+v", res)
+	}
+
+	// The contract logs 0x2a when it is created
+	contract := crypto.CreateAddress(ts.from, 0)
+	topic := common.BigToHash(big.NewInt(42))
+	other := common.HexToHash("0xdead")
+
+	heads := c.subscribe(t, "newHeads")
+	logs := c.subscribe(t, "logs", map[string]interface{}{"address": contract, "topics": []interface{}{topic}})
+	c.subscribe(t, "logs", map[string]interface{}{"topics": []interface{}{other}})
+	pending := c.subscribe(t, "newPendingTransactions")
+
+	tx, err := ethTypes.SignTx(
+		ethTypes.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(0), common.FromHex("602a60006000a100")),
+		ts.state.GetSigner(),
+		ts.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, received := c.call(t, "eth_sendRawTransaction", hexutil.Bytes(mustEncode(t, tx)))
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	ts.commit(t, tx)
+
+	// Exactly one notification per subscription that matches
+	for len(received) < 3 {
+		received = append(received, c.read(t))
+	}
+	seen := make(map[string]bool)
+	for _, msg := range received {
+		var n wsNotification
+		if err := json.Unmarshal(msg, &n); err != nil {
+			t.Fatal(err)
+		}
+		if n.Method != "eth_subscription" || seen[n.Params.Subscription] {
+			t.Fatalf("Unexpected notification %s", msg)
+		}
+		seen[n.Params.Subscription] = true
+
+		switch n.Params.Subscription {
+		case heads:
+			var header ethTypes.Header
+			if err := json.Unmarshal(n.Params.Result, &header); err != nil {
+				t.Fatal(err)
+			}
+			if header.Number.Int64() != 1 || header.Hash() != ts.state.CurrentBlock().Hash() {
+				t.Fatalf("newHeads should notify block 1, not %s", n.Params.Result)
+			}
+		case logs:
+			var log ethTypes.Log
+			if err := json.Unmarshal(n.Params.Result, &log); err != nil {
+				t.Fatal(err)
+			}
+			if log.Address != contract || len(log.Topics) != 1 || log.Topics[0] != topic || log.TxHash != tx.Hash() {
+				t.Fatalf("logs should notify the log of the contract, not %s", n.Params.Result)
+			}
+		case pending:
+			var hash common.Hash
+			if err := json.Unmarshal(n.Params.Result, &hash); err != nil {
+				t.Fatal(err)
+			}
+			if hash != tx.Hash() {
+				t.Fatalf("newPendingTransactions should notify %s, not %s", tx.Hash().Hex(), hash.Hex())
+			}
+		default:
+			t.Fatalf("Notification of a subscription that does not match: %s", msg)
+		}
+	}
+
+	// Subscriptions can only be cancelled once
+	res, received = c.call(t, "eth_unsubscribe", heads)
+	if len(received) != 0 {
+		t.Fatalf("Unexpected notifications %s", received)
+	}
+	if string(res.Result) != "true" {
+		t.Fatalf("eth_unsubscribe should return true, not %+v", res)
+	}
+	if res, _ := c.call(t, "eth_unsubscribe", heads); string(res.Result) != "false" {
+		t.Fatalf("Second eth_unsubscribe should return false, not %+v", res)
+	}
+
+	// Subscriptions are cancelled when their connection closes
+	c.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		ts.subs.Lock()
+		n := len(ts.subs.subs)
+		ts.subs.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions left after the connection closed", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
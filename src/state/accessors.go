@@ -0,0 +1,111 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var (
+	headKey         = []byte("LastCommit")
+	indexRootPrefix = []byte("index-root-")
+	execErrorPrefix = []byte("exec-error-")
+)
+
+// Head describes the last state committed to the database. It is written on
+// every Commit so that the State can be reopened where it left off after a
+// restart.
+type Head struct {
+	// Root of the state trie
+	Root common.Hash `json:"root"`
+	// Hash of the last block
+	Hash common.Hash `json:"hash"`
+	// Number of the last block
+	Height uint64 `json:"height"`
+	// Total number of transactions committed
+	TxCount uint64 `json:"txCount"`
+	// Index of the last block applied by the consensus system (-1 if none)
+	Index int64 `json:"index"`
+}
+
+// readHead retrieves the last committed Head. It returns nil if the database
+// has never been committed to, and an error if it cannot be read.
+func readHead(db DatabaseReader) (*Head, error) {
+	data, err := db.Get(headKey)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var head Head
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// writeHead stores the last committed Head.
+func writeHead(db DatabasePutter, head *Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return db.Put(headKey, data)
+}
+
+// indexRootKey = indexRootPrefix + index (int64 big endian)
+func indexRootKey(index int64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, uint64(index))
+	return append(indexRootPrefix, enc...)
+}
+
+// readIndexRoot retrieves the state root that resulted from applying the block
+// with the given consensus index.
+func readIndexRoot(db DatabaseReader, index int64) (common.Hash, error) {
+	data, err := db.Get(indexRootKey(index))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(data), nil
+}
+
+// writeIndexRoot stores the state root that resulted from applying the block
+// with the given consensus index.
+func writeIndexRoot(db DatabasePutter, index int64, root common.Hash) error {
+	return db.Put(indexRootKey(index), root.Bytes())
+}
+
+// execErrorKey = execErrorPrefix + tx hash
+func execErrorKey(txHash common.Hash) []byte {
+	return append(execErrorPrefix, txHash.Bytes()...)
+}
+
+// readExecutionError retrieves the error of a failed transaction. It returns
+// nil if the transaction did not fail, and an error if it cannot be read.
+func readExecutionError(db DatabaseReader, txHash common.Hash) (*ExecutionError, error) {
+	data, err := db.Get(execErrorKey(txHash))
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var execErr ExecutionError
+	if err := json.Unmarshal(data, &execErr); err != nil {
+		return nil, err
+	}
+	return &execErr, nil
+}
+
+// writeExecutionError stores the error of a failed transaction.
+func writeExecutionError(db DatabasePutter, txHash common.Hash, execErr *ExecutionError) error {
+	data, err := json.Marshal(execErr)
+	if err != nil {
+		return err
+	}
+	return db.Put(execErrorKey(txHash), data)
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// newHeader prepares the header of the block that will be built on top of
+// parent. A nil parent yields the header of the genesis block, with the given
+// gas limit. Other blocks inherit the gas limit of their parent, so that all
+// the blocks have the gas limit of the genesis block. They also inherit its
+// timestamp until one is set explicitly.
+func newHeader(parent *ethTypes.Header, gasLimit uint64) *ethTypes.Header {
+	header := &ethTypes.Header{
+		Number:     big.NewInt(0),
+		Time:       big.NewInt(0),
+		Difficulty: big.NewInt(0),
+		GasLimit:   gasLimit,
+	}
+	if parent != nil {
+		header.ParentHash = parent.Hash()
+		header.Number.Add(parent.Number, big.NewInt(1))
+		header.Time.Set(parent.Time)
+		header.GasLimit = parent.GasLimit
+	}
+	return header
+}
+
+// newContext returns the EVM context for executing a message from origin in
+// the block described by header.
+func newContext(header *ethTypes.Header,
+	getHash vm.GetHashFunc,
+	origin common.Address,
+	gasPrice *big.Int) vm.Context {
+
+	return vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     getHash,
+		Origin:      origin,
+		Coinbase:    header.Coinbase,
+		GasLimit:    header.GasLimit,
+		GasPrice:    new(big.Int).Set(gasPrice),
+		BlockNumber: new(big.Int).Set(header.Number),
+		Time:        new(big.Int).Set(header.Time),
+		Difficulty:  new(big.Int).Set(header.Difficulty),
+	}
+}
+
+// getHashFn returns a GetHashFunc which retrieves the hashes of committed
+// blocks from the database by number.
+func getHashFn(db rawdb.DatabaseReader) vm.GetHashFunc {
+	return func(n uint64) common.Hash {
+		return rawdb.ReadCanonicalHash(db, n)
+	}
+}
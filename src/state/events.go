@@ -0,0 +1,32 @@
+package state
+
+import (
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// ChainEvent is posted after every Commit, with the new block and the logs
+// emitted by its transactions.
+type ChainEvent struct {
+	Block *ethTypes.Block
+	Logs  []*ethTypes.Log
+}
+
+//SubscribeChainEvent registers a subscription of ChainEvent. Commit blocks
+//until every subscriber has received the event, so subscribers must drain ch
+//promptly.
+func (s *State) SubscribeChainEvent(ch chan<- ChainEvent) event.Subscription {
+	return s.chainFeed.Subscribe(ch)
+}
+
+// NewTxsEvent is posted when transactions become pending in the TxPool, in the
+// order they must be submitted to the consensus system.
+type NewTxsEvent struct {
+	Txs []*ethTypes.Transaction
+}
+
+//SubscribeNewTxsEvent registers a subscription of NewTxsEvent. Events are
+//posted while the TxPool is locked, so subscribers must drain ch promptly.
+func (s *State) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription {
+	return s.txPool.SubscribeNewTxsEvent(ch)
+}
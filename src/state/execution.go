@@ -0,0 +1,150 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	// revertSelector is the selector of Error(string), which Solidity uses to
+	// encode the reason of require and revert statements.
+	revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+	// panicSelector is the selector of Panic(uint256), which Solidity uses to
+	// report failed assertions and runtime errors.
+	panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+
+	errNoRevertReason = errors.New("revert data does not contain a reason")
+)
+
+// errExecutionReverted is the message of the (unexported) error returned by
+// the EVM when execution is stopped by REVERT.
+const errExecutionReverted = "execution reverted"
+
+// panicReasons describes the Solidity panic codes
+var panicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// ExecutionError is returned when the execution of a message fails. It carries
+// the EVM error, the data returned by REVERT and the reason decoded from it, if
+// any. It is persisted for failed transactions.
+type ExecutionError struct {
+	Err    string        `json:"error"`
+	Reason string        `json:"reason,omitempty"`
+	Data   hexutil.Bytes `json:"returnData,omitempty"`
+}
+
+func newExecutionError(vmerr error, data []byte) *ExecutionError {
+	e := &ExecutionError{Err: "execution failed"}
+	if vmerr != nil {
+		e.Err = vmerr.Error()
+	}
+	//The EVM prefixes the revert error
+	if e.Err == "evm: "+errExecutionReverted {
+		e.Err = errExecutionReverted
+	}
+	if e.Reverted() {
+		e.Data = common.CopyBytes(data)
+		e.Reason, _ = UnpackRevert(data)
+	}
+	return e
+}
+
+func (e *ExecutionError) Error() string {
+	if e.Reason == "" {
+		return e.Err
+	}
+	return e.Err + ": " + e.Reason
+}
+
+// Reverted reports whether the execution was stopped by REVERT
+func (e *ExecutionError) Reverted() bool {
+	return e.Err == errExecutionReverted
+}
+
+//UnpackRevert decodes the reason of a revert from the data returned by REVERT.
+//It supports Error(string) reasons and Panic(uint256) codes, and fails on any
+//other data.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", errNoRevertReason
+	}
+
+	switch {
+	case bytes.Equal(data[:4], revertSelector):
+		typ, err := abi.NewType("string")
+		if err != nil {
+			return "", err
+		}
+		var reason string
+		if err := (abi.Arguments{{Type: typ}}).Unpack(&reason, data[4:]); err != nil {
+			return "", err
+		}
+		return reason, nil
+	case bytes.Equal(data[:4], panicSelector):
+		if len(data) != 4+32 {
+			return "", errNoRevertReason
+		}
+		code := new(big.Int).SetBytes(data[4:])
+		reason, ok := panicReasons[code.Uint64()]
+		if !code.IsUint64() || !ok {
+			reason = "unknown panic code"
+		}
+		return fmt.Sprintf("panic: %s (0x%x)", reason, code), nil
+	}
+
+	return "", errNoRevertReason
+}
+
+//------------------------------------------------------------------------------
+
+// errorTracer records the outcome of the top-level call of an execution. It is
+// the only way to know why an execution failed, because core.ApplyMessage only
+// reports that it did.
+type errorTracer struct {
+	output []byte
+	err    error
+}
+
+// tracedConfig returns a copy of cfg which reports to tracer
+func tracedConfig(cfg vm.Config, tracer vm.Tracer) vm.Config {
+	cfg.Debug = true
+	cfg.Tracer = tracer
+	return cfg
+}
+
+func (t *errorTracer) CaptureStart(from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *errorTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *errorTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *errorTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.output = output
+	t.err = err
+	return nil
+}
@@ -0,0 +1,399 @@
+package state
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	ethState "github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+//Genesis is the content of a genesis file, in the format of geth's. Numbers are
+//JSON numbers, or strings in decimal or 0x-prefixed hex. Hex data may omit the
+//0x prefix. All the fields are optional. The fields of the genesis block that
+//are not set are zero, except for the gas limit, which defaults to the gas
+//limit of blocks.
+type Genesis struct {
+	Config     *GenesisConfig `json:"config,omitempty"`
+	Nonce      genesisNumber  `json:"nonce,omitempty"`
+	Timestamp  genesisNumber  `json:"timestamp,omitempty"`
+	ExtraData  string         `json:"extraData,omitempty"`
+	GasLimit   genesisNumber  `json:"gasLimit,omitempty"`
+	Difficulty genesisNumber  `json:"difficulty,omitempty"`
+	Mixhash    string         `json:"mixHash,omitempty"`
+	Coinbase   string         `json:"coinbase,omitempty"`
+	Alloc      GenesisAlloc   `json:"alloc"`
+
+	//These fields are only accepted if they are zero, as in geth's genesis
+	//files
+	Number     genesisNumber `json:"number,omitempty"`
+	GasUsed    genesisNumber `json:"gasUsed,omitempty"`
+	ParentHash string        `json:"parentHash,omitempty"`
+}
+
+//GenesisAlloc holds the genesis accounts, by address
+type GenesisAlloc map[string]GenesisAccount
+
+//GenesisAccount is an account of the genesis allocation, with its balance,
+//nonce, code and storage
+type GenesisAccount struct {
+	Balance genesisNumber     `json:"balance"`
+	Nonce   genesisNumber     `json:"nonce,omitempty"`
+	Code    string            `json:"code,omitempty"`
+	Storage map[string]string `json:"storage,omitempty"`
+}
+
+//GenesisConfig is the chain configuration of a genesis file, in the format of
+//geth's. It sets the chain ID and the blocks from which the rules of every hard
+//fork apply to the EVM. Forks that are not set never apply, so the EVM of a
+//genesis without config follows the Frontier rules.
+type GenesisConfig struct {
+	params.ChainConfig
+
+	//Forks that the EVM of this version does not implement
+	PetersburgBlock *big.Int `json:"petersburgBlock,omitempty"`
+	IstanbulBlock   *big.Int `json:"istanbulBlock,omitempty"`
+}
+
+//ReadGenesis reads and validates a genesis file. A missing file is an empty
+//genesis.
+func ReadGenesis(file string) (*Genesis, error) {
+	contents, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &Genesis{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	genesis, err := ParseGenesis(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Genesis file %s: %v", file, err)
+	}
+
+	return genesis, nil
+}
+
+//ParseGenesis decodes and validates the JSON content of a genesis file
+func ParseGenesis(data []byte) (*Genesis, error) {
+	genesis := &Genesis{}
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, err
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+//Validate checks that the genesis block can be built from the genesis, and that
+//its config only schedules supported forks, in order
+func (g *Genesis) Validate() error {
+	if g.Config != nil {
+		if err := g.Config.validate(); err != nil {
+			return err
+		}
+	}
+	_, err := g.ToBlock()
+	return err
+}
+
+//ToBlock returns the genesis block, without writing it anywhere. Its hash
+//identifies the network: nodes whose genesis differ have different genesis
+//blocks.
+func (g *Genesis) ToBlock() (*ethTypes.Block, error) {
+	header, err := g.header()
+	if err != nil {
+		return nil, err
+	}
+
+	statedb, err := ethState.New(common.Hash{}, ethState.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Alloc.apply(statedb); err != nil {
+		return nil, err
+	}
+	//Empty accounts are dropped, as when the State commits the genesis block.
+	//geth keeps them, so their genesis block differs if the alloc has some.
+	header.Root = statedb.IntermediateRoot(true)
+
+	return ethTypes.NewBlock(header, nil, nil, nil), nil
+}
+
+//header returns the header of the genesis block, without state root
+func (g *Genesis) header() (*ethTypes.Header, error) {
+	if n, err := g.Number.uint64("number"); err != nil || n != 0 {
+		return nil, fmt.Errorf("Invalid number %q: the genesis block is block 0", g.Number)
+	}
+	if n, err := g.GasUsed.uint64("gasUsed"); err != nil || n != 0 {
+		return nil, fmt.Errorf("Invalid gasUsed %q: the genesis block has no transactions", g.GasUsed)
+	}
+	parentHash, err := parseHash("parentHash", g.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	if parentHash != (common.Hash{}) {
+		return nil, fmt.Errorf("Invalid parentHash %q: the genesis block has no parent", g.ParentHash)
+	}
+
+	header := newHeader(nil, gasLimit)
+
+	nonce, err := g.Nonce.uint64("nonce")
+	if err != nil {
+		return nil, err
+	}
+	header.Nonce = ethTypes.EncodeNonce(nonce)
+
+	timestamp, err := g.Timestamp.uint64("timestamp")
+	if err != nil {
+		return nil, err
+	}
+	header.Time.SetUint64(timestamp)
+
+	if header.Extra, err = parseHex("extraData", g.ExtraData); err != nil {
+		return nil, err
+	}
+
+	if g.GasLimit != "" {
+		if header.GasLimit, err = g.GasLimit.uint64("gasLimit"); err != nil {
+			return nil, err
+		}
+		if header.GasLimit < params.MinGasLimit {
+			return nil, fmt.Errorf("Invalid gasLimit %d: minimum is %d", header.GasLimit, params.MinGasLimit)
+		}
+	}
+
+	if g.Difficulty != "" {
+		if header.Difficulty, err = g.Difficulty.big("difficulty"); err != nil {
+			return nil, err
+		}
+	}
+
+	if header.MixDigest, err = parseHash("mixHash", g.Mixhash); err != nil {
+		return nil, err
+	}
+
+	if g.Coinbase != "" {
+		if !common.IsHexAddress(g.Coinbase) {
+			return nil, fmt.Errorf("Invalid coinbase %q", g.Coinbase)
+		}
+		header.Coinbase = common.HexToAddress(g.Coinbase)
+	}
+
+	return header, nil
+}
+
+//apply creates the accounts in statedb. Addresses are applied in order, so
+//that errors are deterministic.
+func (alloc GenesisAlloc) apply(statedb *ethState.StateDB) error {
+	addrs := make([]string, 0, len(alloc))
+	for addr := range alloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	seen := make(map[common.Address]string)
+	for _, addr := range addrs {
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("Invalid address %q in alloc", addr)
+		}
+		address := common.HexToAddress(addr)
+		if other, ok := seen[address]; ok {
+			return fmt.Errorf("Duplicate account in alloc: %q and %q", other, addr)
+		}
+		seen[address] = addr
+
+		if err := alloc[addr].apply(statedb, address); err != nil {
+			return fmt.Errorf("Account %s in alloc: %v", addr, err)
+		}
+	}
+
+	return nil
+}
+
+func (a GenesisAccount) apply(statedb *ethState.StateDB, address common.Address) error {
+	balance := new(big.Int)
+	if a.Balance != "" {
+		var err error
+		if balance, err = a.Balance.big("balance"); err != nil {
+			return err
+		}
+	}
+	nonce, err := a.Nonce.uint64("nonce")
+	if err != nil {
+		return err
+	}
+	code, err := parseHex("code", a.Code)
+	if err != nil {
+		return err
+	}
+
+	statedb.AddBalance(address, balance)
+	statedb.SetNonce(address, nonce)
+	statedb.SetCode(address, code)
+	for key, value := range a.Storage {
+		k, err := parseHash("storage key", key)
+		if err != nil {
+			return err
+		}
+		v, err := parseHash("storage value", value)
+		if err != nil {
+			return err
+		}
+		statedb.SetState(address, k, v)
+	}
+
+	return nil
+}
+
+//validate rejects the forks that cannot be applied, and forks that are
+//scheduled before the ones they build upon
+func (c *GenesisConfig) validate() error {
+	unsupported := []struct {
+		name  string
+		block *big.Int
+	}{
+		{"daoForkBlock", c.DAOForkBlock},
+		{"ewasmBlock", c.EWASMBlock},
+		{"petersburgBlock", c.PetersburgBlock},
+		{"istanbulBlock", c.IstanbulBlock},
+	}
+	for _, fork := range unsupported {
+		if fork.block != nil {
+			return fmt.Errorf("Unsupported fork in genesis config: %s", fork.name)
+		}
+	}
+
+	forks := []struct {
+		name  string
+		block *big.Int
+	}{
+		{"homesteadBlock", c.HomesteadBlock},
+		{"eip150Block", c.EIP150Block},
+		{"eip155Block", c.EIP155Block},
+		{"eip158Block", c.EIP158Block},
+		{"byzantiumBlock", c.ByzantiumBlock},
+		{"constantinopleBlock", c.ConstantinopleBlock},
+	}
+	for i := 1; i < len(forks); i++ {
+		prev, cur := forks[i-1], forks[i]
+		if cur.block == nil {
+			continue
+		}
+		if prev.block == nil || prev.block.Cmp(cur.block) > 0 {
+			return fmt.Errorf("Genesis config enables %s at block %v, before %s (%v)",
+				cur.name, cur.block, prev.name, prev.block)
+		}
+	}
+
+	return nil
+}
+
+//chainConfig returns the chain configuration of the State, given the
+//configured chain ID, which can be nil
+func (g *Genesis) chainConfig(chainID *big.Int) (*params.ChainConfig, error) {
+	config := params.ChainConfig{}
+	if g.Config != nil {
+		config = g.Config.ChainConfig
+	}
+
+	genesisID := config.ChainID
+	switch {
+	case chainID != nil && genesisID != nil && chainID.Cmp(genesisID) != 0:
+		return nil, fmt.Errorf("Chain ID %v disagrees with chain ID %v of the genesis file", chainID, genesisID)
+	case chainID != nil:
+		config.ChainID = new(big.Int).Set(chainID)
+	case genesisID != nil:
+		config.ChainID = new(big.Int).Set(genesisID)
+	default:
+		config.ChainID = new(big.Int).Set(DefaultChainID)
+	}
+
+	return &config, nil
+}
+
+//------------------------------------------------------------------------------
+
+//genesisNumber is a number of a genesis file: a JSON number, or a string in
+//decimal or 0x-prefixed hex
+type genesisNumber string
+
+func (n *genesisNumber) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*n = genesisNumber(s)
+		return nil
+	}
+	if string(data) == "null" {
+		*n = ""
+		return nil
+	}
+	*n = genesisNumber(data)
+	return nil
+}
+
+func (n genesisNumber) uint64(field string) (uint64, error) {
+	if n == "" {
+		return 0, nil
+	}
+	v, ok := math.ParseUint64(string(n))
+	if !ok {
+		return 0, fmt.Errorf("Invalid %s %q", field, n)
+	}
+	return v, nil
+}
+
+func (n genesisNumber) big(field string) (*big.Int, error) {
+	v, ok := math.ParseBig256(string(n))
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("Invalid %s %q", field, n)
+	}
+	return v, nil
+}
+
+//parseHex decodes hex data, with or without 0x prefix
+func parseHex(field, s string) ([]byte, error) {
+	h := s
+	if strings.HasPrefix(h, "0x") || strings.HasPrefix(h, "0X") {
+		h = h[2:]
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s %q: %v", field, s, err)
+	}
+	return b, nil
+}
+
+//parseHash decodes a hash of up to 32 bytes, which is left-padded with zeros.
+//Like a number, it can have an odd number of digits.
+func parseHash(field, s string) (common.Hash, error) {
+	h := s
+	if strings.HasPrefix(h, "0x") || strings.HasPrefix(h, "0X") {
+		h = h[2:]
+	}
+	if len(h)%2 == 1 {
+		h = "0" + h
+	}
+	b, err := parseHex(field, h)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if len(b) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("Invalid %s %q: longer than %d bytes", field, s, common.HashLength)
+	}
+	return common.BytesToHash(b), nil
+}
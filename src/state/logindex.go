@@ -0,0 +1,239 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+var mipmapPrefix = []byte("mipmap-log-bloom-")
+
+// The log index is made of a bloom per block, stored in the block header, and
+// of multi-level bloom bins: for every level of MIPMapLevels, each bin covers
+// that many consecutive blocks and holds the union of their blooms. Queries
+// descend from the coarsest bins to the individual blocks, skipping every
+// range whose bloom cannot contain matching logs.
+
+// mipmapKey = mipmapPrefix + level (uint64 big endian) + first block of the
+// bin (uint64 big endian)
+func mipmapKey(level, number uint64) []byte {
+	enc := make([]byte, 16)
+	binary.BigEndian.PutUint64(enc, level)
+	binary.BigEndian.PutUint64(enc[8:], number-number%level)
+	return append(mipmapPrefix, enc...)
+}
+
+// readMipmapBloom retrieves the bloom of the bin of the given level that
+// contains block number.
+func readMipmapBloom(db DatabaseReader, level, number uint64) ethTypes.Bloom {
+	data, _ := db.Get(mipmapKey(level, number))
+	return ethTypes.BytesToBloom(data)
+}
+
+// writeMipmapBloom adds the logs of a block's receipts to the bins that
+// contain it. Existing bins are read from db and the updates written to batch.
+func writeMipmapBloom(db DatabaseReader,
+	batch DatabasePutter,
+	number uint64,
+	receipts ethTypes.Receipts) error {
+
+	bloom := ethTypes.CreateBloom(receipts)
+	if bloom == (ethTypes.Bloom{}) {
+		return nil
+	}
+
+	for _, level := range MIPMapLevels {
+		bin := readMipmapBloom(db, level, number)
+		merged := ethTypes.BytesToBloom(new(big.Int).Or(bin.Big(), bloom.Big()).Bytes())
+		if err := batch.Put(mipmapKey(level, number), merged.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+//GetLogs returns the logs of blocks from to to (inclusive) that were emitted by
+//one of addresses and match topics. See FilterLogs for the matching rules.
+func (s *State) GetLogs(from, to uint64,
+	addresses []common.Address,
+	topics [][]common.Hash) ([]*ethTypes.Log, error) {
+
+	if head := s.block.NumberU64(); to > head {
+		to = head
+	}
+
+	logs := []*ethTypes.Log{}
+	if from > to {
+		return logs, nil
+	}
+
+	return s.findLogs(logs, from, to, 0, addresses, topics)
+}
+
+//GetBlockLogs returns the logs of a single block that were emitted by one of
+//addresses and match topics.
+func (s *State) GetBlockLogs(hash common.Hash,
+	addresses []common.Address,
+	topics [][]common.Hash) ([]*ethTypes.Log, error) {
+
+	number := rawdb.ReadHeaderNumber(s.db, hash)
+	if number == nil {
+		return nil, fmt.Errorf("Block %s not found", hash.Hex())
+	}
+
+	return s.blockLogs([]*ethTypes.Log{}, hash, *number, addresses, topics), nil
+}
+
+// findLogs appends the matching logs of blocks from to to, using the bins of
+// MIPMapLevels[depth] and the following levels.
+func (s *State) findLogs(logs []*ethTypes.Log,
+	from, to uint64,
+	depth int,
+	addresses []common.Address,
+	topics [][]common.Hash) ([]*ethTypes.Log, error) {
+
+	level := MIPMapLevels[depth]
+
+	for bin := from - from%level; bin <= to; bin += level {
+		if !bloomFilter(readMipmapBloom(s.db, level, bin), addresses, topics) {
+			continue
+		}
+
+		start, end := bin, bin+level-1
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+
+		if depth+1 < len(MIPMapLevels) {
+			var err error
+			if logs, err = s.findLogs(logs, start, end, depth+1, addresses, topics); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for number := start; number <= end; number++ {
+			hash := rawdb.ReadCanonicalHash(s.db, number)
+			header := rawdb.ReadHeader(s.db, hash, number)
+			if header == nil {
+				return nil, fmt.Errorf("Block %d not found", number)
+			}
+			if bloomFilter(header.Bloom, addresses, topics) {
+				logs = s.blockLogs(logs, hash, number, addresses, topics)
+			}
+		}
+	}
+
+	return logs, nil
+}
+
+// blockLogs appends the matching logs of a block
+func (s *State) blockLogs(logs []*ethTypes.Log,
+	hash common.Hash,
+	number uint64,
+	addresses []common.Address,
+	topics [][]common.Hash) []*ethTypes.Log {
+
+	for _, receipt := range rawdb.ReadReceipts(s.db, hash, number) {
+		logs = append(logs, FilterLogs(receipt.Logs, addresses, topics)...)
+	}
+	return logs
+}
+
+//FilterLogs returns the logs that were emitted by one of addresses, or by any
+//contract if addresses is empty, and that match topics. Topics are positional:
+//an empty position matches any topic, and a position with several topics
+//matches any of them.
+func FilterLogs(logs []*ethTypes.Log,
+	addresses []common.Address,
+	topics [][]common.Hash) []*ethTypes.Log {
+
+	var res []*ethTypes.Log
+Logs:
+	for _, log := range logs {
+		if len(addresses) > 0 && !includesAddress(addresses, log.Address) {
+			continue
+		}
+		if len(topics) > len(log.Topics) {
+			continue
+		}
+		for i, sub := range topics {
+			if len(sub) > 0 && !includesTopic(sub, log.Topics[i]) {
+				continue Logs
+			}
+		}
+		res = append(res, log)
+	}
+	return res
+}
+
+// bloomFilter reports whether a bloom may contain logs matching addresses and
+// topics.
+func bloomFilter(bloom ethTypes.Bloom,
+	addresses []common.Address,
+	topics [][]common.Hash) bool {
+
+	//Nothing to find where no logs were emitted
+	if bloom == (ethTypes.Bloom{}) {
+		return false
+	}
+
+	if len(addresses) > 0 {
+		included := false
+		for _, addr := range addresses {
+			if ethTypes.BloomLookup(bloom, addr) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, sub := range topics {
+		if len(sub) == 0 {
+			continue
+		}
+		included := false
+		for _, topic := range sub {
+			if ethTypes.BloomLookup(bloom, topic) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	return true
+}
+
+func includesAddress(addresses []common.Address, a common.Address) bool {
+	for _, addr := range addresses {
+		if addr == a {
+			return true
+		}
+	}
+	return false
+}
+
+func includesTopic(topics []common.Hash, t common.Hash) bool {
+	for _, topic := range topics {
+		if topic == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package state
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	ethState "github.com/ethereum/go-ethereum/core/state"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+//AccountProof is an account of the state trie and some of its storage slots,
+//with their Merkle proofs, in the format of eth_getProof (EIP-1186). A proof
+//is the list of RLP-encoded trie nodes on the path from the root to the value.
+//Accounts and slots that do not exist are proven absent, and returned empty.
+type AccountProof struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageProof  `json:"storageProof"`
+}
+
+//StorageProof is a storage slot with its Merkle proof in the storage trie of
+//its account
+type StorageProof struct {
+	Key   common.Hash     `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+//ReceiptProof is the receipt of a committed transaction with its Merkle proof
+//in the receipt trie of its block, whose root is ReceiptsRoot. The key of the
+//receipt in the trie is the RLP encoding of its index.
+type ReceiptProof struct {
+	BlockHash        common.Hash       `json:"blockHash"`
+	BlockNumber      hexutil.Uint64    `json:"blockNumber"`
+	ReceiptsRoot     common.Hash       `json:"receiptsRoot"`
+	TransactionIndex hexutil.Uint64    `json:"transactionIndex"`
+	Receipt          *ethTypes.Receipt `json:"receipt"`
+	Proof            []hexutil.Bytes   `json:"proof"`
+}
+
+//proofList collects the nodes of a Merkle proof, in the order in which the
+//trie writes them: from the root down
+type proofList []hexutil.Bytes
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+//GetProof returns an account and some of its storage slots, with their Merkle
+//proofs, in the state committed with the given consensus index.
+func (s *State) GetProof(index int64, addr common.Address, keys []common.Hash) (*AccountProof, error) {
+	root, err := s.GetIndexRoot(index)
+	if err != nil {
+		return nil, fmt.Errorf("No state committed with index %d", index)
+	}
+
+	db := s.ethState.Database()
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &AccountProof{
+		Address:      addr,
+		Balance:      (*hexutil.Big)(new(big.Int)),
+		CodeHash:     crypto.Keccak256Hash(nil),
+		StorageHash:  ethTypes.EmptyRootHash,
+		StorageProof: make([]StorageProof, len(keys)),
+	}
+
+	//The state and storage tries are secure tries, keyed by the hash of the
+	//address or slot. They hash the keys of lookups, but not of proofs.
+	addrHash := crypto.Keccak256Hash(addr.Bytes())
+	accountProof := proofList{}
+	if err := tr.Prove(addrHash.Bytes(), 0, &accountProof); err != nil {
+		return nil, err
+	}
+	res.AccountProof = accountProof
+
+	enc, err := tr.TryGet(addr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(enc) > 0 {
+		var account ethState.Account
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			return nil, err
+		}
+		res.Balance = (*hexutil.Big)(account.Balance)
+		res.CodeHash = common.BytesToHash(account.CodeHash)
+		res.Nonce = hexutil.Uint64(account.Nonce)
+		res.StorageHash = account.Root
+	}
+
+	storage, err := db.OpenStorageTrie(addrHash, res.StorageHash)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		proof := proofList{}
+		if err := storage.Prove(crypto.Keccak256(key.Bytes()), 0, &proof); err != nil {
+			return nil, err
+		}
+
+		//Slots are stored RLP-encoded, without leading zeros
+		value := new(big.Int)
+		enc, err := storage.TryGet(key.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if len(enc) > 0 {
+			_, content, _, err := rlp.Split(enc)
+			if err != nil {
+				return nil, err
+			}
+			value.SetBytes(content)
+		}
+
+		res.StorageProof[i] = StorageProof{
+			Key:   key,
+			Value: (*hexutil.Big)(value),
+			Proof: proof,
+		}
+	}
+
+	return res, nil
+}
+
+//GetReceiptProof returns the receipt of a committed transaction with its
+//Merkle proof. The receipt trie of the block is rebuilt from its receipts.
+func (s *State) GetReceiptProof(txHash common.Hash) (*ReceiptProof, error) {
+	blockHash, number, index, err := s.GetTransactionLocation(txHash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.GetBlockByHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := rawdb.ReadReceipts(s.db, blockHash, number)
+	if uint64(len(receipts)) <= index {
+		return nil, fmt.Errorf("Receipt %s not found", txHash.Hex())
+	}
+
+	tr, err := trie.New(common.Hash{}, trie.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		return nil, err
+	}
+	for i, receipt := range receipts {
+		key, err := rlp.EncodeToBytes(uint(i))
+		if err != nil {
+			return nil, err
+		}
+		value, err := rlp.EncodeToBytes(receipt)
+		if err != nil {
+			return nil, err
+		}
+		tr.Update(key, value)
+	}
+	if root := tr.Hash(); root != block.ReceiptHash() {
+		return nil, fmt.Errorf("Receipts of block %d do not match its receipt root", number)
+	}
+
+	key, err := rlp.EncodeToBytes(uint(index))
+	if err != nil {
+		return nil, err
+	}
+	var proof proofList
+	if err := tr.Prove(key, 0, &proof); err != nil {
+		return nil, err
+	}
+
+	return &ReceiptProof{
+		BlockHash:        blockHash,
+		BlockNumber:      hexutil.Uint64(number),
+		ReceiptsRoot:     block.ReceiptHash(),
+		TransactionIndex: hexutil.Uint64(index),
+		Receipt:          receipts[index],
+		Proof:            proof,
+	}, nil
+}
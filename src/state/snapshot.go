@@ -0,0 +1,248 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	ethState "github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//snapshotVersion is the version of the snapshot encoding. It is bumped
+//whenever the encoding changes so that old snapshots are refused instead of
+//being misread.
+const snapshotVersion = 1
+
+//A snapshot is encoded as an RLP stream: a snapshotHeader, followed by
+//snapshotEntries until the end of the stream. The entries are the database
+//records that are not part of a trie (blocks, transactions, receipts, lookup
+//entries, index roots, execution errors...), followed by the trie nodes and
+//contract code reachable from the head root. The nodes of older roots are left
+//out, so a restored State only holds the accounts of the head block.
+type snapshotHeader struct {
+	Version uint64
+	Root    common.Hash
+	Hash    common.Hash
+	Height  uint64
+	TxCount uint64
+	Index   uint64
+}
+
+type snapshotEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+//Snapshot is a point-in-time view of the committed State. It can be encoded
+//while the State keeps committing blocks, and must be released afterwards.
+type Snapshot struct {
+	head   Head
+	snap   *leveldb.Snapshot
+	db     ethdb.Database
+	logger *logrus.Logger
+}
+
+//Snapshot captures the last committed State. It must not be called
+//concurrently with Commit.
+func (s *State) Snapshot() (*Snapshot, error) {
+	if s.head.Index < 0 {
+		return nil, fmt.Errorf("No block committed by consensus to snapshot")
+	}
+
+	ldb, ok := s.db.(*ethdb.LDBDatabase)
+	if !ok {
+		return nil, fmt.Errorf("Snapshots require a LevelDB database")
+	}
+	snap, err := ldb.LDB().GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		head:   s.head,
+		snap:   snap,
+		db:     s.db,
+		logger: s.logger,
+	}, nil
+}
+
+//Head returns the head of the State captured by the snapshot
+func (sn *Snapshot) Head() Head {
+	return sn.head
+}
+
+//Encode writes the snapshot to w
+func (sn *Snapshot) Encode(w io.Writer) error {
+	header := snapshotHeader{
+		Version: snapshotVersion,
+		Root:    sn.head.Root,
+		Hash:    sn.head.Hash,
+		Height:  sn.head.Height,
+		TxCount: sn.head.TxCount,
+		Index:   uint64(sn.head.Index),
+	}
+	if err := rlp.Encode(w, &header); err != nil {
+		return err
+	}
+
+	//Records that are not trie nodes or code. Those are keyed by their hash
+	//and come from the trie iteration below. The head is written from the
+	//header on restore.
+	records := 0
+	it := sn.snap.NewIterator(nil, nil)
+	for it.Next() {
+		key := it.Key()
+		if len(key) == common.HashLength || bytes.Equal(key, headKey) {
+			continue
+		}
+		if err := rlp.Encode(w, &snapshotEntry{Key: key, Value: it.Value()}); err != nil {
+			it.Release()
+			return err
+		}
+		records++
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	//Trie nodes and code of the head root. The nodes of a committed root are
+	//never modified, so the trie can be walked on the live database.
+	statedb, err := ethState.New(sn.head.Root, ethState.NewDatabase(sn.db))
+	if err != nil {
+		return err
+	}
+	seen := make(map[common.Hash]struct{})
+	nodes := ethState.NewNodeIterator(statedb)
+	for nodes.Next() {
+		//Nodes embedded in their parent have no hash
+		if nodes.Hash == (common.Hash{}) {
+			continue
+		}
+		if _, ok := seen[nodes.Hash]; ok {
+			continue
+		}
+		seen[nodes.Hash] = struct{}{}
+
+		value, err := sn.snap.Get(nodes.Hash.Bytes(), nil)
+		if err != nil {
+			return fmt.Errorf("Trie node %s: %v", nodes.Hash.Hex(), err)
+		}
+		if err := rlp.Encode(w, &snapshotEntry{Key: nodes.Hash.Bytes(), Value: value}); err != nil {
+			return err
+		}
+	}
+	if nodes.Error != nil {
+		return nodes.Error
+	}
+
+	sn.logger.WithFields(logrus.Fields{
+		"index":   sn.head.Index,
+		"root":    sn.head.Root.Hex(),
+		"records": records,
+		"nodes":   len(seen),
+	}).Debug("Encoded snapshot")
+
+	return nil
+}
+
+//Release frees the database view held by the snapshot
+func (sn *Snapshot) Release() {
+	sn.snap.Release()
+}
+
+//Restore replaces the committed State with the one encoded in a snapshot, and
+//resets the WAS and TxPool on top of it. A State that has already committed
+//the snapshot's index is left untouched: this is the case when a node restarts
+//from its own snapshot. It must not be called concurrently with Commit.
+func (s *State) Restore(r io.Reader) error {
+	stream := rlp.NewStream(r, 0)
+
+	var header snapshotHeader
+	if err := stream.Decode(&header); err != nil {
+		return fmt.Errorf("Decoding snapshot header: %v", err)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %d", header.Version)
+	}
+
+	head := Head{
+		Root:    header.Root,
+		Hash:    header.Hash,
+		Height:  header.Height,
+		TxCount: header.TxCount,
+		Index:   int64(header.Index),
+	}
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"index":  head.Index,
+		"height": head.Height,
+		"root":   head.Root.Hex(),
+	})
+
+	if s.head.Index >= head.Index {
+		root, err := readIndexRoot(s.db, head.Index)
+		if err != nil || root != head.Root {
+			return fmt.Errorf("State committed index %d with a different root than the snapshot", head.Index)
+		}
+		logger.Debug("State already includes snapshot")
+		return nil
+	}
+
+	batch := s.db.NewBatch()
+	for {
+		var entry snapshotEntry
+		err := stream.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Decoding snapshot entry: %v", err)
+		}
+		if err := batch.Put(entry.Key, entry.Value); err != nil {
+			return err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	//The head is written last, so that a node which stops during a restore
+	//resumes from its previous head.
+	block := rawdb.ReadBlock(s.db, head.Hash, head.Height)
+	if block == nil {
+		return fmt.Errorf("Snapshot head block %d (%s) not found", head.Height, head.Hash.Hex())
+	}
+	if err := writeHead(s.db, &head); err != nil {
+		return err
+	}
+	s.head = head
+	s.block = block
+
+	if err := s.ethState.Reset(head.Root); err != nil {
+		return err
+	}
+	if err := s.was.Reset(block.Header()); err != nil {
+		return err
+	}
+	if err := s.txPool.Reset(block.Header()); err != nil {
+		return err
+	}
+
+	logger.Info("Restored snapshot")
+
+	return nil
+}
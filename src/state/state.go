@@ -1,33 +1,33 @@
 package state
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"math/big"
-	"os"
 	"syscall"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	ethState "github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/sirupsen/logrus"
-
-	bcommon "github.com/bear987978897/evm-lite/src/common"
 )
 
 var (
-	chainID        = big.NewInt(1)
-	gasLimit       = uint64(1000000000000000000)
-	txMetaSuffix   = []byte{0x01}
-	receiptsPrefix = []byte("receipts-")
-	MIPMapLevels   = []uint64{1000000, 500000, 100000, 50000, 1000}
+	//DefaultChainID is the chain ID of States whose chain ID is neither
+	//configured nor set in the genesis file
+	DefaultChainID = big.NewInt(1)
+
+	//gas limit of the blocks of genesis files that set none
+	gasLimit     = uint64(1000000000000000000)
+	gasCap       = uint64(50000000) //upper bound of gas estimations
+	txMetaSuffix = []byte{0x01}
+	MIPMapLevels = []uint64{1000000, 500000, 100000, 50000, 1000}
 )
 
 type State struct {
@@ -35,17 +35,44 @@ type State struct {
 	ethState *ethState.StateDB
 	was      *WriteAheadState
 	txPool   *TxPool
+	head     Head
+	block    *ethTypes.Block
+
+	//blockGasLimit is the gas limit of every block, which they inherit from
+	//the genesis block
+	blockGasLimit uint64
+
+	chainFeed event.Feed
 
 	signer      ethTypes.Signer
 	chainConfig params.ChainConfig //vm.env is still tightly coupled with chainConfig
 	vmConfig    vm.Config
 
-	genesisFile string
+	genesis    *Genesis
+	poolConfig TxPoolConfig
 
 	logger *logrus.Logger
 }
 
-func NewState(logger *logrus.Logger, dbFile string, dbCache int, genesisFile string) (*State, error) {
+//NewState opens the database and initializes the State, with the chain
+//configuration of the genesis file. chainID is the chain ID used to sign and
+//verify transactions. If it is nil, the chain ID is the one of the genesis
+//file, or DefaultChainID. It is an error for both to be set and to differ.
+func NewState(logger *logrus.Logger,
+	dbFile string,
+	dbCache int,
+	genesisFile string,
+	chainID *big.Int,
+	poolConfig TxPoolConfig) (*State, error) {
+
+	genesis, err := ReadGenesis(genesisFile)
+	if err != nil {
+		return nil, err
+	}
+	chainConfig, err := genesis.chainConfig(chainID)
+	if err != nil {
+		return nil, err
+	}
 
 	handles, err := getFdLimit()
 	if err != nil {
@@ -59,14 +86,16 @@ func NewState(logger *logrus.Logger, dbFile string, dbCache int, genesisFile str
 
 	s := &State{
 		db:          db,
-		signer:      ethTypes.NewEIP155Signer(chainID),
-		chainConfig: params.ChainConfig{ChainID: chainID},
+		signer:      ethTypes.NewEIP155Signer(chainConfig.ChainID),
+		chainConfig: *chainConfig,
 		vmConfig:    vm.Config{Tracer: vm.NewStructLogger(nil)},
-		genesisFile: genesisFile,
+		genesis:     genesis,
+		poolConfig:  poolConfig,
 		logger:      logger,
 	}
 
 	if err := s.InitState(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -75,13 +104,40 @@ func NewState(logger *logrus.Logger, dbFile string, dbCache int, genesisFile str
 
 //------------------------------------------------------------------------------
 
-//InitState initializes the statedb object, the write-ahead state, the
-//transaction-pool, and creates genesis accounts.
+//InitState initializes the statedb object, the write-ahead state, and the
+//transaction-pool. If the database already contains a committed state, it is
+//reopened from the last committed root; otherwise genesis accounts are created.
 func (s *State) InitState() error {
 
 	initState := common.Hash{}
 
-	var err error
+	//The parent of the next block. nil until genesis is committed.
+	var parent *ethTypes.Header
+
+	head, err := readHead(s.db)
+	if err != nil {
+		return err
+	}
+	if head != nil {
+		s.logger.WithFields(logrus.Fields{
+			"root":     head.Root.Hex(),
+			"hash":     head.Hash.Hex(),
+			"height":   head.Height,
+			"tx_count": head.TxCount,
+			"index":    head.Index,
+		}).Debug("Resuming from last commit")
+
+		s.block = rawdb.ReadBlock(s.db, head.Hash, head.Height)
+		if s.block == nil {
+			return fmt.Errorf("Head block %d (%s) not found in database", head.Height, head.Hash.Hex())
+		}
+
+		initState = head.Root
+		parent = s.block.Header()
+		s.head = *head
+	} else {
+		s.head = Head{Index: -1}
+	}
 
 	s.ethState, err = ethState.New(initState, ethState.NewDatabase(s.db))
 	if err != nil {
@@ -89,7 +145,7 @@ func (s *State) InitState() error {
 	}
 
 	s.was, err = NewWriteAheadState(s.db,
-		initState,
+		parent,
 		s.signer,
 		s.chainConfig,
 		s.vmConfig,
@@ -101,143 +157,391 @@ func (s *State) InitState() error {
 	}
 
 	s.txPool = NewTxPool(s.ethState.Copy(),
+		parent,
+		getHashFn(s.db),
 		s.signer,
 		s.chainConfig,
 		s.vmConfig,
 		gasLimit,
+		s.poolConfig,
 		s.logger)
 
-	//Initialize genesis accounts with balance, code, and state
-	err = s.CreateGenesisAccounts()
-	if err != nil {
-		return err
+	if head == nil {
+		//Initialize genesis accounts with balance, code, and state
+		err = s.CreateGenesisAccounts()
+		if err != nil {
+			return err
+		}
+
+		//Commit the genesis block so that the next restart resumes from it
+		if _, err := s.Commit(-1); err != nil {
+			return err
+		}
 	}
 
-	return err
+	s.logger.WithField("hash", s.GetGenesisHash().Hex()).Info("Genesis block")
+	s.blockGasLimit = s.block.GasLimit()
+
+	return s.writeChainConfig()
 }
 
-//Commit persists all pending state changes (in the WAS) to the DB, and resets
-//the WAS and TxPool
-func (s *State) Commit() (common.Hash, error) {
+//writeChainConfig records the chain configuration with the genesis block. A
+//configuration that changes the rules of blocks that were already committed is
+//rejected, because the state of these blocks was computed with other rules.
+func (s *State) writeChainConfig() error {
+	genesisHash := rawdb.ReadCanonicalHash(s.db, 0)
+
+	if stored := rawdb.ReadChainConfig(s.db, genesisHash); stored != nil {
+		if err := stored.CheckCompatible(&s.chainConfig, s.head.Height); err != nil {
+			return fmt.Errorf("Chain configuration is incompatible with the committed blocks: %v", err)
+		}
+	}
+	rawdb.WriteChainConfig(s.db, genesisHash, &s.chainConfig)
+
+	return nil
+}
+
+//SetBlockTime sets the timestamp of the block being applied. Consensus
+//systems that agree on a time for their blocks should call it before applying
+//transactions; otherwise blocks inherit the timestamp of their parent.
+func (s *State) SetBlockTime(t time.Time) {
+	s.was.SetTime(uint64(t.Unix()))
+}
+
+//Commit persists all pending state changes (in the WAS) to the DB as a new
+//block, and resets the WAS and TxPool. index is the consensus system's index
+//of the block being committed; it is recorded with the resulting root so that
+//the consensus system can check where to resume from after a restart.
+func (s *State) Commit(index int64) (common.Hash, error) {
+	txCount := uint64(len(s.was.transactions))
+	logs := s.was.allLogs
+
 	//commit all state changes to the database
-	root, err := s.was.Commit()
+	block, err := s.was.Commit()
 	if err != nil {
 		s.logger.WithError(err).Error("Committing WAS")
+		return common.Hash{}, err
+	}
+	root := block.Root()
+
+	//Record the new head so that we can resume from it after a restart
+	head := Head{
+		Root:    root,
+		Hash:    block.Hash(),
+		Height:  block.NumberU64(),
+		TxCount: s.head.TxCount + txCount,
+		Index:   index,
+	}
+	batch := s.db.NewBatch()
+	if index >= 0 {
+		if err := writeIndexRoot(batch, index, root); err != nil {
+			return root, err
+		}
+	}
+	if err := writeHead(batch, &head); err != nil {
 		return root, err
 	}
+	if err := batch.Write(); err != nil {
+		s.logger.WithError(err).Error("Writing head")
+		return root, err
+	}
+	s.head = head
+	s.block = block
 
 	//Reset main ethState
 	if err := s.ethState.Reset(root); err != nil {
 		s.logger.WithError(err).Error("Resetting main StateDB")
 		return root, err
 	}
-	s.logger.WithField("root", root.Hex()).Debug("Committed")
+	s.logger.WithFields(logrus.Fields{
+		"number":   block.NumberU64(),
+		"hash":     block.Hash().Hex(),
+		"root":     root.Hex(),
+		"txs":      txCount,
+		"gas_used": block.GasUsed(),
+	}).Debug("Committed")
 
 	//Reset WAS
-	if err := s.was.Reset(root); err != nil {
+	if err := s.was.Reset(block.Header()); err != nil {
 		s.logger.WithError(err).Error("Resetting WAS")
 		return root, err
 	}
 	s.logger.Debug("Reset WAS")
 
 	//Reset TxPool
-	if err := s.txPool.Reset(root); err != nil {
+	if err := s.txPool.Reset(block.Header()); err != nil {
 		s.logger.WithError(err).Error("Resetting TxPool")
 		return root, err
 	}
 	s.logger.Debug("Reset TxPool")
 
+	s.chainFeed.Send(ChainEvent{Block: block, Logs: logs})
+
 	return root, nil
 }
 
 //------------------------------------------------------------------------------
 
 //Call executes a readonly transaction on the statedb. It is called by the
-//service handlers
+//service handlers. If the execution fails, the returned error is an
+//*ExecutionError, and the result contains the data returned by REVERT, if any.
 func (s *State) Call(callMsg ethTypes.Message) ([]byte, error) {
 	s.logger.Debug("Call")
 
-	context := vm.Context{
-		CanTransfer: core.CanTransfer,
-		Transfer:    core.Transfer,
-		GetHash:     func(uint64) common.Hash { return common.Hash{} },
-		Origin:      callMsg.From(),
-		GasPrice:    callMsg.GasPrice(),
+	res, _, execErr, err := s.execute(callMsg)
+	if err != nil {
+		s.logger.WithError(err).Error("Executing Call on WAS")
+		return nil, err
+	}
+	if execErr != nil {
+		return res, execErr
+	}
+
+	return res, nil
+}
+
+//EstimateGas returns the lowest gas limit with which a message executes
+//successfully on top of the WAS. It binary-searches between the intrinsic gas
+//of a transaction and the message's gas limit, or gasCap if the message has
+//none, capped by the block gas limit and by what the sender can afford. It
+//returns an *ExecutionError if the execution is reverted even with the highest
+//gas limit.
+func (s *State) EstimateGas(callMsg ethTypes.Message) (uint64, error) {
+	lo := params.TxGas - 1
+	hi := callMsg.Gas()
+	if hi < params.TxGas {
+		hi = gasCap
+	}
+	if blockGas := s.GetGasLimit(); hi > blockGas {
+		hi = blockGas
+	}
+
+	//The sender must be able to pay for the gas
+	if callMsg.GasPrice().Sign() > 0 {
+		available := new(big.Int).Sub(s.was.ethState.GetBalance(callMsg.From()), callMsg.Value())
+		if available.Sign() < 0 {
+			available.SetUint64(0)
+		}
+		allowance := available.Div(available, callMsg.GasPrice())
+		if allowance.IsUint64() && allowance.Uint64() < hi {
+			hi = allowance.Uint64()
+		}
+	}
+	max := hi
+
+	run := func(gas uint64) (*ExecutionError, error) {
+		msg := ethTypes.NewMessage(callMsg.From(),
+			callMsg.To(),
+			0,
+			callMsg.Value(),
+			gas,
+			callMsg.GasPrice(),
+			callMsg.Data(),
+			false)
+		_, _, execErr, err := s.execute(msg)
+		return execErr, err
+	}
+
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if execErr, err := run(mid); err != nil || execErr != nil {
+			lo = mid
+		} else {
+			hi = mid
+		}
 	}
 
+	//Check that the message succeeds at all
+	if hi == max {
+		execErr, err := run(hi)
+		if err != nil {
+			return 0, err
+		}
+		if execErr != nil {
+			if execErr.Reverted() {
+				return 0, execErr
+			}
+			return 0, fmt.Errorf("gas required exceeds allowance (%d) or always failing transaction", max)
+		}
+	}
+
+	return hi, nil
+}
+
+//execute applies a message to a copy of the WAS's ethState. It returns the
+//result of the execution, the gas used, and an *ExecutionError if the
+//execution failed.
+func (s *State) execute(callMsg ethTypes.Message) ([]byte, uint64, *ExecutionError, error) {
+	context := s.was.Context(callMsg.From(), callMsg.GasPrice())
+
+	//The tracer tells why the execution failed, if it does
+	tracer := &errorTracer{}
+
 	//We use a copy of the ethState because even call transactions increment the
 	//sender's nonce
-	vmenv := vm.NewEVM(context, s.was.ethState.Copy(), &s.chainConfig, s.vmConfig)
+	vmenv := vm.NewEVM(context, s.was.ethState.Copy(), &s.chainConfig, tracedConfig(s.vmConfig, tracer))
 
 	// Apply the transaction to the current state (included in the env)
-	res, _, _, err := core.ApplyMessage(vmenv, callMsg, new(core.GasPool).AddGas(gasLimit))
+	res, gas, failed, err := core.ApplyMessage(vmenv, callMsg, new(core.GasPool).AddGas(callMsg.Gas()))
 	if err != nil {
-		s.logger.WithError(err).Error("Executing Call on WAS")
-		return nil, err
+		return nil, 0, nil, err
+	}
+	if failed {
+		return res, gas, newExecutionError(tracer.err, res), nil
 	}
 
-	return res, err
+	return res, gas, nil, nil
+}
+
+//AddTx adds a transaction submitted to this node to the TxPool. It is called
+//by the Service handlers. Transactions are not submitted to the consensus
+//system directly: the TxPool posts them, in nonce order, as a NewTxsEvent when
+//they become pending. Rejected transactions are reported with a *TxError.
+func (s *State) AddTx(tx *ethTypes.Transaction) error {
+	return s.txPool.Add(tx)
 }
 
 //CheckTx attempt to apply a transaction to the TxPool's statedb. It is called
-//by the Service handlers to check if a transaction is valid before submitting
-//it to the consensus system. This also updates the sender's Nonce in the
-//TxPool's statedb.
+//by consensus systems that run their own admission, for transactions that they
+//already hold. This also updates the sender's Nonce in the TxPool's statedb.
+//Rejected transactions are reported with a *TxError.
 func (s *State) CheckTx(tx *ethTypes.Transaction) error {
 	return s.txPool.CheckTx(tx)
 }
 
 //ApplyTransaction decodes a transaction and applies it to the WAS. It is meant
-//to be called by the consensus system to apply transactions sequentially.
-func (s *State) ApplyTransaction(txBytes []byte, txIndex int, blockHash common.Hash) error {
+//to be called by the consensus system to apply transactions sequentially. The
+//transactions applied between two Commits form a block.
+func (s *State) ApplyTransaction(txBytes []byte) error {
+	_, err := s.DeliverTransaction(txBytes)
+	return err
+}
 
-	var t ethTypes.Transaction
-	if err := rlp.Decode(bytes.NewReader(txBytes), &t); err != nil {
+//DeliverTransaction is ApplyTransaction, but it also returns the result of the
+//transaction. Transactions that cannot be decoded or applied are rejected with
+//a TxError.
+func (s *State) DeliverTransaction(txBytes []byte) (*TxResult, error) {
+
+	t, err := DecodeTx(txBytes)
+	if err != nil {
 		s.logger.WithError(err).Error("Decoding Transaction")
-		return err
+		return nil, err
 	}
 	s.logger.WithField("hash", t.Hash().Hex()).Debug("Decoded tx")
 
-	return s.was.ApplyTransaction(t, txIndex, blockHash)
+	return s.was.ApplyTransaction(*t)
 }
 
+//CreateGenesisAccounts applies the genesis allocation to the WAS, and sets the
+//fields of the genesis block. It does not commit.
 func (s *State) CreateGenesisAccounts() error {
+	return s.createGenesis(s.genesis)
+}
 
-	if _, err := os.Stat(s.genesisFile); os.IsNotExist(err) {
-		return nil
+//createGenesis prepares the genesis block in the WAS, which must have no parent
+func (s *State) createGenesis(genesis *Genesis) error {
+	header, err := genesis.header()
+	if err != nil {
+		return err
 	}
+	s.was.header = header
+
+	return genesis.Alloc.apply(s.was.ethState)
+}
 
-	contents, err := ioutil.ReadFile(s.genesisFile)
+//InitGenesis checks that the genesis block of the State holds the accounts of
+//alloc, for consensus systems whose own genesis carries the accounts. A genesis
+//block without accounts is replaced by one with them, as long as no block was
+//committed on top of it. Otherwise, the two genesis disagree, and an error is
+//returned.
+func (s *State) InitGenesis(alloc GenesisAlloc) error {
+
+	//The genesis block with these accounts
+	genesis := *s.genesis
+	genesis.Alloc = alloc
+	block, err := genesis.ToBlock()
 	if err != nil {
 		return err
 	}
 
-	var genesis struct {
-		Alloc bcommon.AccountMap
+	current, err := s.GetBlockByNumber(0)
+	if err != nil {
+		return err
 	}
+	if current.Hash() == block.Hash() {
+		return nil
+	}
+	if current.Root() != ethTypes.EmptyRootHash {
+		return fmt.Errorf("Genesis accounts disagree with the genesis block: root %s, want %s",
+			current.Root().Hex(), block.Root().Hex())
+	}
+	if s.head.Height > 0 || s.head.Index >= 0 {
+		return fmt.Errorf("Cannot replace the genesis block: blocks were committed on top of it")
+	}
+
+	s.logger.WithField("hash", block.Hash().Hex()).Debug("Replacing empty genesis block")
 
-	if err := json.Unmarshal(contents, &genesis); err != nil {
+	//Build the genesis block again, from an empty state
+	s.was, err = NewWriteAheadState(s.db,
+		nil,
+		s.signer,
+		s.chainConfig,
+		s.vmConfig,
+		gasLimit,
+		s.logger)
+	if err != nil {
 		return err
 	}
-
-	for addr, account := range genesis.Alloc {
-		address := common.HexToAddress(addr)
-		if s.Empty(address) {
-			s.was.ethState.AddBalance(address, math.MustParseBig256(account.Balance))
-			s.was.ethState.SetCode(address, common.Hex2Bytes(account.Code))
-			for key, value := range account.Storage {
-				s.was.ethState.SetState(address, common.HexToHash(key), common.HexToHash(value))
-			}
-			s.logger.WithField("address", addr).Debug("Adding account")
-		}
+	if err := s.createGenesis(&genesis); err != nil {
+		return err
 	}
-
-	if _, err = s.Commit(); err != nil {
+	if _, err := s.Commit(-1); err != nil {
 		return err
 	}
+	s.genesis = &genesis
+
+	return s.writeChainConfig()
+}
+
+//GetGenesisHash returns the hash of the genesis block. Nodes of the same
+//network have the same genesis block.
+func (s *State) GetGenesisHash() common.Hash {
+	return rawdb.ReadCanonicalHash(s.db, 0)
+}
+
+//GetGasLimit returns the gas limit of blocks, which is set by the genesis
+//file. The transactions of a block cannot use more gas: those that do not fit
+//are rejected when the block is applied. Consensus systems that build their own
+//blocks use it to bound the gas of the transactions they put in one. It does
+//not change after NewState, so it can be called while blocks are committed.
+func (s *State) GetGasLimit() uint64 {
+	return s.blockGasLimit
+}
 
+//LastIndex returns the consensus index recorded with the last Commit, or -1 if
+//no block has been committed by the consensus system yet.
+func (s *State) LastIndex() int64 {
+	return s.head.Index
+}
+
+//CheckIndex verifies that the State is not ahead of the consensus system,
+//given the index of the last block known to the latter. A State that is ahead
+//of its consensus system was built from a different history, so the node must
+//not be started on top of it.
+func (s *State) CheckIndex(lastIndex int64) error {
+	if s.head.Index > lastIndex {
+		return fmt.Errorf("State is ahead of consensus: last committed index is %d but consensus only knows up to %d",
+			s.head.Index,
+			lastIndex)
+	}
 	return nil
+}
 
+//GetIndexRoot returns the state root that resulted from committing the block
+//with the given consensus index. Consensus systems use it to answer for blocks
+//that are replayed after a restart and were already applied.
+func (s *State) GetIndexRoot(index int64) (common.Hash, error) {
+	return readIndexRoot(s.db, index)
 }
 
 //Empty reports whether the account is non-existant or empty
@@ -259,41 +563,125 @@ func (s *State) GetNonce(addr common.Address) uint64 {
 
 //GetPoolNonce returns an account's nonce from the txpool's ethState
 func (s *State) GetPoolNonce(addr common.Address) uint64 {
-	return s.txPool.ethState.GetNonce(addr)
+	return s.txPool.GetNonce(addr)
 }
 
-//GetTransaction fetches transactions by hash directly from the DB.
-func (s *State) GetTransaction(hash common.Hash) (*ethTypes.Transaction, error) {
-	// Retrieve the transaction itself from the database
-	data, err := s.db.Get(hash.Bytes())
+//GetChainID returns the chain ID used to sign and verify transactions
+func (s *State) GetChainID() *big.Int {
+	return new(big.Int).Set(s.chainConfig.ChainID)
+}
+
+//GetSigner returns the signer used to verify transactions
+func (s *State) GetSigner() ethTypes.Signer {
+	return s.signer
+}
+
+//StateAt opens a read-only StateDB on the state committed with the given block
+//number. It shares the trie cache of the main ethState.
+func (s *State) StateAt(number uint64) (*ethState.StateDB, error) {
+	block, err := s.GetBlockByNumber(number)
 	if err != nil {
-		s.logger.WithError(err).Error("GetTransaction")
 		return nil, err
 	}
-	var tx ethTypes.Transaction
-	if err := rlp.DecodeBytes(data, &tx); err != nil {
-		s.logger.WithError(err).Error("Decoding Transaction")
+	return ethState.New(block.Root(), s.ethState.Database())
+}
+
+//CurrentBlock returns the last committed block
+func (s *State) CurrentBlock() *ethTypes.Block {
+	return s.block
+}
+
+//GetBlockByNumber fetches a committed block by number directly from the DB.
+func (s *State) GetBlockByNumber(number uint64) (*ethTypes.Block, error) {
+	hash := rawdb.ReadCanonicalHash(s.db, number)
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("Block %d not found", number)
+	}
+	return s.GetBlockByHash(hash)
+}
+
+//GetBlockByHash fetches a committed block by hash directly from the DB.
+func (s *State) GetBlockByHash(hash common.Hash) (*ethTypes.Block, error) {
+	number := rawdb.ReadHeaderNumber(s.db, hash)
+	if number == nil {
+		return nil, fmt.Errorf("Block %s not found", hash.Hex())
+	}
+	block := rawdb.ReadBlock(s.db, hash, *number)
+	if block == nil {
+		return nil, fmt.Errorf("Block %s not found", hash.Hex())
+	}
+	return block, nil
+}
+
+//GetTransaction fetches transactions by hash directly from the DB.
+func (s *State) GetTransaction(hash common.Hash) (*ethTypes.Transaction, error) {
+	tx, _, _, _ := rawdb.ReadTransaction(s.db, hash)
+	if tx == nil {
+		err := fmt.Errorf("Transaction %s not found", hash.Hex())
+		s.logger.WithError(err).Error("GetTransaction")
 		return nil, err
 	}
 
-	return &tx, nil
+	return tx, nil
+}
+
+//GetTransactionLocation returns the hash and number of the block that contains
+//a committed transaction, and the index of the transaction in that block.
+func (s *State) GetTransactionLocation(hash common.Hash) (common.Hash, uint64, uint64, error) {
+	blockHash, blockNumber, index := rawdb.ReadTxLookupEntry(s.db, hash)
+	if blockHash == (common.Hash{}) {
+		return common.Hash{}, 0, 0, fmt.Errorf("Transaction %s not found", hash.Hex())
+	}
+	return blockHash, blockNumber, index, nil
 }
 
 //GetReceipt fetches transaction receipts by transaction hash directly from the
 //DB
 func (s *State) GetReceipt(txHash common.Hash) (*ethTypes.Receipt, error) {
-	data, err := s.db.Get(append(receiptsPrefix, txHash.Bytes()...))
-	if err != nil {
+	receipt, _, _, _ := rawdb.ReadReceipt(s.db, txHash)
+	if receipt == nil {
+		err := fmt.Errorf("Receipt %s not found", txHash.Hex())
 		s.logger.WithError(err).Error("GetReceipt")
 		return nil, err
 	}
-	var receipt ethTypes.ReceiptForStorage
-	if err := rlp.DecodeBytes(data, &receipt); err != nil {
-		s.logger.WithError(err).Error("Decoding Receipt")
-		return nil, err
+
+	return receipt, nil
+}
+
+//GetExecutionError returns the error of a committed transaction that failed,
+//or nil if the transaction succeeded.
+func (s *State) GetExecutionError(txHash common.Hash) (*ExecutionError, error) {
+	return readExecutionError(s.db, txHash)
+}
+
+//GetTxStatus returns a transaction submitted to this node and its status:
+//included if it is part of a committed block, otherwise its status in the
+//TxPool. Dropped and unknown transactions are returned as nil; the reason
+//explains why a transaction was dropped.
+func (s *State) GetTxStatus(hash common.Hash) (*ethTypes.Transaction, TxStatus, string) {
+	if tx, _, _, _ := rawdb.ReadTransaction(s.db, hash); tx != nil {
+		return tx, TxStatusIncluded, ""
 	}
+	return s.txPool.Get(hash)
+}
+
+//GetPoolContent returns the pending and queued transactions of the TxPool,
+//grouped by sender and sorted by nonce.
+func (s *State) GetPoolContent() (map[common.Address][]*ethTypes.Transaction,
+	map[common.Address][]*ethTypes.Transaction) {
+	return s.txPool.Content()
+}
+
+//GetPoolStats returns the number of pending and queued transactions of the
+//TxPool
+func (s *State) GetPoolStats() (int, int) {
+	return s.txPool.Stats()
+}
 
-	return (*ethTypes.Receipt)(&receipt), nil
+//DropTx removes a transaction from the TxPool after the consensus system
+//failed to accept it. The reason is reported by GetTxStatus.
+func (s *State) DropTx(hash common.Hash, reason string) error {
+	return s.txPool.Drop(hash, reason)
 }
 
 //------------------------------------------------------------------------------
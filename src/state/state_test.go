@@ -1,19 +1,30 @@
 package state
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	ethState "github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 	"github.com/sirupsen/logrus"
 
 	bcommon "github.com/bear987978897/evm-lite/src/common"
@@ -42,7 +53,7 @@ func NewTest(dataDir string, logger *logrus.Logger, t *testing.T) *Test {
 	genesisFile := filepath.Join(dataDir, "genesis.json")
 	cache := 128
 
-	state, err := NewState(logger, dbFile, cache, genesisFile)
+	state, err := NewState(logger, dbFile, cache, genesisFile, nil, DefaultTxPoolConfig())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,7 +147,7 @@ func (test *Test) prepareTransaction(from, to *accounts.Account,
 			data)
 	}
 
-	signer := ethTypes.NewEIP155Signer(big.NewInt(1))
+	signer := test.state.GetSigner()
 
 	signature, err := test.keyStore.SignHash(*from, signer.Hash(tx).Bytes())
 	if err != nil {
@@ -171,11 +182,11 @@ func (test *Test) deployContract(from accounts.Account, contract *Contract, t *t
 	}
 
 	// Try to commit the transaction
-	err = test.state.ApplyTransaction(data, 0, common.Hash{})
+	err = test.state.ApplyTransaction(data)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = test.state.Commit()
+	_, err = test.state.Commit(test.state.LastIndex() + 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -231,11 +242,11 @@ func TestTransfer(t *testing.T) {
 	}
 
 	// Try to process the block
-	err = test.state.ApplyTransaction(data, 0, common.Hash{})
+	err = test.state.ApplyTransaction(data)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = test.state.Commit()
+	_, err = test.state.Commit(test.state.LastIndex() + 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -256,6 +267,278 @@ func TestTransfer(t *testing.T) {
 	}
 }
 
+func TestResume(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+	to := test.keyStore.Accounts()[1]
+
+	tx, err := test.prepareTransaction(&from,
+		&to,
+		big.NewInt(1000000),
+		uint64(21000),
+		big.NewInt(0),
+		[]byte{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = test.state.ApplyTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := test.state.Commit(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toBalance := test.state.GetBalance(to.Address)
+
+	// Restart
+	test.state.db.Close()
+	test = NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	if l := test.state.LastIndex(); l != 7 {
+		t.Fatalf("LastIndex should be 7, not %d", l)
+	}
+
+	if b := test.state.GetBalance(to.Address); b.Cmp(toBalance) != 0 {
+		t.Fatalf("Balance should be %v, not %v", toBalance, b)
+	}
+
+	if n := test.state.GetNonce(from.Address); n != 1 {
+		t.Fatalf("Nonce should be 1, not %d", n)
+	}
+
+	indexRoot, err := test.state.GetIndexRoot(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexRoot != root {
+		t.Fatalf("Root at index 7 should be %v, not %v", root.Hex(), indexRoot.Hex())
+	}
+
+	if err := test.state.CheckIndex(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := test.state.CheckIndex(6); err == nil {
+		t.Fatal("CheckIndex should fail when the State is ahead of consensus")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+	os.RemoveAll("test_data/eth/snapshot")
+	defer os.RemoveAll("test_data/eth/snapshot")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+
+	contract := dummyContract()
+	test.deployContract(from, contract, t)
+	contract.parseABI(t)
+	callDummyContractTestAsync(test, from, contract, t)
+
+	snap, err := test.state.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+
+	// Blocks committed after the snapshot are not part of it
+	callDummyContractTestAsync(test, from, contract, t)
+
+	var buf bytes.Buffer
+	if err := snap.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Restore into an empty database
+	restored, err := NewState(test.logger,
+		"test_data/eth/snapshot",
+		test.cache,
+		filepath.Join(test.dataDir, "genesis.json"),
+		nil,
+		DefaultTxPoolConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.db.Close()
+
+	if err := restored.Restore(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	head := snap.Head()
+	if l := restored.LastIndex(); l != head.Index {
+		t.Fatalf("LastIndex should be %d, not %d", head.Index, l)
+	}
+	if b := restored.CurrentBlock(); b.Hash() != head.Hash || b.Root() != head.Root {
+		t.Fatalf("Current block should be %s, not %s", head.Hash.Hex(), b.Hash().Hex())
+	}
+	if n := restored.GetNonce(from.Address); n != 2 {
+		t.Fatalf("Nonce should be 2, not %d", n)
+	}
+	if n := restored.GetPoolNonce(from.Address); n != 2 {
+		t.Fatalf("Pool nonce should be 2, not %d", n)
+	}
+
+	// Code and storage
+	callDummyContractTest(&Test{state: restored}, from, contract, big.NewInt(110), t)
+
+	// Index metadata
+	for index := int64(0); index <= head.Index; index++ {
+		root, err := test.state.GetIndexRoot(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, err := restored.GetIndexRoot(index); err != nil || r != root {
+			t.Fatalf("Root at index %d should be %s, not %s (%v)", index, root.Hex(), r.Hex(), err)
+		}
+	}
+	for number := uint64(0); number <= head.Height; number++ {
+		block, err := restored.GetBlockByNumber(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range block.Transactions() {
+			if _, err := restored.GetTransaction(tx.Hash()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := restored.GetReceipt(tx.Hash()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The restored State keeps committing blocks from the snapshot
+	callDummyContractTestAsync(&Test{state: restored, keyStore: test.keyStore}, from, contract, t)
+	callDummyContractTest(&Test{state: restored}, from, contract, big.NewInt(210), t)
+
+	// A State that already committed the snapshot is left untouched
+	lastIndex := test.state.LastIndex()
+	if err := test.state.Restore(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if l := test.state.LastIndex(); l != lastIndex {
+		t.Fatalf("LastIndex should be %d, not %d", lastIndex, l)
+	}
+}
+
+func verifyProof(root common.Hash, key []byte, proof []hexutil.Bytes, t *testing.T) []byte {
+	db := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	value, _, err := trie.VerifyProof(root, key, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestProof(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+
+	contract := dummyContract()
+	test.deployContract(from, contract, t)
+	deployIndex := test.state.LastIndex()
+	contract.parseABI(t)
+	callDummyContractTestAsync(test, from, contract, t)
+
+	// localI is in slot 0: 1 after the deployment, 11 after testAsync(10)
+	slot := common.Hash{}
+	for index, expected := range map[int64]int64{deployIndex: 1, test.state.LastIndex(): 11} {
+		proof, err := test.state.GetProof(index, contract.address, []common.Hash{slot})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		root, _ := test.state.GetIndexRoot(index)
+		enc := verifyProof(root, crypto.Keccak256(contract.address.Bytes()), proof.AccountProof, t)
+		var account ethState.Account
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			t.Fatal(err)
+		}
+		if account.Root != proof.StorageHash {
+			t.Fatalf("Storage root should be %s, not %s", account.Root.Hex(), proof.StorageHash.Hex())
+		}
+
+		storage := proof.StorageProof[0]
+		if v := storage.Value.ToInt().Int64(); v != expected {
+			t.Fatalf("Slot value at index %d should be %d, not %d", index, expected, v)
+		}
+		enc = verifyProof(proof.StorageHash, crypto.Keccak256(slot.Bytes()), storage.Proof, t)
+		if _, content, _, _ := rlp.Split(enc); new(big.Int).SetBytes(content).Int64() != expected {
+			t.Fatalf("Proven slot value at index %d should be %d", index, expected)
+		}
+	}
+
+	// Accounts that do not exist are proven absent
+	proof, err := test.state.GetProof(test.state.LastIndex(), common.HexToAddress("0xdead"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := verifyProof(test.state.CurrentBlock().Root(), crypto.Keccak256(common.HexToAddress("0xdead").Bytes()), proof.AccountProof, t); enc != nil {
+		t.Fatalf("Account should be absent, got %x", enc)
+	}
+
+	// Receipts
+	block := test.state.CurrentBlock()
+	txHash := block.Transactions()[0].Hash()
+	receiptProof, err := test.state.GetReceiptProof(txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receiptProof.ReceiptsRoot != block.ReceiptHash() {
+		t.Fatalf("Receipt root should be %s, not %s", block.ReceiptHash().Hex(), receiptProof.ReceiptsRoot.Hex())
+	}
+	key, _ := rlp.EncodeToBytes(uint(receiptProof.TransactionIndex))
+	enc := verifyProof(block.ReceiptHash(), key, receiptProof.Proof, t)
+	if expected, _ := rlp.EncodeToBytes(receiptProof.Receipt); !bytes.Equal(enc, expected) {
+		t.Fatal("Proven receipt does not match")
+	}
+}
+
 //------------------------------------------------------------------------------
 type Contract struct {
 	name    string
@@ -365,11 +648,11 @@ func callDummyContractTestAsync(test *Test, from accounts.Account, contract *Con
 	}
 
 	// Try to process the block
-	err = test.state.ApplyTransaction(data, 0, common.Hash{})
+	err = test.state.ApplyTransaction(data)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = test.state.Commit()
+	_, err = test.state.Commit(test.state.LastIndex() + 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -414,3 +697,1120 @@ func TestCreateContract(t *testing.T) {
 	callDummyContractTest(test, from, contract, big.NewInt(110), t)
 
 }
+
+//------------------------------------------------------------------------------
+
+/*
+
+Runtime code of a contract that returns the block number, the block timestamp,
+and the hash of the previous block:
+
+NUMBER PUSH1 0x00 MSTORE
+TIMESTAMP PUSH1 0x20 MSTORE
+PUSH1 0x01 NUMBER SUB BLOCKHASH PUSH1 0x40 MSTORE
+PUSH1 0x60 PUSH1 0x00 RETURN
+
+*/
+
+func blockInfoContract() *Contract {
+	return &Contract{
+		name: "BlockInfo",
+		code: "601580600b6000396000f3" + "4360005242602052600143034060405260606000f3",
+	}
+}
+
+func TestBlocks(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := test.state.CurrentBlock()
+	if genesis.NumberU64() != 0 {
+		t.Fatalf("Genesis block number should be 0, not %d", genesis.NumberU64())
+	}
+
+	from := test.keyStore.Accounts()[0]
+
+	contract := blockInfoContract()
+
+	test.deployContract(from, contract, t)
+
+	block := test.state.CurrentBlock()
+	if block.NumberU64() != 1 {
+		t.Fatalf("Block number should be 1, not %d", block.NumberU64())
+	}
+	if block.ParentHash() != genesis.Hash() {
+		t.Fatalf("Block parent should be %s, not %s", genesis.Hash().Hex(), block.ParentHash().Hex())
+	}
+	if len(block.Transactions()) != 1 {
+		t.Fatalf("Block should contain 1 transaction, not %d", len(block.Transactions()))
+	}
+
+	byNumber, err := test.state.GetBlockByNumber(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byNumber.Hash() != block.Hash() {
+		t.Fatalf("Block 1 should be %s, not %s", block.Hash().Hex(), byNumber.Hash().Hex())
+	}
+
+	timestamp := time.Unix(1234567890, 0)
+	test.state.SetBlockTime(timestamp)
+
+	callMsg := ethTypes.NewMessage(from.Address,
+		&contract.address,
+		0,
+		_defaultValue,
+		_defaultGas,
+		_defaultGasPrice,
+		[]byte{},
+		false)
+
+	res, err := test.state.Call(callMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 96 {
+		t.Fatalf("Result should be 96 bytes long, not %d", len(res))
+	}
+
+	if number := new(big.Int).SetBytes(res[:32]); number.Uint64() != 2 {
+		t.Fatalf("NUMBER should be 2, not %v", number)
+	}
+	if ts := new(big.Int).SetBytes(res[32:64]); ts.Int64() != timestamp.Unix() {
+		t.Fatalf("TIMESTAMP should be %d, not %v", timestamp.Unix(), ts)
+	}
+	if hash := common.BytesToHash(res[64:]); hash != block.Hash() {
+		t.Fatalf("BLOCKHASH(NUMBER-1) should be %s, not %s", block.Hash().Hex(), hash.Hex())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+/*
+
+Runtime code of a contract that emits a log with no data and the first word of
+its calldata as only topic:
+
+PUSH1 0x00 CALLDATALOAD PUSH1 0x00 PUSH1 0x00 LOG1 STOP
+
+*/
+
+func logContract() *Contract {
+	return &Contract{
+		name: "Log",
+		code: "600980600b6000396000f3" + "60003560006000a100",
+	}
+}
+
+func TestLogs(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+
+	contract := logContract()
+
+	test.deployContract(from, contract, t)
+
+	// Emit one log per block, in blocks 2, 3 and 4
+	topicA := common.BigToHash(big.NewInt(1))
+	topicB := common.BigToHash(big.NewInt(2))
+	for _, topic := range []common.Hash{topicA, topicB, topicA} {
+		tx, err := test.prepareTransaction(&from,
+			&accounts.Account{Address: contract.address},
+			_defaultValue,
+			_defaultGas,
+			_defaultGasPrice,
+			topic.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := test.state.ApplyTransaction(data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := test.state.Commit(test.state.LastIndex() + 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// And an empty block
+	if _, err := test.state.Commit(test.state.LastIndex() + 1); err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := []common.Address{contract.address}
+	other := []common.Address{from.Address}
+
+	testCases := []struct {
+		name      string
+		from, to  uint64
+		addresses []common.Address
+		topics    [][]common.Hash
+		blocks    []uint64
+	}{
+		{"all", 0, 10, nil, nil, []uint64{2, 3, 4}},
+		{"address", 0, 10, addresses, nil, []uint64{2, 3, 4}},
+		{"other address", 0, 10, other, nil, nil},
+		{"topic", 0, 10, addresses, [][]common.Hash{{topicA}}, []uint64{2, 4}},
+		{"topics", 0, 10, nil, [][]common.Hash{{topicA, topicB}}, []uint64{2, 3, 4}},
+		{"range", 3, 4, nil, [][]common.Hash{{topicA}}, []uint64{4}},
+		{"empty range", 5, 5, nil, nil, nil},
+		{"too many topics", 0, 10, nil, [][]common.Hash{{topicA}, {topicA}}, nil},
+	}
+
+	for _, tc := range testCases {
+		logs, err := test.state.GetLogs(tc.from, tc.to, tc.addresses, tc.topics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != len(tc.blocks) {
+			t.Fatalf("%s: expected %d logs, not %d", tc.name, len(tc.blocks), len(logs))
+		}
+		for i, log := range logs {
+			if log.BlockNumber != tc.blocks[i] {
+				t.Fatalf("%s: log %d should be in block %d, not %d", tc.name, i, tc.blocks[i], log.BlockNumber)
+			}
+		}
+	}
+
+	block, err := test.state.GetBlockByNumber(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := test.state.GetBlockLogs(block.Hash(), addresses, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Topics[0] != topicB || logs[0].BlockHash != block.Hash() {
+		t.Fatalf("Block 3 should contain one log with topic %s", topicB.Hex())
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func TestEstimateGas(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+	to := test.keyStore.Accounts()[1]
+
+	estimate := func(to *common.Address, gas uint64, data []byte) (uint64, error) {
+		msg := ethTypes.NewMessage(from.Address,
+			to,
+			0,
+			_defaultValue,
+			gas,
+			_defaultGasPrice,
+			data,
+			false)
+		return test.state.EstimateGas(msg)
+	}
+
+	// A simple transfer costs the intrinsic gas of a transaction
+	gas, err := estimate(&to.Address, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas != params.TxGas {
+		t.Fatalf("Transfer should cost %d gas, not %d", params.TxGas, gas)
+	}
+
+	contract := dummyContract()
+	test.deployContract(from, contract, t)
+	contract.parseABI(t)
+
+	callData, err := contract.jsonABI.Pack("testAsync", big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gas, err = estimate(&contract.address, 0, callData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas <= params.TxGas {
+		t.Fatalf("testAsync should cost more than %d gas, not %d", params.TxGas, gas)
+	}
+
+	// The estimate is the lowest gas limit that succeeds
+	if _, err := estimate(&contract.address, gas, callData); err != nil {
+		t.Fatalf("testAsync should succeed with %d gas: %v", gas, err)
+	}
+	if _, err := estimate(&contract.address, gas-1, callData); err == nil {
+		t.Fatalf("testAsync should fail with %d gas", gas-1)
+	}
+
+	// Calls that always fail are reported
+	invalid := &Contract{name: "Invalid", code: "600180600b6000396000f3" + "fe"}
+	test.deployContract(from, invalid, t)
+	if _, err := estimate(&invalid.address, 0, nil); err == nil {
+		t.Fatal("Estimating a failing call should fail")
+	}
+}
+
+func TestUnpackRevert(t *testing.T) {
+	// Error("nope")
+	data := common.FromHex("08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000")
+
+	reason, err := UnpackRevert(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "nope" {
+		t.Fatalf("Reason should be nope, not %s", reason)
+	}
+
+	if err := newExecutionError(errors.New("evm: execution reverted"), data).Error(); err != "execution reverted: nope" {
+		t.Fatalf("Unexpected error message: %s", err)
+	}
+
+	// Panic(0x11)
+	data = common.FromHex("4e487b71" +
+		"0000000000000000000000000000000000000000000000000000000000000011")
+
+	reason, err = UnpackRevert(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "panic: arithmetic underflow or overflow (0x11)" {
+		t.Fatalf("Unexpected panic reason: %s", reason)
+	}
+
+	if _, err := UnpackRevert(common.FromHex("deadbeef")); err == nil {
+		t.Fatal("Unpacking data that is not an Error(string) should fail")
+	}
+}
+
+func TestExecutionError(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+
+	// A contract whose code is a single INVALID opcode
+	invalid := &Contract{name: "Invalid", code: "600180600b6000396000f3" + "fe"}
+	test.deployContract(from, invalid, t)
+
+	tx, err := test.prepareTransaction(&from,
+		&accounts.Account{Address: invalid.address},
+		_defaultValue,
+		_defaultGas,
+		_defaultGasPrice,
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := test.state.DeliverTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error == nil || res.Receipt.Status != ethTypes.ReceiptStatusFailed {
+		t.Fatalf("Result should be a failure, not %v", res.Error)
+	}
+	if res.Receipt.GasUsed != _defaultGas {
+		t.Fatalf("Failed transaction should use all its gas, not %d", res.Receipt.GasUsed)
+	}
+	if _, err := test.state.Commit(test.state.LastIndex() + 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transactions that cannot be applied are rejected with a TxError
+	_, err = test.state.DeliverTransaction(data)
+	if txErr, ok := err.(*TxError); !ok || txErr.Code != CodeNonceTooLow {
+		t.Fatalf("Replayed transaction should be rejected with CodeNonceTooLow, not %v", err)
+	}
+
+	execErr, err := test.state.GetExecutionError(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execErr == nil || !strings.HasPrefix(execErr.Err, "invalid opcode") {
+		t.Fatalf("Transaction should have failed with an invalid opcode, not %v", execErr)
+	}
+
+	// Successful transactions have no execution error
+	deployment := test.state.CurrentBlock()
+	parent, err := test.state.GetBlockByHash(deployment.ParentHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	execErr, err = test.state.GetExecutionError(parent.Transactions()[0].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execErr != nil {
+		t.Fatalf("Deployment should not have an execution error, not %v", execErr)
+	}
+
+	callMsg := ethTypes.NewMessage(from.Address,
+		&invalid.address,
+		0,
+		_defaultValue,
+		_defaultGas,
+		_defaultGasPrice,
+		nil,
+		false)
+
+	_, err = test.state.Call(callMsg)
+	if _, ok := err.(*ExecutionError); !ok {
+		t.Fatalf("Call should fail with an ExecutionError, not %v", err)
+	}
+}
+
+func TestCheckTx(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+	to := test.keyStore.Accounts()[1]
+	balance := test.state.GetBalance(from.Address)
+	chainID := test.state.GetChainID()
+
+	transfer := func(nonce uint64, value *big.Int, gas uint64, chainID *big.Int) *ethTypes.Transaction {
+		tx := ethTypes.NewTransaction(nonce, to.Address, value, gas, _defaultGasPrice, nil)
+		signed, err := test.keyStore.SignTx(from, tx, chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tx := transfer(0, big.NewInt(1), 21000, chainID)
+	if err := test.state.CheckTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	// Checking an admitted transaction again is a no-op
+	if err := test.state.CheckTx(tx); err != nil {
+		t.Fatalf("Admitted transaction should pass CheckTx again, not %v", err)
+	}
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 1 {
+		t.Fatalf("Pool nonce should be 1, not %d", nonce)
+	}
+
+	unsigned := ethTypes.NewTransaction(1, to.Address, big.NewInt(1), 21000, _defaultGasPrice, nil)
+	badSig, err := unsigned.WithSignature(ethTypes.HomesteadSigner{}, make([]byte, 65))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		tx   *ethTypes.Transaction
+		code uint32
+	}{
+		{"invalid sender", badSig, CodeInvalidSender},
+		{"invalid chain id", transfer(1, big.NewInt(1), 21000, big.NewInt(2)), CodeInvalidChainID},
+		{"nonce too low", transfer(0, big.NewInt(2), 21000, chainID), CodeNonceTooLow},
+		{"nonce too high", transfer(5, big.NewInt(1), 21000, chainID), CodeNonceTooHigh},
+		{"insufficient funds", transfer(1, balance, 21000, chainID), CodeInsufficientFunds},
+		{"intrinsic gas", transfer(1, big.NewInt(1), 20999, chainID), CodeIntrinsicGas},
+	}
+
+	for _, tc := range testCases {
+		err := test.state.CheckTx(tc.tx)
+		txErr, ok := err.(*TxError)
+		if !ok {
+			t.Fatalf("%s: CheckTx should return a TxError, not %v", tc.name, err)
+		}
+		if txErr.Code != tc.code {
+			t.Fatalf("%s: error code should be %d, not %d (%v)", tc.name, tc.code, txErr.Code, txErr)
+		}
+	}
+
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 1 {
+		t.Fatalf("Rejected transactions should not change the pool nonce (%d)", nonce)
+	}
+
+	if _, err := DecodeTx([]byte{0x01, 0x02}); err == nil || err.(*TxError).Code != CodeMalformedTx {
+		t.Fatalf("DecodeTx should fail with CodeMalformedTx, not %v", err)
+	}
+}
+
+func TestTxPool(t *testing.T) {
+
+	os.RemoveAll("test_data/eth/chaindata")
+	defer os.RemoveAll("test_data/eth/chaindata")
+
+	test := NewTest("test_data/eth", bcommon.NewTestLogger(t), t)
+	defer test.state.db.Close()
+
+	err := test.Init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := test.keyStore.Accounts()[0]
+	to := test.keyStore.Accounts()[1]
+	chainID := test.state.GetChainID()
+
+	transfer := func(nonce uint64, gasPrice int64) *ethTypes.Transaction {
+		tx := ethTypes.NewTransaction(nonce, to.Address, big.NewInt(1), 21000, big.NewInt(gasPrice), nil)
+		signed, err := test.keyStore.SignTx(from, tx, chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	expectCode := func(err error, code uint32) {
+		if txErr, ok := err.(*TxError); !ok || txErr.Code != code {
+			t.Fatalf("Error should have code %d, not %v", code, err)
+		}
+	}
+
+	txCh := make(chan NewTxsEvent, 16)
+	sub := test.state.SubscribeNewTxsEvent(txCh)
+	defer sub.Unsubscribe()
+
+	// A nonce gap queues the transaction
+	queued := transfer(1, 20)
+	if err := test.state.AddTx(queued); err != nil {
+		t.Fatal(err)
+	}
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 0 {
+		t.Fatalf("Queued transactions should not change the pool nonce (%d)", nonce)
+	}
+
+	// Replacements must pay PriceBump more
+	expectCode(test.state.AddTx(transfer(1, 21)), CodeReplaceUnderpriced)
+	replacement := transfer(1, 22)
+	if err := test.state.AddTx(replacement); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus := func(tx *ethTypes.Transaction, status TxStatus) {
+		if _, s, _ := test.state.GetTxStatus(tx.Hash()); s != status {
+			t.Fatalf("Transaction %s should be %s, not %s", tx.Hash().Hex(), status, s)
+		}
+	}
+	expectStatus(queued, TxStatusDropped)
+	expectStatus(replacement, TxStatusQueued)
+
+	// Caps apply to the queue
+	test.state.txPool.config.AccountQueue = 1
+	expectCode(test.state.AddTx(transfer(2, 10)), CodeTxPoolFull)
+
+	// Filling the gap promotes the queued transaction
+	first := transfer(0, 10)
+	if err := test.state.AddTx(first); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-txCh:
+		if len(ev.Txs) != 2 || ev.Txs[0].Hash() != first.Hash() || ev.Txs[1].Hash() != replacement.Hash() {
+			t.Fatalf("Promoted transactions should be %s and %s, not %v",
+				first.Hash().Hex(), replacement.Hash().Hex(), ev.Txs)
+		}
+	default:
+		t.Fatal("Promoted transactions should be posted")
+	}
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 2 {
+		t.Fatalf("Pool nonce should be 2, not %d", nonce)
+	}
+	expectCode(test.state.AddTx(first), CodeKnownTx)
+	expectStatus(replacement, TxStatusPending)
+	if pending, queued := test.state.GetPoolStats(); pending != 2 || queued != 0 {
+		t.Fatalf("Pool should have 2 pending and 0 queued transactions, not %d and %d", pending, queued)
+	}
+
+	// Including the first transaction in a block leaves the second pending
+	data, err := rlp.EncodeToBytes(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.state.ApplyTransaction(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.state.Commit(test.state.LastIndex() + 1); err != nil {
+		t.Fatal(err)
+	}
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 2 {
+		t.Fatalf("Pool nonce should still be 2 after the block, not %d", nonce)
+	}
+	if err := test.state.CheckTx(replacement); err != nil {
+		t.Fatalf("Pending transaction should pass CheckTx, not %v", err)
+	}
+	expectStatus(first, TxStatusIncluded)
+	expectStatus(replacement, TxStatusPending)
+	expectStatus(transfer(5, 0), TxStatusUnknown)
+
+	// Dropping a pending transaction drops the following ones and rewinds
+	// the pool nonce
+	test.state.txPool.config.AccountQueue = 64
+	next := transfer(2, 10)
+	if err := test.state.AddTx(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := test.state.DropTx(replacement.Hash(), "rejected"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(replacement, TxStatusDropped)
+	expectStatus(next, TxStatusDropped)
+	if _, _, reason := test.state.GetTxStatus(next.Hash()); reason != "rejected" {
+		t.Fatalf("Drop reason should be rejected, not %q", reason)
+	}
+	if nonce := test.state.GetPoolNonce(from.Address); nonce != 1 {
+		t.Fatalf("Pool nonce should be 1 after the drop, not %d", nonce)
+	}
+}
+
+func TestInitGenesis(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := bcommon.NewTestLogger(t)
+
+	genesis, err := ReadGenesis("test_data/eth/genesis.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := common.HexToAddress("59d6e09fde8bf65183ddd1e0ca06f3d618c44c57")
+
+	newState := func(name, genesisFile string) *State {
+		s, err := NewState(logger, filepath.Join(dir, name), 128, genesisFile, nil, DefaultTxPoolConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// A State created with the same genesis accounts agrees
+	full := newState("full", "test_data/eth/genesis.json")
+	defer full.db.Close()
+	if err := full.InitGenesis(genesis.Alloc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The empty genesis block of a State created without a genesis file is
+	// replaced
+	empty := newState("empty", filepath.Join(dir, "missing.json"))
+	defer empty.db.Close()
+	if err := empty.InitGenesis(genesis.Alloc); err != nil {
+		t.Fatal(err)
+	}
+	if empty.CurrentBlock().Hash() != full.CurrentBlock().Hash() {
+		t.Fatalf("Genesis block should be %s, not %s",
+			full.CurrentBlock().Hash().Hex(),
+			empty.CurrentBlock().Hash().Hex())
+	}
+	if balance := empty.GetBalance(addr); balance.Cmp(full.GetBalance(addr)) != 0 {
+		t.Fatalf("Balance should be %v, not %v", full.GetBalance(addr), balance)
+	}
+
+	// Different accounts disagree
+	other := GenesisAlloc{}
+	other["b0b"] = genesis.Alloc["59d6e09fde8bf65183ddd1e0ca06f3d618c44c57"]
+	if err := full.InitGenesis(other); err == nil {
+		t.Fatal("Different genesis accounts should disagree")
+	}
+
+	// The genesis block cannot be replaced once blocks are committed on top
+	fresh := newState("fresh", filepath.Join(dir, "missing.json"))
+	defer fresh.db.Close()
+	if _, err := fresh.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.InitGenesis(genesis.Alloc); err == nil {
+		t.Fatal("Genesis block should not be replaced after a commit")
+	}
+}
+
+func TestChainID(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-chainid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesisFile := filepath.Join(dir, "genesis.json")
+	if err := ioutil.WriteFile(genesisFile, []byte(`{"config": {"chainId": 4242}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		genesis    string
+		configured *big.Int
+		chainID    *big.Int
+	}{
+		{"default", filepath.Join(dir, "missing.json"), nil, DefaultChainID},
+		{"configured", filepath.Join(dir, "missing.json"), big.NewInt(7), big.NewInt(7)},
+		{"genesis", genesisFile, nil, big.NewInt(4242)},
+		{"both", genesisFile, big.NewInt(4242), big.NewInt(4242)},
+	}
+
+	for _, tc := range testCases {
+		s, err := NewState(bcommon.NewTestLogger(t),
+			filepath.Join(dir, tc.name),
+			128,
+			tc.genesis,
+			tc.configured,
+			DefaultTxPoolConfig())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		s.db.Close()
+
+		if s.GetChainID().Cmp(tc.chainID) != 0 {
+			t.Fatalf("%s: chain ID should be %v, not %v", tc.name, tc.chainID, s.GetChainID())
+		}
+		if signer := ethTypes.NewEIP155Signer(tc.chainID); !s.GetSigner().Equal(signer) {
+			t.Fatalf("%s: signer should use chain ID %v", tc.name, tc.chainID)
+		}
+	}
+
+	_, err = NewState(bcommon.NewTestLogger(t),
+		filepath.Join(dir, "disagree"),
+		128,
+		genesisFile,
+		big.NewInt(1),
+		DefaultTxPoolConfig())
+	if err == nil {
+		t.Fatal("Chain ID that disagrees with the genesis file should be rejected")
+	}
+}
+
+func TestForks(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-forks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	account := NewTestAccount(t)
+
+	newState := func(name, config string) (*State, error) {
+		genesisFile := filepath.Join(dir, name+".json")
+		account.WriteGenesis(t, genesisFile, config)
+		return NewState(bcommon.NewTestLogger(t),
+			filepath.Join(dir, name),
+			128,
+			genesisFile,
+			nil,
+			DefaultTxPoolConfig())
+	}
+
+	// A contract that reverts with Error("nope")
+	reason := "08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000"
+	code := common.FromHex("6070600c60003960706000f3" + "6064600c60003960646000fd" + reason)
+
+	// deliver applies a transaction in a block of its own
+	deliver := func(s *State, tx *ethTypes.Transaction) *TxResult {
+		tx, err := ethTypes.SignTx(tx, s.GetSigner(), account.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.DeliverTransaction(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Commit(s.LastIndex() + 1); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	deploy := func(s *State) (common.Address, *TxResult) {
+		res := deliver(s, ethTypes.NewContractCreation(0, _defaultValue, _defaultGas, _defaultGasPrice, code))
+		return res.Receipt.ContractAddress, res
+	}
+
+	// touch sends 0 wei to an account that does not exist, and tells whether
+	// it exists afterwards
+	touch := func(s *State) bool {
+		empty := common.HexToAddress("0xe3e3")
+		deliver(s, ethTypes.NewTransaction(1, empty, big.NewInt(0), _defaultGas, _defaultGasPrice, nil))
+		return s.ethState.Exist(empty)
+	}
+
+	call := func(s *State, to common.Address) error {
+		msg := ethTypes.NewMessage(account.Address, &to, 1, _defaultValue, _defaultGas, _defaultGasPrice, nil, false)
+		_, err := s.Call(msg)
+		return err
+	}
+
+	// Without config, the EVM follows the Frontier rules, which have no REVERT
+	frontier, err := newState("frontier", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frontier.db.Close()
+	contract, deployed := deploy(frontier)
+	err = call(frontier, contract)
+	if execErr, ok := err.(*ExecutionError); !ok || !strings.HasPrefix(execErr.Err, "invalid opcode") {
+		t.Fatalf("Call should fail with an invalid opcode, not %v", err)
+	}
+
+	// Before EIP158, empty accounts survive, and receipts carry the
+	// intermediate root
+	if !touch(frontier) {
+		t.Fatal("Empty account should survive before EIP158")
+	}
+	if len(deployed.Receipt.PostState) != len(common.Hash{}) {
+		t.Fatalf("Receipt should carry the intermediate root, not %x", deployed.Receipt.PostState)
+	}
+
+	// From Byzantium, REVERT returns the reason
+	byzantium, err := newState("byzantium", `"config": {
+		"chainId": 4242,
+		"homesteadBlock": 0,
+		"eip150Block": 0,
+		"eip155Block": 0,
+		"eip158Block": 0,
+		"byzantiumBlock": 0
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, res := deploy(byzantium)
+	if res.Receipt.Status != ethTypes.ReceiptStatusSuccessful || len(res.Receipt.PostState) != 0 {
+		t.Fatalf("Deployment should succeed, with a status receipt, not %+v", res.Receipt)
+	}
+	if touch(byzantium) {
+		t.Fatal("Empty account should be deleted from EIP158")
+	}
+	err = call(byzantium, contract)
+	if execErr, ok := err.(*ExecutionError); !ok || !execErr.Reverted() || execErr.Reason != "nope" {
+		t.Fatalf("Call should be reverted with reason nope, not %v", err)
+	}
+	if byzantium.GetChainID().Int64() != 4242 {
+		t.Fatalf("Chain ID should be 4242, not %v", byzantium.GetChainID())
+	}
+
+	// The configuration cannot change the rules of committed blocks
+	byzantium.db.Close()
+	if _, err := newState("byzantium", `"config": {"chainId": 4242}`); err == nil {
+		t.Fatal("Incompatible chain configuration should be rejected")
+	}
+
+	// Forks must be scheduled in order, and be implemented by the EVM
+	for _, config := range []string{
+		`"config": {"byzantiumBlock": 0}`,
+		`"config": {"homesteadBlock": 10, "eip150Block": 5}`,
+		`"config": {"istanbulBlock": 0}`,
+	} {
+		if _, err := newState("invalid", config); err == nil {
+			t.Fatalf("Genesis config %s should be rejected", config)
+		}
+	}
+}
+
+func TestGenesis(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-geth-genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A genesis file as written for geth, with numbers in hex and decimal
+	contents := `{
+		"config": {
+			"chainId": 4242,
+			"homesteadBlock": 0,
+			"eip150Block": 0,
+			"eip155Block": 0,
+			"eip158Block": 0,
+			"byzantiumBlock": 0
+		},
+		"nonce": "0x0000000000000042",
+		"timestamp": "0x5c4a3b2f",
+		"extraData": "0x65766d6c",
+		"gasLimit": "0x47b760",
+		"difficulty": "0x400",
+		"mixhash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"coinbase": "0x0000000000000000000000000000000000000000",
+		"number": "0x0",
+		"gasUsed": "0x0",
+		"parentHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"alloc": {
+			"0x59d6e09fde8bf65183ddd1e0ca06f3d618c44c57": {
+				"balance": "0x487a9a304539440000",
+				"nonce": "0x5"
+			},
+			"d93535fcd9f7f119e9b741642a8e1353355de90a": {
+				"balance": "1337000000000000000000",
+				"nonce": "3",
+				"code": "0x6001600055",
+				"storage": {
+					"0x00": "0x01",
+					"0x0000000000000000000000000000000000000000000000000000000000000002": "0x2a"
+				}
+			}
+		}
+	}`
+	genesisFile := filepath.Join(dir, "genesis.json")
+	if err := ioutil.WriteFile(genesisFile, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := ReadGenesis(genesisFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := genesis.ToBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// geth computes the same genesis block
+	var gethGenesis core.Genesis
+	if err := json.Unmarshal([]byte(contents), &gethGenesis); err != nil {
+		t.Fatal(err)
+	}
+	if hash := gethGenesis.ToBlock(nil).Hash(); block.Hash() != hash {
+		t.Fatalf("Genesis hash should be %s, not %s", hash.Hex(), block.Hash().Hex())
+	}
+
+	s, err := NewState(bcommon.NewTestLogger(t), filepath.Join(dir, "db"), 128, genesisFile, nil, DefaultTxPoolConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	if s.GetGenesisHash() != block.Hash() || s.CurrentBlock().Hash() != block.Hash() {
+		t.Fatalf("Genesis block should be %s, not %s", block.Hash().Hex(), s.GetGenesisHash().Hex())
+	}
+	if gasLimit := s.CurrentBlock().GasLimit(); gasLimit != 4700000 {
+		t.Fatalf("Genesis gas limit should be 4700000, not %d", gasLimit)
+	}
+	addr := common.HexToAddress("d93535fcd9f7f119e9b741642a8e1353355de90a")
+	if nonce := s.GetNonce(addr); nonce != 3 {
+		t.Fatalf("Nonce should be 3, not %d", nonce)
+	}
+	if code := s.ethState.GetCode(addr); !bytes.Equal(code, common.FromHex("6001600055")) {
+		t.Fatalf("Code should be 6001600055, not %x", code)
+	}
+	if value := s.ethState.GetState(addr, common.BigToHash(big.NewInt(2))); value != common.BigToHash(big.NewInt(42)) {
+		t.Fatalf("Storage slot 2 should be 42, not %s", value.Hex())
+	}
+
+	// Numbers can also be JSON numbers
+	for _, c := range []string{`{"timestamp": 1548368687, "alloc": {}}`, `{"timestamp": "0x5c4a3b2f", "alloc": {}}`} {
+		g, err := ParseGenesis([]byte(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h, _ := g.header(); h.Time.Uint64() != 1548368687 {
+			t.Fatalf("Timestamp of %s should be 1548368687, not %v", c, h.Time)
+		}
+	}
+
+	// Invalid genesis files are rejected
+	invalid := []string{
+		`{"alloc": {"0xb0b": {"balance": "1"}}}`,
+		`{"alloc": {"0x59d6e09fde8bf65183ddd1e0ca06f3d618c44c57": {"balance": "-1"}}}`,
+		`{"alloc": {"0x59d6e09fde8bf65183ddd1e0ca06f3d618c44c57": {"balance": "1"},
+			"0x59D6E09FDE8BF65183DDD1E0CA06F3D618C44C57": {"balance": "2"}}}`,
+		`{"alloc": {"0x59d6e09fde8bf65183ddd1e0ca06f3d618c44c57": {"code": "0xzz"}}}`,
+		`{"number": "0x1", "alloc": {}}`,
+		`{"gasLimit": "0x100", "alloc": {}}`,
+		`{"timestamp": "soon", "alloc": {}}`,
+		`{"alloc": [`,
+	}
+	for _, c := range invalid {
+		if _, err := ParseGenesis([]byte(c)); err == nil {
+			t.Fatalf("Genesis should be invalid: %s", c)
+		}
+	}
+}
+
+func TestBlockGasLimit(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-gaslimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	account := NewTestAccount(t)
+	to := common.HexToAddress("b0b")
+
+	s := account.NewState(t, dir, `"gasLimit": "100000"`)
+	defer s.db.Close()
+
+	if gasLimit := s.GetGasLimit(); gasLimit != 100000 {
+		t.Fatalf("Block gas limit should be 100000, not %d", gasLimit)
+	}
+
+	transfer := func(nonce, gas uint64) *ethTypes.Transaction {
+		tx, err := ethTypes.SignTx(
+			ethTypes.NewTransaction(nonce, to, big.NewInt(1), gas, _defaultGasPrice, nil),
+			s.GetSigner(),
+			account.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	deliver := func(tx *ethTypes.Transaction) error {
+		data, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ApplyTransaction(data)
+	}
+
+	// Transactions that want more gas than a block has are not admitted
+	err = s.CheckTx(transfer(0, 100001))
+	if txErr, ok := err.(*TxError); !ok || txErr.Code != CodeGasLimit {
+		t.Fatalf("Transaction should exceed the block gas limit, not %v", err)
+	}
+
+	// The pending transactions of the TxPool can fill several blocks
+	for nonce := uint64(0); nonce < 5; nonce++ {
+		if err := s.CheckTx(transfer(nonce, 40000)); err != nil {
+			t.Fatalf("Transaction %d should be admitted: %v", nonce, err)
+		}
+	}
+
+	// A block holds the transactions whose gas fits in what the previous ones
+	// left: each of them uses 21000, but needs 40000
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := deliver(transfer(nonce, 40000)); err != nil {
+			t.Fatalf("Transaction %d should be applied: %v", nonce, err)
+		}
+	}
+	err = deliver(transfer(3, 40000))
+	if txErr, ok := err.(*TxError); !ok || txErr.Code != CodeGasLimit {
+		t.Fatalf("Transaction should not fit in the block, not %v", err)
+	}
+	if _, err := s.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	block := s.CurrentBlock()
+	if len(block.Transactions()) != 3 || block.GasUsed() != 3*params.TxGas || block.GasLimit() != 100000 {
+		t.Fatalf("Block should have 3 transactions, use %d gas of 100000, not %d transactions, %d gas of %d",
+			3*params.TxGas, len(block.Transactions()), block.GasUsed(), block.GasLimit())
+	}
+
+	// The next block has the same gas limit, and room for the transaction
+	if err := deliver(transfer(3, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Commit(2); err != nil {
+		t.Fatal(err)
+	}
+	if block := s.CurrentBlock(); block.GasUsed() != params.TxGas || block.GasLimit() != 100000 {
+		t.Fatalf("Block should use %d gas of 100000, not %d of %d", params.TxGas, block.GasUsed(), block.GasLimit())
+	}
+}
+
+//failingDB is a database whose reads fail
+type failingDB struct{}
+
+func (failingDB) Get(key []byte) ([]byte, error) {
+	return nil, errors.New("corrupted")
+}
+
+func TestReadAccessors(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "evml-head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := ethdb.NewLDBDatabase(filepath.Join(dir, "db"), 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A database that was never committed to has no head
+	head, err := readHead(db)
+	if err != nil || head != nil {
+		t.Fatalf("Empty database should have no head, not %v (%v)", head, err)
+	}
+
+	// Failed reads are not mistaken for a missing head
+	if _, err := readHead(failingDB{}); err == nil {
+		t.Fatal("Failed read should return an error")
+	}
+
+	// Nor for a transaction that did not fail
+	execErr, err := readExecutionError(db, common.Hash{})
+	if err != nil || execErr != nil {
+		t.Fatalf("Unknown transaction should have no error, not %v (%v)", execErr, err)
+	}
+	if _, err := readExecutionError(failingDB{}, common.Hash{}); err == nil {
+		t.Fatal("Failed read should return an error")
+	}
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bcommon "github.com/bear987978897/evm-lite/src/common"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//TestAccount is an account funded by the genesis of test States. It is shared
+//by the tests of the packages built on the State.
+type TestAccount struct {
+	Key     *ecdsa.PrivateKey
+	Address common.Address
+}
+
+//NewTestAccount generates the key of a new TestAccount
+func NewTestAccount(t *testing.T) *TestAccount {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TestAccount{Key: key, Address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+//WriteGenesis writes a genesis file whose alloc gives the account 1337 ether.
+//fields are extra members of the genesis object, like `"gasLimit": "100000"`.
+func (a *TestAccount) WriteGenesis(t *testing.T, file string, fields string) {
+	if fields != "" {
+		fields += ", "
+	}
+	genesis := fmt.Sprintf(`{%s"alloc": {"%x": {"balance": "1337000000000000000000"}}}`, fields, a.Address)
+	if err := ioutil.WriteFile(file, []byte(genesis), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//NewState opens a State in dir, on a genesis written there with the extra
+//fields. It logs to t.
+func (a *TestAccount) NewState(t *testing.T, dir string, fields string) *State {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	genesisFile := filepath.Join(dir, "genesis.json")
+	a.WriteGenesis(t, genesisFile, fields)
+
+	s, err := NewState(bcommon.NewTestLogger(t),
+		filepath.Join(dir, "db"),
+		16,
+		genesisFile,
+		nil,
+		DefaultTxPoolConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"sort"
+
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// txList is the set of transactions of a single sender, indexed by nonce
+type txList struct {
+	txs map[uint64]*ethTypes.Transaction
+}
+
+func newTxList() *txList {
+	return &txList{txs: make(map[uint64]*ethTypes.Transaction)}
+}
+
+func (l *txList) Len() int {
+	return len(l.txs)
+}
+
+func (l *txList) Get(nonce uint64) *ethTypes.Transaction {
+	return l.txs[nonce]
+}
+
+// Put inserts a transaction, replacing any transaction with the same nonce
+func (l *txList) Put(tx *ethTypes.Transaction) {
+	l.txs[tx.Nonce()] = tx
+}
+
+func (l *txList) Remove(nonce uint64) {
+	delete(l.txs, nonce)
+}
+
+// Forward removes and returns, in nonce order, the transactions with a nonce
+// lower than threshold.
+func (l *txList) Forward(threshold uint64) []*ethTypes.Transaction {
+	var removed []*ethTypes.Transaction
+	for _, tx := range l.Flatten() {
+		if tx.Nonce() >= threshold {
+			break
+		}
+		removed = append(removed, tx)
+		delete(l.txs, tx.Nonce())
+	}
+	return removed
+}
+
+// Cap removes and returns, in nonce order, the transactions with a nonce
+// greater than or equal to threshold.
+func (l *txList) Cap(threshold uint64) []*ethTypes.Transaction {
+	var removed []*ethTypes.Transaction
+	for _, tx := range l.Flatten() {
+		if tx.Nonce() >= threshold {
+			removed = append(removed, tx)
+			delete(l.txs, tx.Nonce())
+		}
+	}
+	return removed
+}
+
+// Last returns the transaction with the highest nonce
+func (l *txList) Last() *ethTypes.Transaction {
+	var last *ethTypes.Transaction
+	for _, tx := range l.txs {
+		if last == nil || tx.Nonce() > last.Nonce() {
+			last = tx
+		}
+	}
+	return last
+}
+
+// Flatten returns the transactions sorted by nonce
+func (l *txList) Flatten() []*ethTypes.Transaction {
+	txs := make([]*ethTypes.Transaction, 0, len(l.txs))
+	for _, tx := range l.txs {
+		txs = append(txs, tx)
+	}
+	sort.Sort(ethTypes.TxByNonce(txs))
+	return txs
+}
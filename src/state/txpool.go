@@ -1,94 +1,619 @@
 package state
 
 import (
+	"bytes"
+	"fmt"
 	"math/big"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	ethState "github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/sirupsen/logrus"
 )
 
+//Codes of the errors returned by the TxPool and the WAS. They are used as ABCI
+//response codes, so 0 is reserved for accepted transactions.
+const (
+	CodeMalformedTx uint32 = iota + 1
+	CodeInvalidSender
+	CodeInvalidChainID
+	CodeNonceTooLow
+	CodeNonceTooHigh
+	CodeInsufficientFunds
+	CodeIntrinsicGas
+	CodeGasLimit
+	CodeInvalidTx
+	CodeReplaceUnderpriced
+	CodeTxPoolFull
+	CodeKnownTx
+	//CodeExecutionFailed is not a TxError. It is the code of transactions that
+	//were applied, but whose execution failed.
+	CodeExecutionFailed
+)
+
+//TxError is the reason why a transaction was not admitted to the TxPool
+type TxError struct {
+	Code    uint32 `json:"code"`
+	Message string `json:"error"`
+}
+
+func newTxError(code uint32, format string, args ...interface{}) *TxError {
+	return &TxError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *TxError) Error() string {
+	return e.Message
+}
+
+//DecodeTx decodes an RLP encoded transaction. It returns a TxError with
+//CodeMalformedTx if the data is not a valid transaction.
+func DecodeTx(data []byte) (*ethTypes.Transaction, error) {
+	var tx ethTypes.Transaction
+	if err := rlp.Decode(bytes.NewReader(data), &tx); err != nil {
+		return nil, newTxError(CodeMalformedTx, "malformed transaction: %v", err)
+	}
+	return &tx, nil
+}
+
+//TxStatus is the status of a transaction submitted to this node
+type TxStatus string
+
+const (
+	TxStatusUnknown  TxStatus = "unknown"
+	TxStatusPending  TxStatus = "pending"
+	TxStatusQueued   TxStatus = "queued"
+	TxStatusIncluded TxStatus = "included"
+	TxStatusDropped  TxStatus = "dropped"
+)
+
+//maxDropped is the number of dropped transactions that the TxPool remembers
+const maxDropped = 4096
+
+//------------------------------------------------------------------------------
+
+//TxPoolConfig are the limits of the TxPool
+type TxPoolConfig struct {
+	PriceBump    uint64 //Minimum price bump (%) to replace a queued transaction
+	AccountSlots uint64 //Maximum number of pending transactions per account
+	GlobalSlots  uint64 //Maximum number of pending transactions
+	AccountQueue uint64 //Maximum number of queued transactions per account
+	GlobalQueue  uint64 //Maximum number of queued transactions
+}
+
+//DefaultTxPoolConfig returns the default limits of the TxPool
+func DefaultTxPoolConfig() TxPoolConfig {
+	return TxPoolConfig{
+		PriceBump:    10,
+		AccountSlots: 1024,
+		GlobalSlots:  16384,
+		AccountQueue: 64,
+		GlobalQueue:  1024,
+	}
+}
+
+//TxPool holds the transactions that were admitted by this node but are not
+//yet part of a block. Pending transactions have been forwarded to the
+//consensus system; they are applied to the pool's statedb, in nonce order, so
+//that the following transactions are checked against the resulting nonces and
+//balances. Queued transactions have a nonce gap and wait for the missing
+//transactions; they are promoted to pending as soon as the gap is filled.
 type TxPool struct {
+	sync.Mutex
+
 	ethState *ethState.StateDB
+	parent   *ethTypes.Header
+	header   *ethTypes.Header
+	getHash  vm.GetHashFunc
 
-	signer       ethTypes.Signer
-	chainConfig  params.ChainConfig // vm.env is still tightly coupled with chainConfig
-	vmConfig     vm.Config
-	gasLimit     uint64
-	totalUsedGas uint64
-	gp           *core.GasPool
+	signer      ethTypes.Signer
+	chainConfig params.ChainConfig // vm.env is still tightly coupled with chainConfig
+	vmConfig    vm.Config
+	gasLimit    uint64
+
+	config       TxPoolConfig
+	pending      map[common.Address]*txList
+	queue        map[common.Address]*txList
+	all          map[common.Hash]*ethTypes.Transaction
+	pendingCount uint64
+	queueCount   uint64
+
+	//reasons why the last maxDropped transactions were dropped, and their
+	//hashes, oldest first
+	dropped      map[common.Hash]string
+	droppedOrder []common.Hash
+
+	txFeed event.Feed
 
 	logger *logrus.Logger
 }
 
 func NewTxPool(ethState *ethState.StateDB,
+	parent *ethTypes.Header,
+	getHash vm.GetHashFunc,
 	signer ethTypes.Signer,
 	chainConfig params.ChainConfig,
 	vmConfig vm.Config,
 	gasLimit uint64,
+	config TxPoolConfig,
 	logger *logrus.Logger) *TxPool {
 
 	return &TxPool{
 		ethState:    ethState,
+		parent:      parent,
+		header:      newHeader(parent, gasLimit),
+		getHash:     getHash,
 		signer:      signer,
 		chainConfig: chainConfig,
 		vmConfig:    vmConfig,
 		gasLimit:    gasLimit,
+		config:      config,
+		pending:     make(map[common.Address]*txList),
+		queue:       make(map[common.Address]*txList),
+		all:         make(map[common.Hash]*ethTypes.Transaction),
+		dropped:     make(map[common.Hash]string),
 		logger:      logger,
 	}
 }
 
-func (p *TxPool) Reset(root common.Hash) error {
+//Reset rebases the pool on a new block. Pending transactions included in the
+//block are removed, and the others are applied again on top of it; those that
+//no longer apply are dropped along with the following transactions of the
+//same sender. Queued transactions that became executable are promoted.
+func (p *TxPool) Reset(parent *ethTypes.Header) error {
+	p.Lock()
+	defer p.Unlock()
 
-	err := p.ethState.Reset(root)
+	return p.reset(parent)
+}
+
+func (p *TxPool) reset(parent *ethTypes.Header) error {
+	err := p.ethState.Reset(parent.Root)
 	if err != nil {
 		return err
 	}
 
-	p.totalUsedGas = 0
-	p.gp = new(core.GasPool).AddGas(p.gasLimit)
+	p.parent = parent
+	p.header = newHeader(parent, p.gasLimit)
+
+	for from, list := range p.pending {
+		for _, tx := range list.Forward(p.ethState.GetNonce(from)) {
+			p.removePending(tx)
+		}
+		for _, tx := range list.Flatten() {
+			if err := p.execute(tx); err != nil {
+				for _, tx := range list.Cap(tx.Nonce()) {
+					p.removePending(tx)
+					p.drop(tx, err.Error())
+				}
+				break
+			}
+		}
+		if list.Len() == 0 {
+			delete(p.pending, from)
+		}
+	}
+
+	var promoted []*ethTypes.Transaction
+	for from := range p.queue {
+		promoted = append(promoted, p.promote(from)...)
+	}
+	if len(promoted) > 0 {
+		p.txFeed.Send(NewTxsEvent{Txs: promoted})
+	}
 
 	return nil
 }
 
+//Add admits a transaction submitted to this node. A transaction with the
+//sender's next nonce becomes pending, along with the queued transactions that
+//follow it, and they are posted in nonce order as a NewTxsEvent, to be
+//forwarded to the consensus system. A transaction with a higher nonce is
+//queued, replacing any queued transaction with the same nonce if its gas price
+//is at least PriceBump percent higher. Rejected transactions leave the pool
+//unchanged and are reported with a *TxError.
+func (p *TxPool) Add(tx *ethTypes.Transaction) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.all[tx.Hash()] != nil {
+		return newTxError(CodeKnownTx, "known transaction: %s", tx.Hash().Hex())
+	}
+
+	from, err := p.validateTx(tx)
+	if err != nil {
+		return err
+	}
+
+	if tx.Nonce() > p.ethState.GetNonce(from) {
+		return p.enqueue(from, tx)
+	}
+
+	if err := p.addPending(from, tx); err != nil {
+		return err
+	}
+
+	p.txFeed.Send(NewTxsEvent{Txs: append([]*ethTypes.Transaction{tx}, p.promote(from)...)})
+
+	return nil
+}
+
+//CheckTx admits a transaction that the consensus system already has, like
+//the transactions of Tendermint's mempool. The transaction must carry the
+//sender's next nonce; it becomes pending without being posted. Pending
+//transactions pass CheckTx again. Rejected transactions leave the pool
+//unchanged and are reported with a *TxError.
 func (p *TxPool) CheckTx(tx *ethTypes.Transaction) error {
+	p.Lock()
+	defer p.Unlock()
 
-	msg, err := tx.AsMessage(p.signer)
+	from, err := p.validateTx(tx)
+	if p.isPending(from, tx) {
+		return nil
+	}
 	if err != nil {
-		p.logger.WithError(err).Error("Converting Transaction to Message")
 		return err
 	}
 
-	context := vm.Context{
-		CanTransfer: core.CanTransfer,
-		Transfer:    core.Transfer,
-		GetHash:     func(uint64) common.Hash { return common.Hash{} },
-		// Message information
-		Origin:      msg.From(),
-		GasLimit:    msg.Gas(),
-		GasPrice:    msg.GasPrice(),
-		BlockNumber: big.NewInt(0), // The vm has a dependency on this.
+	if nonce := p.ethState.GetNonce(from); tx.Nonce() > nonce {
+		return newTxError(CodeNonceTooHigh,
+			"nonce too high: have %d, want %d", tx.Nonce(), nonce)
+	}
+
+	if err := p.addPending(from, tx); err != nil {
+		return err
+	}
+
+	//A queued transaction with the same nonce can no longer be executed
+	if list := p.queue[from]; list != nil {
+		for _, old := range list.Forward(tx.Nonce() + 1) {
+			p.removeQueued(from, old)
+			p.drop(old, "nonce too low")
+		}
+	}
+
+	if promoted := p.promote(from); len(promoted) > 0 {
+		p.txFeed.Send(NewTxsEvent{Txs: promoted})
+	}
+
+	return nil
+}
+
+//Drop removes a transaction that the consensus system did not accept. A
+//pending transaction is dropped along with the following pending transactions
+//of the same sender, and the remaining pending transactions are applied again.
+//Transactions that are not in the pool are ignored.
+func (p *TxPool) Drop(hash common.Hash, reason string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	tx := p.all[hash]
+	if tx == nil {
+		return nil
+	}
+
+	from, _ := ethTypes.Sender(p.signer, tx)
+	if !p.isPending(from, tx) {
+		p.removeQueued(from, tx)
+		p.drop(tx, reason)
+		return nil
+	}
+
+	list := p.pending[from]
+	for _, tx := range list.Cap(tx.Nonce()) {
+		p.removePending(tx)
+		p.drop(tx, reason)
 	}
+	if list.Len() == 0 {
+		delete(p.pending, from)
+	}
+
+	return p.reset(p.parent)
+}
+
+func (p *TxPool) GetNonce(addr common.Address) uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.ethState.GetNonce(addr)
+}
+
+//SubscribeNewTxsEvent registers a subscription of NewTxsEvent. Events are
+//posted while the pool is locked, so subscribers must drain ch promptly.
+func (p *TxPool) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription {
+	return p.txFeed.Subscribe(ch)
+}
+
+//Content returns the pending and queued transactions, grouped by sender and
+//sorted by nonce.
+func (p *TxPool) Content() (map[common.Address][]*ethTypes.Transaction,
+	map[common.Address][]*ethTypes.Transaction) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	pending := make(map[common.Address][]*ethTypes.Transaction)
+	for from, list := range p.pending {
+		pending[from] = list.Flatten()
+	}
+	queued := make(map[common.Address][]*ethTypes.Transaction)
+	for from, list := range p.queue {
+		queued[from] = list.Flatten()
+	}
+
+	return pending, queued
+}
+
+//Stats returns the number of pending and queued transactions
+func (p *TxPool) Stats() (int, int) {
+	p.Lock()
+	defer p.Unlock()
+
+	return int(p.pendingCount), int(p.queueCount)
+}
+
+//Get returns a pending or queued transaction and its status. Dropped
+//transactions are returned as nil, along with the reason why they were
+//dropped. Other transactions are TxStatusUnknown to the pool.
+func (p *TxPool) Get(hash common.Hash) (*ethTypes.Transaction, TxStatus, string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if tx := p.all[hash]; tx != nil {
+		from, _ := ethTypes.Sender(p.signer, tx)
+		if p.isPending(from, tx) {
+			return tx, TxStatusPending, ""
+		}
+		return tx, TxStatusQueued, ""
+	}
+	if reason, ok := p.dropped[hash]; ok {
+		return nil, TxStatusDropped, reason
+	}
+	return nil, TxStatusUnknown, ""
+}
+
+//------------------------------------------------------------------------------
+
+//validateTx performs the checks that do not depend on the pool's content:
+//chain ID, signature, gas limits, nonce and balance. It returns the sender.
+func (p *TxPool) validateTx(tx *ethTypes.Transaction) (common.Address, error) {
+
+	if tx.Protected() && tx.ChainId().Cmp(p.chainConfig.ChainID) != 0 {
+		return common.Address{}, newTxError(CodeInvalidChainID,
+			"invalid chain id: have %v, want %v", tx.ChainId(), p.chainConfig.ChainID)
+	}
+
+	from, err := ethTypes.Sender(p.signer, tx)
+	if err != nil {
+		return common.Address{}, newTxError(CodeInvalidSender, "invalid sender: %v", err)
+	}
+
+	if tx.Gas() > p.header.GasLimit {
+		return from, newTxError(CodeGasLimit,
+			"exceeds block gas limit: have %d, max %d", tx.Gas(), p.header.GasLimit)
+	}
+
+	intrinsicGas, err := core.IntrinsicGas(tx.Data(),
+		tx.To() == nil,
+		p.chainConfig.IsHomestead(p.header.Number))
+	if err != nil {
+		return from, newTxError(CodeIntrinsicGas, "%v", err)
+	}
+	if tx.Gas() < intrinsicGas {
+		return from, newTxError(CodeIntrinsicGas,
+			"intrinsic gas too low: have %d, want %d", tx.Gas(), intrinsicGas)
+	}
+
+	nonce := p.ethState.GetNonce(from)
+	if tx.Nonce() < nonce {
+		return from, newTxError(CodeNonceTooLow,
+			"nonce too low: have %d, want %d", tx.Nonce(), nonce)
+	}
+
+	balance := p.ethState.GetBalance(from)
+	if balance.Cmp(tx.Cost()) < 0 {
+		return from, newTxError(CodeInsufficientFunds,
+			"insufficient funds for gas * price + value: have %v, want %v", balance, tx.Cost())
+	}
+
+	return from, nil
+}
+
+//execute applies a transaction to the pool's statedb. The statedb is left
+//unchanged if the transaction cannot be applied.
+func (p *TxPool) execute(tx *ethTypes.Transaction) error {
+
+	msg, err := tx.AsMessage(p.signer)
+	if err != nil {
+		return newTxError(CodeInvalidSender, "invalid sender: %v", err)
+	}
+
+	context := newContext(p.header, p.getHash, msg.From(), msg.GasPrice())
 
 	// The EVM should never be reused and is not thread safe.
 	vmenv := vm.NewEVM(context, p.ethState, &p.chainConfig, p.vmConfig)
 
-	// Apply the transaction to the current state (included in the env)
-	_, gas, _, err := core.ApplyMessage(vmenv, msg, p.gp)
+	// Apply the transaction to the current state (included in the env). Pending
+	// transactions can span several blocks, so each one is only bounded by the
+	// block gas limit, which validateTx checked.
+	snapshot := p.ethState.Snapshot()
+	_, _, _, err = core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(p.header.GasLimit))
 	if err != nil {
+		p.ethState.RevertToSnapshot(snapshot)
 		p.logger.WithError(err).Error("Applying transaction to TxPool")
+		return newTxError(CodeInvalidTx, "%v", err)
+	}
+
+	return nil
+}
+
+//addPending applies a transaction with the sender's next nonce and adds it to
+//the pending transactions.
+func (p *TxPool) addPending(from common.Address, tx *ethTypes.Transaction) error {
+
+	if err := p.checkPendingSlots(from); err != nil {
 		return err
 	}
 
-	p.totalUsedGas += gas
+	if err := p.execute(tx); err != nil {
+		return err
+	}
+
+	list := p.pending[from]
+	if list == nil {
+		list = newTxList()
+		p.pending[from] = list
+	}
+	list.Put(tx)
+	p.all[tx.Hash()] = tx
+	p.pendingCount++
+
+	return nil
+}
 
+func (p *TxPool) checkPendingSlots(from common.Address) error {
+	if list := p.pending[from]; list != nil && uint64(list.Len()) >= p.config.AccountSlots {
+		return newTxError(CodeTxPoolFull,
+			"txpool is full: %d pending transactions from %s", list.Len(), from.Hex())
+	}
+	if p.pendingCount >= p.config.GlobalSlots {
+		return newTxError(CodeTxPoolFull,
+			"txpool is full: %d pending transactions", p.pendingCount)
+	}
 	return nil
 }
 
-func (p *TxPool) GetNonce(addr common.Address) uint64 {
-	return p.ethState.GetNonce(addr)
+func (p *TxPool) isPending(from common.Address, tx *ethTypes.Transaction) bool {
+	list := p.pending[from]
+	return list != nil && list.Get(tx.Nonce()) != nil && list.Get(tx.Nonce()).Hash() == tx.Hash()
+}
+
+//enqueue adds a transaction with a nonce gap to the queue
+func (p *TxPool) enqueue(from common.Address, tx *ethTypes.Transaction) error {
+
+	list := p.queue[from]
+	if list == nil {
+		list = newTxList()
+	}
+
+	if old := list.Get(tx.Nonce()); old != nil {
+		threshold := new(big.Int).Mul(old.GasPrice(), big.NewInt(int64(100+p.config.PriceBump)))
+		threshold.Div(threshold, big.NewInt(100))
+		if tx.GasPrice().Cmp(old.GasPrice()) <= 0 || tx.GasPrice().Cmp(threshold) < 0 {
+			return newTxError(CodeReplaceUnderpriced,
+				"replacement transaction underpriced: have %v, want %v", tx.GasPrice(), threshold)
+		}
+		delete(p.all, old.Hash())
+		p.drop(old, "replaced by "+tx.Hash().Hex())
+		list.Put(tx)
+		p.all[tx.Hash()] = tx
+		return nil
+	}
+
+	if uint64(list.Len()) >= p.config.AccountQueue {
+		return newTxError(CodeTxPoolFull,
+			"txpool is full: %d queued transactions from %s", list.Len(), from.Hex())
+	}
+	if p.queueCount >= p.config.GlobalQueue && !p.evictQueued(tx.GasPrice()) {
+		return newTxError(CodeTxPoolFull,
+			"txpool is full: %d queued transactions", p.queueCount)
+	}
+
+	list.Put(tx)
+	p.queue[from] = list
+	p.all[tx.Hash()] = tx
+	p.queueCount++
+
+	return nil
+}
+
+//evictQueued makes room in the queue by dropping the cheapest transaction
+//among the last queued transaction of every sender, so that no gap is created
+//in the queues. It reports false if all of them pay at least price.
+func (p *TxPool) evictQueued(price *big.Int) bool {
+	var (
+		cheapest *ethTypes.Transaction
+		sender   common.Address
+	)
+	for from, list := range p.queue {
+		if last := list.Last(); cheapest == nil || last.GasPrice().Cmp(cheapest.GasPrice()) < 0 {
+			cheapest, sender = last, from
+		}
+	}
+	if cheapest == nil || cheapest.GasPrice().Cmp(price) >= 0 {
+		return false
+	}
+
+	p.removeQueued(sender, cheapest)
+	p.drop(cheapest, "evicted from full txpool")
+
+	return true
+}
+
+//promote moves the queued transactions of a sender that follow its pending
+//transactions without a gap to pending. It drops the queued transactions whose
+//nonce is too low or that cannot be applied, and returns the promoted ones in
+//nonce order.
+func (p *TxPool) promote(from common.Address) []*ethTypes.Transaction {
+	list := p.queue[from]
+	if list == nil {
+		return nil
+	}
+
+	for _, tx := range list.Forward(p.ethState.GetNonce(from)) {
+		p.removeQueued(from, tx)
+		p.drop(tx, "nonce too low")
+	}
+
+	var promoted []*ethTypes.Transaction
+	for {
+		tx := list.Get(p.ethState.GetNonce(from))
+		if tx == nil || p.checkPendingSlots(from) != nil {
+			break
+		}
+		p.removeQueued(from, tx)
+		if err := p.addPending(from, tx); err != nil {
+			p.drop(tx, err.Error())
+			break
+		}
+		promoted = append(promoted, tx)
+	}
+
+	return promoted
+}
+
+func (p *TxPool) removeQueued(from common.Address, tx *ethTypes.Transaction) {
+	list := p.queue[from]
+	list.Remove(tx.Nonce())
+	if list.Len() == 0 {
+		delete(p.queue, from)
+	}
+	delete(p.all, tx.Hash())
+	p.queueCount--
+}
+
+//removePending forgets a transaction that was taken out of its pending list
+func (p *TxPool) removePending(tx *ethTypes.Transaction) {
+	delete(p.all, tx.Hash())
+	p.pendingCount--
+}
+
+//drop records that a transaction left the pool without being included
+func (p *TxPool) drop(tx *ethTypes.Transaction, reason string) {
+	p.logger.WithFields(logrus.Fields{
+		"hash":   tx.Hash().Hex(),
+		"reason": reason,
+	}).Debug("Dropped transaction")
+
+	if len(p.droppedOrder) == maxDropped {
+		delete(p.dropped, p.droppedOrder[0])
+		p.droppedOrder = p.droppedOrder[1:]
+	}
+	p.dropped[tx.Hash()] = reason
+	p.droppedOrder = append(p.droppedOrder, tx.Hash())
 }
@@ -5,19 +5,20 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	ethState "github.com/ethereum/go-ethereum/core/state"
 	ethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/sirupsen/logrus"
 )
 
 // WriteAheadState is a wrapper around a DB and StateDB object that applies
 // transactions to the StateDB and only commits them to the DB upon Commit. It
-// also handles persisting transactions, logs, and receipts to the DB.
+// builds a block out of the applied transactions and handles persisting the
+// block, its transactions, logs, and receipts to the DB.
 // NOT THREAD SAFE
 type WriteAheadState struct {
 	db       ethdb.Database
@@ -28,10 +29,12 @@ type WriteAheadState struct {
 	vmConfig    vm.Config
 	gasLimit    uint64
 
+	header       *ethTypes.Header
 	txIndex      int
 	transactions []*ethTypes.Transaction
 	receipts     []*ethTypes.Receipt
 	allLogs      []*ethTypes.Log
+	execErrors   map[common.Hash]*ExecutionError
 
 	totalUsedGas uint64
 	gp           *core.GasPool
@@ -39,19 +42,28 @@ type WriteAheadState struct {
 	logger *logrus.Logger
 }
 
+// NewWriteAheadState creates a WriteAheadState that builds a block on top of
+// parent. A nil parent means that the next block is the genesis block.
 func NewWriteAheadState(db ethdb.Database,
-	root common.Hash,
+	parent *ethTypes.Header,
 	signer ethTypes.Signer,
 	chainConfig params.ChainConfig,
 	vmConfig vm.Config,
 	gasLimit uint64,
 	logger *logrus.Logger) (*WriteAheadState, error) {
 
+	root := common.Hash{}
+	if parent != nil {
+		root = parent.Root
+	}
+
 	ethState, err := ethState.New(root, ethState.NewDatabase(db))
 	if err != nil {
 		return nil, err
 	}
 
+	header := newHeader(parent, gasLimit)
+
 	return &WriteAheadState{
 		db:          db,
 		ethState:    ethState,
@@ -59,66 +71,116 @@ func NewWriteAheadState(db ethdb.Database,
 		chainConfig: chainConfig,
 		vmConfig:    vmConfig,
 		gasLimit:    gasLimit,
-		gp:          new(core.GasPool).AddGas(gasLimit),
+		header:      header,
+		execErrors:  make(map[common.Hash]*ExecutionError),
+		gp:          new(core.GasPool).AddGas(header.GasLimit),
 		logger:      logger,
 	}, nil
 }
 
-func (was *WriteAheadState) Reset(root common.Hash) error {
+// Reset discards all pending changes and prepares a new block on top of parent
+func (was *WriteAheadState) Reset(parent *ethTypes.Header) error {
 
-	err := was.ethState.Reset(root)
+	err := was.ethState.Reset(parent.Root)
 	if err != nil {
 		return err
 	}
 
+	was.header = newHeader(parent, was.gasLimit)
 	was.txIndex = 0
 	was.transactions = []*ethTypes.Transaction{}
 	was.receipts = []*ethTypes.Receipt{}
 	was.allLogs = []*ethTypes.Log{}
+	was.execErrors = make(map[common.Hash]*ExecutionError)
 
 	was.totalUsedGas = 0
-	was.gp = new(core.GasPool).AddGas(was.gasLimit)
+	was.gp = new(core.GasPool).AddGas(was.header.GasLimit)
 
 	return nil
 }
 
-func (was *WriteAheadState) ApplyTransaction(tx ethTypes.Transaction, txIndex int, blockHash common.Hash) error {
+// TxResult is the outcome of a transaction applied to the WAS
+type TxResult struct {
+	Tx      *ethTypes.Transaction
+	From    common.Address
+	Receipt *ethTypes.Receipt
+	// Return is the data returned by the execution: the output of a call, or
+	// the data passed to REVERT. Contract creations return the code of the
+	// contract.
+	Return []byte
+	// Error is nil if the execution succeeded
+	Error *ExecutionError
+}
+
+// SetTime sets the timestamp (in seconds) of the block being built
+func (was *WriteAheadState) SetTime(time uint64) {
+	was.header.Time = new(big.Int).SetUint64(time)
+}
+
+// Context returns the EVM context for executing a message from origin in the
+// block being built
+func (was *WriteAheadState) Context(origin common.Address, gasPrice *big.Int) vm.Context {
+	return newContext(was.header, getHashFn(was.db), origin, gasPrice)
+}
+
+// ApplyTransaction applies a transaction to the StateDB and records its
+// receipt. Transactions that cannot be applied, like those with a wrong nonce,
+// are rejected with a TxError and leave the state unchanged. Transactions whose
+// execution fails are applied nonetheless, and their result carries the
+// ExecutionError.
+func (was *WriteAheadState) ApplyTransaction(tx ethTypes.Transaction) (*TxResult, error) {
 
 	msg, err := tx.AsMessage(was.signer)
 	if err != nil {
 		was.logger.WithError(err).Error("Converting Transaction to Message")
-		return err
+		return nil, newTxError(CodeInvalidSender, "invalid sender: %v", err)
 	}
 
-	context := vm.Context{
-		CanTransfer: core.CanTransfer,
-		Transfer:    core.Transfer,
-		GetHash:     func(uint64) common.Hash { return blockHash },
-		Origin:      msg.From(),
-		GasLimit:    msg.Gas(),
-		GasPrice:    msg.GasPrice(),
-		BlockNumber: big.NewInt(0), // The vm has a dependency on this..
-	}
+	context := was.Context(msg.From(), msg.GasPrice())
 
 	//Prepare the ethState with transaction Hash so that it can be used in emitted
-	//logs
-	was.ethState.Prepare(tx.Hash(), blockHash, txIndex)
+	//logs. The block hash is only known upon Commit.
+	was.ethState.Prepare(tx.Hash(), common.Hash{}, was.txIndex)
 
-	vmenv := vm.NewEVM(context, was.ethState, &was.chainConfig, was.vmConfig)
+	//The tracer tells why the transaction failed, if it does
+	tracer := &errorTracer{}
+	vmenv := vm.NewEVM(context, was.ethState, &was.chainConfig, tracedConfig(was.vmConfig, tracer))
 
 	// Apply the transaction to the current state (included in the env)
-	_, gas, failed, err := core.ApplyMessage(vmenv, msg, was.gp)
+	res, gas, failed, err := core.ApplyMessage(vmenv, msg, was.gp)
 	if err != nil {
 		was.logger.WithError(err).Error("Applying transaction to WAS")
-		return err
+		switch err {
+		case core.ErrGasLimitReached:
+			return nil, newTxError(CodeGasLimit, "%v: %d available", err, was.gp.Gas())
+		case core.ErrNonceTooLow:
+			return nil, newTxError(CodeNonceTooLow, "%v", err)
+		case core.ErrNonceTooHigh:
+			return nil, newTxError(CodeNonceTooHigh, "%v", err)
+		}
+		return nil, newTxError(CodeInvalidTx, "%v", err)
+	}
+
+	var execErr *ExecutionError
+	if failed {
+		execErr = newExecutionError(tracer.err, res)
+		was.execErrors[tx.Hash()] = execErr
+		was.logger.WithError(execErr).WithField("hash", tx.Hash().Hex()).Debug("Transaction failed")
 	}
 
 	was.totalUsedGas += gas
 
 	// Create a new receipt for the transaction, storing the intermediate root and gas used by the tx
 	// based on the eip phase, we're passing wether the root touch-delete accounts.
-	root := was.ethState.IntermediateRoot(true) //this has side effects. It updates StateObjects (SmartContract memory)
-	receipt := ethTypes.NewReceipt(root.Bytes(), failed, was.totalUsedGas)
+	// From Byzantium, receipts carry the status of the transaction instead of
+	// the intermediate root.
+	var root []byte
+	if was.chainConfig.IsByzantium(was.header.Number) {
+		was.ethState.Finalise(was.chainConfig.IsEIP158(was.header.Number))
+	} else {
+		root = was.ethState.IntermediateRoot(was.chainConfig.IsEIP158(was.header.Number)).Bytes() //this has side effects. It updates StateObjects (SmartContract memory)
+	}
+	receipt := ethTypes.NewReceipt(root, failed, was.totalUsedGas)
 	receipt.TxHash = tx.Hash()
 	receipt.GasUsed = gas
 	// if the transaction created a contract, store the creation address in the receipt.
@@ -137,62 +199,64 @@ func (was *WriteAheadState) ApplyTransaction(tx ethTypes.Transaction, txIndex in
 
 	was.logger.WithField("hash", tx.Hash().Hex()).Debug("Applied tx to WAS")
 
-	return nil
+	return &TxResult{
+		Tx:      &tx,
+		From:    msg.From(),
+		Receipt: receipt,
+		Return:  res,
+		Error:   execErr,
+	}, nil
 }
 
-func (was *WriteAheadState) Commit() (common.Hash, error) {
+// Commit commits all state changes to the database and writes the resulting
+// block, along with its receipts and transaction lookup entries.
+func (was *WriteAheadState) Commit() (*ethTypes.Block, error) {
 	// Commit all state changes to the database
-	root, err := was.ethState.Commit(true)
+	root, err := was.ethState.Commit(was.chainConfig.IsEIP158(was.header.Number))
 	if err != nil {
 		was.logger.WithError(err).Error("Committing state")
-		return common.Hash{}, err
+		return nil, err
 	}
 
 	//XXX FORCE DISK WRITE
 	//Apparenty Geth does something smarter here... but cant figure it out
 	was.ethState.Database().TrieDB().Commit(root, true)
 
-	if err := was.writeTransactions(); err != nil {
-		was.logger.WithError(err).Error("Writing txs")
-		return common.Hash{}, err
-	}
-	if err := was.writeReceipts(); err != nil {
-		was.logger.WithError(err).Error("Writing receipts")
-		return common.Hash{}, err
-	}
-	return root, nil
-}
+	was.header.Root = root
+	was.header.GasUsed = was.totalUsedGas
+	block := ethTypes.NewBlock(was.header, was.transactions, nil, was.receipts)
 
-func (was *WriteAheadState) writeTransactions() error {
-	batch := was.db.NewBatch()
+	// Now that the block hash is known, set it in the logs
+	for _, log := range was.allLogs {
+		log.BlockHash = block.Hash()
+		log.BlockNumber = block.NumberU64()
+	}
 
-	for _, tx := range was.transactions {
-		data, err := rlp.EncodeToBytes(tx)
-		if err != nil {
-			return err
-		}
-		if err := batch.Put(tx.Hash().Bytes(), data); err != nil {
-			return err
-		}
+	if err := was.writeBlock(block); err != nil {
+		was.logger.WithError(err).Error("Writing block")
+		return nil, err
 	}
 
-	// Write the scheduled data into the database
-	return batch.Write()
+	return block, nil
 }
 
-func (was *WriteAheadState) writeReceipts() error {
+func (was *WriteAheadState) writeBlock(block *ethTypes.Block) error {
 	batch := was.db.NewBatch()
 
-	for _, receipt := range was.receipts {
-		storageReceipt := (*ethTypes.ReceiptForStorage)(receipt)
-		data, err := rlp.EncodeToBytes(storageReceipt)
-		if err != nil {
-			return err
-		}
-		if err := batch.Put(append(receiptsPrefix, receipt.TxHash.Bytes()...), data); err != nil {
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), was.receipts)
+	rawdb.WriteTxLookupEntries(batch, block)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	if err := writeMipmapBloom(was.db, batch, block.NumberU64(), was.receipts); err != nil {
+		return err
+	}
+	for txHash, execErr := range was.execErrors {
+		if err := writeExecutionError(batch, txHash, execErr); err != nil {
 			return err
 		}
 	}
 
+	// Write the scheduled data into the database
 	return batch.Write()
 }